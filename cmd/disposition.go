@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/disposition"
+	"github.com/penwern/curate-preservation-core/internal/preservation"
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dispositionDryRun bool
+	dispositionJSON   bool
+)
+
+var dispositionCmd = &cobra.Command{
+	Use:   "disposition",
+	Short: "Review and run the scheduled deletions of preserved sources",
+	Long: `Preserved sources with the delete disposition are scheduled for deletion once the review period ends (CA4M_DISPOSITION_REVIEW_PERIOD).
+Scheduled deletions wait in a queue under the processing base directory, where they can be listed and cancelled.
+Run "disposition run" periodically, e.g. from cron, to delete the due sources. Every action is recorded in the disposition audit log.`,
+}
+
+var dispositionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the scheduled deletions",
+	Args:  cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		cfg, err := loadDispositionConfig()
+		if err != nil {
+			return err
+		}
+		dir := preservation.DispositionDir(cfg.ProcessingBaseDir)
+		entries, err := disposition.NewQueue(disposition.QueuePath(dir)).List()
+		if err != nil {
+			return err
+		}
+		if dispositionJSON {
+			if entries == nil {
+				entries = []disposition.Entry{}
+			}
+			return printDispositionJSON(entries)
+		}
+		now := time.Now()
+		for _, entry := range entries {
+			state := "scheduled"
+			if !entry.Due.After(now) {
+				state = "due"
+			}
+			//nolint:forbidigo // Report needs to output directly to stdout
+			fmt.Printf("%s %s (%s) %s %s, AIP %s\n", entry.Due.Format(time.RFC3339), entry.NodePath, entry.NodeUUID, entry.Username, state, entry.AipUUID)
+		}
+		//nolint:forbidigo // Report needs to output directly to stdout
+		fmt.Printf("%d scheduled deletion(s)\n", len(entries))
+		return nil
+	},
+}
+
+var dispositionRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Delete the sources whose review period ended",
+	Long: `Delete the due sources, as the users who preserved them. Sources changed since they were preserved aren't deleted and leave the queue.
+Failed deletions stay in the queue and are retried on the next run. Use --dry-run to report the due sources without deleting them.`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		cfg, err := loadDispositionConfig()
+		if err != nil {
+			return err
+		}
+		cellsClient, err := preservation.NewCellsClient(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("cells client error: %w", err)
+		}
+		defer cellsClient.Close()
+
+		dir := preservation.DispositionDir(cfg.ProcessingBaseDir)
+		runner := disposition.NewRunner(cellsClient, disposition.NewQueue(disposition.QueuePath(dir)), disposition.NewAuditLog(disposition.AuditPath(dir)), cfg.AllowInsecureTLS)
+		report, err := runner.Run(ctx, time.Now(), dispositionDryRun)
+		if report != nil {
+			printDispositionReport(report)
+		}
+		if err != nil {
+			return err
+		}
+		if report.Failed > 0 {
+			return fmt.Errorf("%d deletion(s) failed, run again to retry them", report.Failed)
+		}
+		return nil
+	},
+}
+
+var dispositionCancelCmd = &cobra.Command{
+	Use:   "cancel <node-uuid>",
+	Short: "Cancel the scheduled deletion of a source",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := loadDispositionConfig()
+		if err != nil {
+			return err
+		}
+		dir := preservation.DispositionDir(cfg.ProcessingBaseDir)
+		queue := disposition.NewQueue(disposition.QueuePath(dir))
+		entries, err := queue.List()
+		if err != nil {
+			return err
+		}
+		var entry *disposition.Entry
+		for i := range entries {
+			if entries[i].NodeUUID == args[0] {
+				entry = &entries[i]
+				break
+			}
+		}
+		if entry == nil {
+			return fmt.Errorf("no deletion scheduled for node %s", args[0])
+		}
+		if _, err := queue.Remove(entry.NodeUUID); err != nil {
+			return err
+		}
+
+		detail := fmt.Sprintf("Deletion of source %s, preserved in AIP %s at %s", entry.NodePath, entry.AipUUID, entry.AipLocation)
+		event := disposition.NewAuditEvent(disposition.EventDeletion, detail, disposition.OutcomeCancelled, "cancelled by an operator", entry.Username, entry.NodeUUID, entry.AipUUID)
+		if err := disposition.NewAuditLog(disposition.AuditPath(dir)).Record(event); err != nil {
+			logger.Error("Failed to record cancellation of %s: %v", entry.NodePath, err)
+		}
+		//nolint:forbidigo // Report needs to output directly to stdout
+		fmt.Printf("Cancelled deletion of %s (%s)\n", entry.NodePath, entry.NodeUUID)
+		return nil
+	},
+}
+
+func init() {
+	dispositionRunCmd.Flags().BoolVar(&dispositionDryRun, "dry-run", false, "Report the due sources without deleting them")
+	dispositionRunCmd.Flags().BoolVar(&dispositionJSON, "json", false, "Print the report as JSON")
+	dispositionListCmd.Flags().BoolVar(&dispositionJSON, "json", false, "Print the queue as JSON")
+	dispositionCmd.AddCommand(dispositionListCmd, dispositionRunCmd, dispositionCancelCmd)
+}
+
+// loadDispositionConfig loads the environment configuration and initializes the logger.
+func loadDispositionConfig() (*config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading configuration: %w", err)
+	}
+	logger.Initialize(cfg.LogLevel, cfg.LogFilePath)
+	return cfg, nil
+}
+
+// printDispositionReport prints the disposition run report to stdout.
+func printDispositionReport(report *disposition.Report) {
+	if dispositionJSON {
+		if err := printDispositionJSON(report); err != nil {
+			logger.Error("Error marshalling disposition report: %v", err)
+		}
+		return
+	}
+	for _, item := range report.Items {
+		//nolint:forbidigo // Report needs to output directly to stdout
+		fmt.Printf("%-9s %s (%s): %s\n", item.Outcome, item.Entry.NodePath, item.Entry.NodeUUID, item.Note)
+	}
+	mode := "Processed"
+	if report.DryRun {
+		mode = "Due"
+	}
+	//nolint:forbidigo // Report needs to output directly to stdout
+	fmt.Printf("%s %d deletion(s). Failed: %d\n", mode, len(report.Items), report.Failed)
+}
+
+func printDispositionJSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	//nolint:forbidigo // Report needs to output directly to stdout
+	fmt.Println(string(data))
+	return nil
+}
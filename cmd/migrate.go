@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/penwern/curate-preservation-core/internal/migration"
+	"github.com/penwern/curate-preservation-core/internal/preservation"
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// migrationStateDir is the directory, within the processing base directory, persisting migration progress.
+const migrationStateDir = ".migrations"
+
+var (
+	migratePath      string
+	migrateUsername  string
+	migrateDryRun    bool
+	migrateStatePath string
+	migrateJSON      bool
+)
+
+var migrateMetadataCmd = &cobra.Command{
+	Use:   "migrate-metadata",
+	Short: "Migrate deprecated Cells metadata namespaces",
+	Long: `Scan a Cells workspace or subtree through the admin tree API and rewrite deprecated metadata namespaces into their current equivalents:
+
+  usermeta-a3m-progress  -> usermeta-preservation-status (and its status code)
+  usermeta-dip-progress  -> usermeta-dip-status (and its status code)
+  usermeta-premis-data   -> Premis (events merged)
+
+The path is an absolute datasource path, e.g. personal/username. Use --dry-run to report the changes without writing them.
+Progress is saved after each node, so an interrupted migration resumes where it stopped when run again.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("error loading configuration: %w", err)
+		}
+		logger.Initialize(cfg.LogLevel, cfg.LogFilePath)
+
+		vocabularies, err := config.LoadStatusVocabularies(cfg.Status.VocabulariesPath)
+		if err != nil {
+			return fmt.Errorf("status vocabularies error: %w", err)
+		}
+
+		cellsClient, err := preservation.NewCellsClient(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("cells client error: %w", err)
+		}
+		defer cellsClient.Close()
+
+		// Admins can't edit the user metadata of personal files, so those are migrated as their owner
+		userClient := cellsClient.AdminUserClient()
+		if migrateUsername != "" {
+			userClient, err = cellsClient.NewUserClient(ctx, migrateUsername, cfg.AllowInsecureTLS)
+			if err != nil {
+				return fmt.Errorf("error creating user client: %w", err)
+			}
+			defer func() {
+				if err := cellsClient.CloseUserClient(context.WithoutCancel(ctx), userClient); err != nil {
+					logger.Error("Failed to close user client: %v", err)
+				}
+			}()
+		}
+
+		root := strings.Trim(migratePath, "/")
+		statePath := migrateStatePath
+		if statePath == "" {
+			sum := sha256.Sum256([]byte(root))
+			statePath = filepath.Join(cfg.ProcessingBaseDir, migrationStateDir, "metadata-"+hex.EncodeToString(sum[:4])+".jsonl")
+		}
+
+		migrator := migration.NewMigrator(cellsClient, userClient, cfg, vocabularies)
+		report, err := migrator.Run(ctx, root, migration.Options{DryRun: migrateDryRun, StatePath: statePath})
+		if report != nil {
+			printMigrationReport(report)
+		}
+		if err != nil {
+			return err
+		}
+		if report.Failed > 0 {
+			return fmt.Errorf("%d node(s) failed to migrate, run again to retry them", report.Failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	migrateMetadataCmd.Flags().StringVarP(&migratePath, "path", "p", "", "Absolute Cells path to migrate, e.g. personal/username (required)")
+	migrateMetadataCmd.Flags().StringVarP(&migrateUsername, "cells-username", "u", "", "Write the metadata as this user, e.g. the owner of personal files. Defaults to the admin")
+	migrateMetadataCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Report the changes without writing them")
+	migrateMetadataCmd.Flags().StringVar(&migrateStatePath, "state-file", "", "Migration progress file. Defaults to a file under the processing base directory")
+	migrateMetadataCmd.Flags().BoolVar(&migrateJSON, "json", false, "Print the report as JSON")
+	_ = migrateMetadataCmd.MarkFlagRequired("path")
+}
+
+// printMigrationReport prints the migration report to stdout.
+func printMigrationReport(report *migration.Report) {
+	if migrateJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error("Error marshalling migration report: %v", err)
+			return
+		}
+		//nolint:forbidigo // Report needs to output directly to stdout
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, change := range report.Changes {
+		//nolint:forbidigo // Report needs to output directly to stdout
+		fmt.Printf("%s (%s)\n", change.Path, change.UUID)
+		namespaces := make([]string, 0, len(change.Set))
+		for namespace := range change.Set {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+		for _, namespace := range namespaces {
+			value := []rune(string(change.Set[namespace]))
+			if len(value) > 80 {
+				value = append(value[:77], []rune("...")...)
+			}
+			//nolint:forbidigo // Report needs to output directly to stdout
+			fmt.Printf("  set    %s = %s\n", namespace, string(value))
+		}
+		for _, namespace := range change.Delete {
+			//nolint:forbidigo // Report needs to output directly to stdout
+			fmt.Printf("  delete %s\n", namespace)
+		}
+		for _, note := range change.Notes {
+			//nolint:forbidigo // Report needs to output directly to stdout
+			fmt.Printf("  note   %s\n", note)
+		}
+	}
+	for _, e := range report.Errors {
+		//nolint:forbidigo // Report needs to output directly to stdout
+		fmt.Printf("error: %s\n", e)
+	}
+
+	mode := "Migrated"
+	count := report.Migrated
+	if report.DryRun {
+		mode = "Would migrate"
+		count = len(report.Changes)
+	}
+	//nolint:forbidigo // Report needs to output directly to stdout
+	fmt.Printf("%s %d of %d node(s) under %s. Already migrated: %d. Failed: %d\n", mode, count, report.Scanned, report.Root, report.Resumed, report.Failed)
+}
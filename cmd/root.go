@@ -19,8 +19,11 @@ import (
 var (
 	addr             string
 	cleanup          bool
+	dispositionFlag  string
+	force            bool
 	serve            bool
 	allowInsecureTLS bool
+	statusVocabulary string
 
 	// Pydio Cells
 	cellsArchiveDir string
@@ -155,8 +158,11 @@ Environment configuration is loaded from the environment variables.`,
 			CellsPaths:       cellsPaths,
 			CellsUsername:    cellsUsername,
 			Cleanup:          cleanup,
+			Disposition:      dispositionFlag,
+			Force:            force,
 			PreservationCfg:  &preservationCfg,
 			AtomCfg:          finalAtomConfig,
+			StatusVocabulary: statusVocabulary,
 		}
 
 		if err := svc.RunArgs(ctx, &svcArgs); err != nil {
@@ -174,15 +180,24 @@ func init() {
 	// Add version command
 	RootCmd.AddCommand(versionCmd)
 
+	// Add metadata migration command
+	RootCmd.AddCommand(migrateMetadataCmd)
+
+	// Add disposition queue command
+	RootCmd.AddCommand(dispositionCmd)
+
 	RootCmd.Flags().BoolVar(&serve, "serve", false, "Start HTTP server")
 	RootCmd.Flags().StringVar(&addr, "addr", ":6905", "HTTP listen address (with --serve)")
 	RootCmd.Flags().BoolVar(&cleanup, "cleanup", true, "Cleanup after run")
+	RootCmd.Flags().StringVar(&dispositionFlag, "disposition", "", "Action on sources once preserved: leave, move or delete. Defaults to CA4M_DISPOSITION_ACTION")
+	RootCmd.Flags().BoolVar(&force, "force", false, "Preserve content unchanged since its last preservation")
 	RootCmd.Flags().BoolVar(&allowInsecureTLS, "allow-insecure-tls", false, "Allow insecure TLS connections (for testing only)")
+	RootCmd.Flags().StringVar(&statusVocabulary, "status-vocabulary", "", "Status labels vocabulary, e.g. plain. Defaults to CA4M_STATUS_VOCABULARY")
 
 	// Cells
 	RootCmd.Flags().StringSliceVarP(&cellsPaths, "cells-path", "p", nil, "Cells paths to preserve. can provide multiple.")
 	RootCmd.Flags().StringVarP(&cellsUsername, "cells-username", "u", "", "Cells username (required)")
-	RootCmd.Flags().StringVarP(&cellsArchiveDir, "cells-archive-dir", "a", "common-files", "Cells archive directory")
+	RootCmd.Flags().StringVarP(&cellsArchiveDir, "cells-archive-dir", "a", "", "Cells archive directory or template. Defaults to CA4M_CELLS_ARCHIVE_WORKSPACE")
 
 	// Preservation
 	RootCmd.Flags().BoolVar(&compressAip, "compress-aip", defaultPreservationCfg.CompressAip, "Compress AIP")
@@ -9,9 +9,9 @@ import (
 	"time"
 
 	transferservice "github.com/penwern/curate-preservation-core/common/proto/a3m/gen/go/a3m/api/transferservice/v1beta1"
+	"github.com/penwern/curate-preservation-core/pkg/config"
 	"github.com/penwern/curate-preservation-core/pkg/logger"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Client wraps the gRPC connection and provides package submission methods.
@@ -24,19 +24,30 @@ type Client struct {
 	activeRequests   sync.Map      // Map of active package IDs
 	processingTokens chan struct{} // Semaphore for limiting concurrent processing
 	opt              ClientOptions
+
+	// Health tracking
+	breaker    *circuitBreaker
+	stopHealth context.CancelFunc
 }
 
 // ClientOptions represents the options for the A3M client.
 type ClientOptions struct {
-	MaxActiveProcessing int           // Maximum number of concurrent packages in processing state
-	PollInterval        time.Duration // Time between status polls
+	MaxActiveProcessing int               // Maximum number of concurrent packages in processing state
+	PollInterval        time.Duration     // Time between status polls
+	TLS                 TLSOptions        // Transport security. Plaintext if not enabled
+	BearerToken         string            // Bearer token sent with every RPC (optional, requires TLS)
+	DialOptions         []grpc.DialOption // Additional dial options, e.g. a custom dialer for an in-process server
+	HealthCheckInterval time.Duration     // Time between A3M health checks. Disabled if zero
+	FailureThreshold    int               // Consecutive A3M failures before the circuit opens
 }
 
 // ClientInterface defines the interface for the A3M client.
 type ClientInterface interface {
 	Close()
+	WaitAvailable(ctx context.Context) error
 	SubmitPackage(ctx context.Context, path, name string, config *transferservice.ProcessingConfig) (string, *transferservice.ReadResponse, error)
 	GetActiveProcessingCount() int
+	Health() HealthStatus
 }
 
 // NewClient creates a new client instance with default options.
@@ -47,11 +58,33 @@ func NewClient(address string) (*Client, error) {
 	})
 }
 
+// NewClientFromConfig creates a new client instance using the address, transport security and authentication from the environment configuration.
+func NewClientFromConfig(cfg *config.Config, options ClientOptions) (*Client, error) {
+	options.TLS = TLSOptions{
+		Enabled:            cfg.A3M.TLS.Enabled,
+		CACertPath:         cfg.A3M.TLS.CACertPath,
+		ClientCertPath:     cfg.A3M.TLS.ClientCertPath,
+		ClientKeyPath:      cfg.A3M.TLS.ClientKeyPath,
+		ServerName:         cfg.A3M.TLS.ServerName,
+		InsecureSkipVerify: cfg.AllowInsecureTLS,
+	}
+	options.BearerToken = cfg.A3M.Token
+	if options.HealthCheckInterval == 0 {
+		options.HealthCheckInterval = cfg.A3M.HealthCheckInterval
+	}
+	if options.FailureThreshold == 0 {
+		options.FailureThreshold = cfg.A3M.FailureThreshold
+	}
+	return NewClientWithOptions(cfg.A3M.Address, options)
+}
+
 // NewClientWithOptions creates a new client instance with custom options.
 func NewClientWithOptions(address string, options ClientOptions) (*Client, error) {
-	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	opts, err := dialOptions(options.TLS, options.BearerToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid a3m connection options: %w", err)
 	}
+	opts = append(opts, options.DialOptions...)
 
 	conn, err := grpc.NewClient(address, opts...)
 	if err != nil {
@@ -66,19 +99,42 @@ func NewClientWithOptions(address string, options ClientOptions) (*Client, error
 	if pollingInterval <= 0 {
 		pollingInterval = 1 * time.Second
 	}
+	failureThreshold := options.FailureThreshold
+	if failureThreshold <= 0 {
+		failureThreshold = 3
+	}
+
+	// An open circuit waits for the next health check before a trial RPC, or a fixed cooldown without health checks
+	circuitCooldown := options.HealthCheckInterval
+	if circuitCooldown <= 0 {
+		circuitCooldown = defaultCircuitCooldown
+	}
 
-	client := transferservice.NewTransferServiceClient(conn)
-	return &Client{
+	client := &Client{
 		address: address,
-		client:  client,
+		client:  transferservice.NewTransferServiceClient(conn),
 		conn:    conn,
 		opt: ClientOptions{
 			MaxActiveProcessing: maxActive,
 			PollInterval:        pollingInterval,
+			TLS:                 options.TLS,
+			BearerToken:         options.BearerToken,
+			HealthCheckInterval: options.HealthCheckInterval,
+			FailureThreshold:    failureThreshold,
 		},
 
 		processingTokens: make(chan struct{}, maxActive),
-	}, nil
+		breaker:          newCircuitBreaker(failureThreshold, circuitCooldown),
+	}
+
+	// Track A3M health in the background
+	if options.HealthCheckInterval > 0 {
+		healthCtx, cancel := context.WithCancel(context.Background())
+		client.stopHealth = cancel
+		go client.healthLoop(healthCtx, options.HealthCheckInterval)
+	}
+
+	return client, nil
 }
 
 // GetActiveProcessingCount returns the number of packages currently being processed
@@ -91,9 +147,20 @@ func (c *Client) GetActiveProcessingCount() int {
 	return count
 }
 
+// WaitAvailable blocks while the circuit is open because A3M is unavailable, until it recovers,
+// the caller is let through as the trial submission, or the context is done.
+// Call it before each SubmitPackage, outside any timeout of the submission, so waiting for A3M doesn't count against it.
+func (c *Client) WaitAvailable(ctx context.Context) error {
+	if err := c.breaker.wait(ctx); err != nil {
+		return fmt.Errorf("context cancelled while waiting for a3m to recover: %w", err)
+	}
+	return nil
+}
+
 // SubmitPackage submits a package (given by its URI) with a name and configuration.
 // It polls the server until processing is complete (or fails) and returns the AIP UUID and final response.
 // This implementation will block if there are already maxActiveProcessing packages being processed.
+// It doesn't wait for A3M to recover from an outage, call WaitAvailable first.
 func (c *Client) SubmitPackage(ctx context.Context, path, name string, config *transferservice.ProcessingConfig) (string, *transferservice.ReadResponse, error) {
 	// Acquire processing token (will block if too many packages are processing)
 	select {
@@ -119,6 +186,7 @@ func (c *Client) SubmitPackage(ctx context.Context, path, name string, config *t
 	logger.Debug("A3M Submission Request: %+v", submitReq)
 
 	submitResp, err := c.client.Submit(ctx, submitReq)
+	c.recordResult(ctx, err)
 	logger.Debug("A3M Submission Response: %v", submitResp)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to submit package: %w", err)
@@ -141,6 +209,7 @@ func (c *Client) SubmitPackage(ctx context.Context, path, name string, config *t
 
 		readReq := &transferservice.ReadRequest{Id: submitResp.Id}
 		readResp, err := c.client.Read(ctx, readReq)
+		c.recordResult(ctx, err)
 		if err != nil {
 			return "", nil, fmt.Errorf("error reading status for package %q (ID: %q): %w", name, submitResp.Id, err)
 		}
@@ -174,8 +243,11 @@ func (c *Client) SubmitPackage(ctx context.Context, path, name string, config *t
 	}
 }
 
-// Close shuts down the underlying gRPC connection.
+// Close stops the health checks and shuts down the underlying gRPC connection.
 func (c *Client) Close() {
+	if c.stopHealth != nil {
+		c.stopHealth()
+	}
 	if c.conn != nil {
 		if err := c.conn.Close(); err != nil {
 			logger.Error("Failed to close connection: %v", err)
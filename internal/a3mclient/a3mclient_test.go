@@ -0,0 +1,222 @@
+package a3mclient
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	transferservice "github.com/penwern/curate-preservation-core/common/proto/a3m/gen/go/a3m/api/transferservice/v1beta1"
+	"github.com/penwern/curate-preservation-core/internal/a3mtest"
+	"github.com/penwern/curate-preservation-core/pkg/utils"
+)
+
+// newTestClient serves a fake A3M over an in-memory listener and creates a client polling it every millisecond.
+func newTestClient(t *testing.T, options a3mtest.Options, clientOptions ClientOptions) (*Client, *a3mtest.Server) {
+	t.Helper()
+	server := a3mtest.NewServer(options)
+	clientOptions.DialOptions = server.StartBufconn()
+	clientOptions.PollInterval = time.Millisecond
+	t.Cleanup(server.Stop)
+	client, err := NewClientWithOptions(server.Address(), clientOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client, server
+}
+
+// submit waits for A3M and submits a package, the way the preservation service does.
+func submit(t *testing.T, client *Client, name string) (string, *transferservice.ReadResponse, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.WaitAvailable(ctx); err != nil {
+		return "", nil, err
+	}
+	return client.SubmitPackage(ctx, "/transfers/"+name, name, &transferservice.ProcessingConfig{})
+}
+
+func TestSubmitPackageComplete(t *testing.T) {
+	completedDir := t.TempDir()
+	client, server := newTestClient(t, a3mtest.Options{CompletedDir: completedDir}, ClientOptions{})
+	server.Enqueue(a3mtest.Script{ProcessingPolls: 2, Outcome: a3mtest.OutcomeComplete})
+
+	id, resp, err := submit(t, client, "my package")
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if id == "" || resp.Status != transferservice.PackageStatus_PACKAGE_STATUS_COMPLETE {
+		t.Fatalf("submit: got (%q, %s), want an ID and a complete status", id, resp.Status)
+	}
+	if submissions := server.Submissions(); len(submissions) != 1 || submissions[0].Name != "my_package" {
+		t.Fatalf("submissions: got %v, want one named my_package", submissions)
+	}
+	entries, err := os.ReadDir(completedDir)
+	if err != nil || len(entries) != 1 || !strings.Contains(entries[0].Name(), id) {
+		t.Fatalf("completed dir: got (%v, %v), want the AIP of %s", entries, err, id)
+	}
+	if health := client.Health(); health.CircuitState != CircuitClosed.String() || health.ActiveProcessing != 0 {
+		t.Fatalf("health: got %+v, want a closed circuit and no active processing", health)
+	}
+}
+
+func TestSubmitPackageFailure(t *testing.T) {
+	failedJob := a3mtest.FailedJob("Normalize")
+	cases := []struct {
+		name   string
+		script a3mtest.Script
+		want   []string
+	}{
+		{
+			name: "failed",
+			script: a3mtest.Script{
+				Outcome: a3mtest.OutcomeFailed,
+				Jobs:    []*transferservice.Job{failedJob},
+				Tasks:   map[string][]*transferservice.Task{failedJob.Id: {{Id: "task", Stderr: "normalization failed"}}},
+			},
+			want: []string{"PACKAGE_STATUS_FAILED", "Normalize", "normalization failed"},
+		},
+		{name: "rejected", script: a3mtest.Script{Outcome: a3mtest.OutcomeRejected}, want: []string{"PACKAGE_STATUS_REJECTED"}},
+		{name: "unspecified", script: a3mtest.Script{Outcome: a3mtest.OutcomeUnspecified}, want: []string{"unspecified status"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := newTestClient(t, a3mtest.Options{}, ClientOptions{})
+			server.Enqueue(tc.script)
+
+			id, _, err := submit(t, client, "package")
+			if err == nil || id != "" {
+				t.Fatalf("submit: got (%q, %v), want an error", id, err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("submit: got %v, want error containing %q", err, want)
+				}
+			}
+			// Package failures are final, and don't count against A3M health
+			if utils.IsTransientError(err) {
+				t.Errorf("submit: got transient error %v, want a final one", err)
+			}
+			if health := client.Health(); health.CircuitState != CircuitClosed.String() || health.ConsecutiveFailures != 0 {
+				t.Errorf("health: got %+v, want a closed circuit without failures", health)
+			}
+		})
+	}
+}
+
+func TestSubmitPackageTransientRetry(t *testing.T) {
+	client, server := newTestClient(t, a3mtest.Options{}, ClientOptions{FailureThreshold: 3})
+	server.Enqueue(
+		a3mtest.Script{SubmitErr: a3mtest.TransportError()},
+		a3mtest.Script{ReadErrs: []error{a3mtest.TransportError()}},
+		a3mtest.Script{ProcessingPolls: 1, Outcome: a3mtest.OutcomeComplete},
+	)
+
+	// Retried the way the preservation service submits packages
+	var id string
+	attempts := 0
+	err := utils.Retry(3, time.Millisecond, func() error {
+		attempts++
+		var err error
+		id, _, err = submit(t, client, "package")
+		if attempts < 3 {
+			if err == nil {
+				t.Fatalf("attempt %d: got success, want a transport error", attempts)
+			}
+			// The second submission is accepted before its first poll fails, so each attempt ends on one failure
+			if health := client.Health(); health.CircuitState != CircuitClosed.String() || health.ConsecutiveFailures != 1 {
+				t.Fatalf("attempt %d: got %+v, want a closed circuit with one failure", attempts, health)
+			}
+		}
+		return err
+	}, utils.IsTransientError)
+	if err != nil || id == "" {
+		t.Fatalf("submit: got (%q, %v) after %d attempts, want success", id, err, attempts)
+	}
+	if len(server.Submissions()) != 3 {
+		t.Fatalf("submissions: got %d, want 3", len(server.Submissions()))
+	}
+	if health := client.Health(); health.CircuitState != CircuitClosed.String() || health.ConsecutiveFailures != 0 {
+		t.Fatalf("health: got %+v, want a closed circuit without failures", health)
+	}
+}
+
+func TestSubmitPackageHalfOpenCircuit(t *testing.T) {
+	client, server := newTestClient(t, a3mtest.Options{}, ClientOptions{FailureThreshold: 1})
+	client.breaker = newCircuitBreaker(1, 20*time.Millisecond)
+	server.Enqueue(
+		a3mtest.Script{SubmitErr: a3mtest.TransportError()},
+		a3mtest.Script{SubmitErr: a3mtest.TransportError()},
+		a3mtest.Script{Outcome: a3mtest.OutcomeComplete},
+	)
+
+	// The first transport failure opens the circuit
+	if _, _, err := submit(t, client, "package"); err == nil {
+		t.Fatal("submit: got success, want a transport error")
+	}
+	if state := client.Health().CircuitState; state != CircuitOpen.String() {
+		t.Fatalf("circuit: got %s, want %s", state, CircuitOpen)
+	}
+
+	// The trial submission after the cooldown fails, so the circuit opens again
+	start := time.Now()
+	if _, _, err := submit(t, client, "package"); err == nil {
+		t.Fatal("trial: got success, want a transport error")
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Fatalf("trial: let through after %s, want the cooldown", waited)
+	}
+	if state := client.Health().CircuitState; state != CircuitOpen.String() {
+		t.Fatalf("circuit: got %s, want %s", state, CircuitOpen)
+	}
+
+	// The next trial succeeds and closes the circuit
+	if id, _, err := submit(t, client, "package"); err != nil || id == "" {
+		t.Fatalf("trial: got (%q, %v), want success", id, err)
+	}
+	if state := client.Health().CircuitState; state != CircuitClosed.String() {
+		t.Fatalf("circuit: got %s, want %s", state, CircuitClosed)
+	}
+}
+
+// waitForCircuit waits for the circuit to reach a state, failing the test after a second.
+func waitForCircuit(t *testing.T, client *Client, want CircuitState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for client.Health().CircuitState != want.String() {
+		if time.Now().After(deadline) {
+			t.Fatalf("circuit: got %+v, want %s", client.Health(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHealthProbeCircuit(t *testing.T) {
+	client, server := newTestClient(t, a3mtest.Options{}, ClientOptions{HealthCheckInterval: 5 * time.Millisecond, FailureThreshold: 2})
+	server.SetProbeError(a3mtest.TransportError())
+
+	// Failing probes open the circuit, without any submission
+	waitForCircuit(t, client, CircuitOpen)
+	if health := client.Health(); health.ConsecutiveFailures < 2 || !strings.Contains(health.LastError, "transport is closing") {
+		t.Fatalf("health: got %+v, want the probe failures", health)
+	}
+
+	// The first probe A3M answers closes it
+	server.SetProbeError(nil)
+	waitForCircuit(t, client, CircuitClosed)
+	if health := client.Health(); health.ConsecutiveFailures != 0 || health.LastError != "" {
+		t.Fatalf("health: got %+v, want the failures reset", health)
+	}
+	if len(server.Submissions()) != 0 {
+		t.Fatalf("submissions: got %d, want the circuit closed by probes only", len(server.Submissions()))
+	}
+	if server.ProbeReads() < 3 {
+		t.Fatalf("probes: got %d, want the circuit to follow them", server.ProbeReads())
+	}
+
+	if id, _, err := submit(t, client, "package"); err != nil || id == "" {
+		t.Fatalf("submit: got (%q, %v), want success", id, err)
+	}
+}
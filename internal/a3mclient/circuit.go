@@ -0,0 +1,275 @@
+package a3mclient
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	transferservice "github.com/penwern/curate-preservation-core/common/proto/a3m/gen/go/a3m/api/transferservice/v1beta1"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthProbeID is the package ID read by the health probe. It never exists, so a healthy A3M answers NotFound.
+// The Empty RPC is deliberately not used as a probe because it deletes the contents of A3M's shared folders.
+const healthProbeID = "00000000-0000-0000-0000-000000000000"
+
+// defaultCircuitCooldown is the time an open circuit waits before letting a trial RPC through, when health checks are disabled.
+const defaultCircuitCooldown = 30 * time.Second
+
+// metrics exposes the A3M health of the process via expvar, served at /metrics.
+var metrics = expvar.NewMap("a3m")
+
+// CircuitState is the state of the A3M circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed means A3M is healthy and submissions proceed.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means A3M is unhealthy and submissions wait until it recovers.
+	CircuitOpen
+	// CircuitHalfOpen means the cooldown of an open circuit ended and a single trial RPC is let through.
+	// Its success closes the circuit, its failure opens it again.
+	CircuitHalfOpen
+)
+
+// String returns the name of the circuit state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthStatus is a snapshot of the A3M health tracked by the client.
+type HealthStatus struct {
+	Address             string    `json:"address"`
+	CircuitState        string    `json:"circuitState"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastCheck           time.Time `json:"lastCheck,omitzero"`
+	OpenedAt            time.Time `json:"openedAt,omitzero"`
+	ActiveProcessing    int       `json:"activeProcessing"`
+}
+
+// circuitBreaker tracks consecutive A3M transport failures and blocks submissions while open.
+// Health probes close it as soon as A3M answers. Without probes, a trial RPC is let through once the cooldown ends.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration // Time an open circuit waits before a trial RPC
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	lastErr   error
+	lastCheck time.Time
+	openedAt  time.Time
+	trialAt   time.Time     // When the last trial RPC was let through. Another is let through after the cooldown, in case its result is never recorded
+	closedCh  chan struct{} // Closed when the circuit closes. Replaced when it opens
+}
+
+// newCircuitBreaker creates a closed circuit breaker that opens after threshold consecutive failures.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	closedCh := make(chan struct{})
+	close(closedCh)
+	metrics.Set("circuit_state", stringVar(CircuitClosed.String()))
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     CircuitClosed,
+		closedCh:  closedCh,
+	}
+}
+
+// wait blocks while the circuit is open, until it closes, this caller is let through as the trial RPC, or the context is done.
+func (cb *circuitBreaker) wait(ctx context.Context) error {
+	logged := false
+	for {
+		cb.mu.Lock()
+		if cb.state == CircuitClosed {
+			cb.mu.Unlock()
+			return nil
+		}
+		// The cooldown runs from the opening, or from the last trial
+		since := cb.openedAt
+		if cb.state == CircuitHalfOpen {
+			since = cb.trialAt
+		}
+		remaining := cb.cooldown - time.Since(since)
+		if remaining <= 0 {
+			logger.Info("A3M circuit cooldown ended. Letting a trial request through")
+			cb.state = CircuitHalfOpen
+			cb.trialAt = time.Now()
+			metrics.Set("circuit_state", stringVar(CircuitHalfOpen.String()))
+			cb.mu.Unlock()
+			return nil
+		}
+		closedCh := cb.closedCh
+		cb.mu.Unlock()
+
+		if !logged {
+			logger.Info("A3M circuit is open. Waiting for A3M to recover")
+			logged = true
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-closedCh:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// recordSuccess resets the failure count and closes the circuit.
+func (cb *circuitBreaker) recordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.lastErr = nil
+	cb.lastCheck = time.Now()
+	metrics.Set("consecutive_failures", intVar(0))
+	if cb.state != CircuitClosed {
+		logger.Info("A3M recovered. Closing circuit after %s", time.Since(cb.openedAt).Round(time.Second))
+		cb.state = CircuitClosed
+		cb.openedAt = time.Time{}
+		cb.trialAt = time.Time{}
+		close(cb.closedCh)
+		metrics.Set("circuit_state", stringVar(CircuitClosed.String()))
+	}
+}
+
+// recordFailure counts a failure and opens the circuit once the threshold is reached.
+func (cb *circuitBreaker) recordFailure(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	cb.lastErr = err
+	cb.lastCheck = time.Now()
+	metrics.Set("consecutive_failures", intVar(cb.failures))
+	if cb.state == CircuitClosed && cb.failures >= cb.threshold {
+		logger.Error("A3M unavailable after %d consecutive failures. Opening circuit: %v", cb.failures, err)
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+		cb.closedCh = make(chan struct{})
+		metrics.Set("circuit_state", stringVar(CircuitOpen.String()))
+		metrics.Add("circuit_opens_total", 1)
+	} else if cb.state == CircuitHalfOpen {
+		logger.Error("A3M still unavailable. Reopening circuit: %v", err)
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+		metrics.Set("circuit_state", stringVar(CircuitOpen.String()))
+	}
+}
+
+// snapshot returns the health of the circuit.
+func (cb *circuitBreaker) snapshot() HealthStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	health := HealthStatus{
+		CircuitState:        cb.state.String(),
+		ConsecutiveFailures: cb.failures,
+		LastCheck:           cb.lastCheck,
+		OpenedAt:            cb.openedAt,
+	}
+	if cb.lastErr != nil {
+		health.LastError = cb.lastErr.Error()
+	}
+	return health
+}
+
+// Health returns the A3M health tracked by the client.
+func (c *Client) Health() HealthStatus {
+	health := c.breaker.snapshot()
+	health.Address = c.address
+	health.ActiveProcessing = c.GetActiveProcessingCount()
+	return health
+}
+
+// healthLoop probes A3M every interval until the context is done.
+func (c *Client) healthLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.probe(ctx, interval)
+		}
+	}
+}
+
+// probe checks A3M is reachable and records the result.
+func (c *Client) probe(ctx context.Context, timeout time.Duration) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := c.client.Read(probeCtx, &transferservice.ReadRequest{Id: healthProbeID})
+	metrics.Add("health_checks_total", 1)
+	if err == nil || status.Code(err) == codes.NotFound || status.Code(err) == codes.InvalidArgument {
+		c.breaker.recordSuccess()
+		return
+	}
+	if ctx.Err() != nil {
+		return // Client closing
+	}
+	metrics.Add("health_check_failures_total", 1)
+	logger.Debug("A3M health check failed: %v", err)
+	c.breaker.recordFailure(err)
+}
+
+// recordResult records the outcome of an RPC against the circuit breaker.
+// Only transport failures count against A3M health. Package failures and cancellations don't.
+func (c *Client) recordResult(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		c.breaker.recordSuccess()
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		// Our own cancellation or deadline
+	case isTransportError(err):
+		c.breaker.recordFailure(err)
+	default:
+		c.breaker.recordSuccess()
+	}
+}
+
+// isTransportError checks if an error means A3M could not be reached.
+func isTransportError(err error) bool {
+	//nolint:exhaustive // We only want to handle transport error codes
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// stringVar is an expvar.Var holding a string value.
+type stringVar string
+
+// String implements expvar.Var.
+func (s stringVar) String() string {
+	return `"` + string(s) + `"`
+}
+
+// intVar is an expvar.Var holding an integer value.
+type intVar int
+
+// String implements expvar.Var.
+func (i intVar) String() string {
+	v := new(expvar.Int)
+	v.Set(int64(i))
+	return v.String()
+}
@@ -0,0 +1,97 @@
+package a3mclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSOptions represents the transport security options for the A3M connection.
+type TLSOptions struct {
+	Enabled            bool   // Use TLS. Plaintext is used if false
+	CACertPath         string // PEM CA bundle to verify the server. System roots are used if empty
+	ClientCertPath     string // PEM client certificate for mTLS (optional)
+	ClientKeyPath      string // PEM client key for mTLS (required with ClientCertPath)
+	ServerName         string // Overrides the server name used for verification (optional)
+	InsecureSkipVerify bool   // Skip server certificate verification (for testing only)
+}
+
+// bearerTokenCredentials attaches a bearer token to every RPC.
+type bearerTokenCredentials struct {
+	token string
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (b bearerTokenCredentials) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+// The token must never be sent in plaintext.
+func (b bearerTokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// dialOptions builds the gRPC dial options for the transport security and authentication options.
+func dialOptions(tlsOpts TLSOptions, bearerToken string) ([]grpc.DialOption, error) {
+	if !tlsOpts.Enabled {
+		if bearerToken != "" {
+			return nil, fmt.Errorf("a3m bearer token requires TLS to be enabled")
+		}
+		return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, nil
+	}
+
+	tlsConfig, err := newTLSConfig(tlsOpts)
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+	}
+	if bearerToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerTokenCredentials{token: bearerToken}))
+	}
+	return opts, nil
+}
+
+// newTLSConfig creates the TLS configuration, loading the CA bundle and client key pair if provided.
+func newTLSConfig(tlsOpts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: tlsOpts.ServerName,
+		// #nosec G402 -- InsecureSkipVerify is configurable via AllowInsecureTLS for development/testing environments
+		InsecureSkipVerify: tlsOpts.InsecureSkipVerify,
+	}
+
+	if tlsOpts.CACertPath != "" {
+		caPEM, err := os.ReadFile(filepath.Clean(tlsOpts.CACertPath))
+		if err != nil {
+			return nil, fmt.Errorf("error reading a3m CA certificate: %w", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in a3m CA certificate: %s", tlsOpts.CACertPath)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	switch {
+	case tlsOpts.ClientCertPath != "" && tlsOpts.ClientKeyPath != "":
+		clientCert, err := tls.LoadX509KeyPair(tlsOpts.ClientCertPath, tlsOpts.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading a3m client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	case tlsOpts.ClientCertPath != "" || tlsOpts.ClientKeyPath != "":
+		return nil, fmt.Errorf("a3m client certificate and key must be provided together")
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,132 @@
+package a3mtest
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fakeMETS is the METS document written into fake AIPs and DIPs.
+const fakeMETS = `<?xml version="1.0" encoding="UTF-8"?>
+<mets:mets xmlns:mets="http://www.loc.gov/METS/" OBJID="%s"/>
+`
+
+// WriteAIP writes a fake AIP for a package to the completed directory, where A3M would store it.
+// The AIP is named <name>-<id>.7z like a real A3M AIP, but is a tar archive, which the extraction utilities detect by signature.
+// If transferPath is a local directory, its contents are included under data/objects.
+// Returns the path of the AIP.
+func WriteAIP(completedDir, name, id, transferPath string) (string, error) {
+	aipName := name + "-" + id
+	aipPath := filepath.Join(completedDir, aipName+".7z")
+
+	file, err := os.Create(filepath.Clean(aipPath))
+	if err != nil {
+		return "", fmt.Errorf("error creating AIP: %w", err)
+	}
+	tw := tar.NewWriter(file)
+
+	writeErr := func() error {
+		for _, dir := range []string{aipName, aipName + "/data", aipName + "/data/objects"} {
+			if err := writeTarDir(tw, dir); err != nil {
+				return err
+			}
+		}
+		files := map[string]string{
+			aipName + "/bagit.txt":                        "BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n",
+			aipName + "/bag-info.txt":                     "Bagging-Date: " + time.Now().UTC().Format("2006-01-02") + "\n",
+			aipName + "/data/METS." + id + ".xml":         fmt.Sprintf(fakeMETS, id),
+			aipName + "/data/README.html":                 "<html><body>a3mtest</body></html>\n",
+			aipName + "/data/objects/a3mtest-placeholder": id + "\n",
+		}
+		for name, content := range files {
+			if err := writeTarFile(tw, name, strings.NewReader(content), int64(len(content))); err != nil {
+				return err
+			}
+		}
+		if info, err := os.Stat(transferPath); err == nil && info.IsDir() {
+			if err := writeTarTree(tw, transferPath, aipName+"/data/objects"); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	}()
+	closeErr := file.Close()
+	if writeErr != nil {
+		return "", fmt.Errorf("error writing AIP: %w", writeErr)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("error closing AIP: %w", closeErr)
+	}
+	return aipPath, nil
+}
+
+// WriteDIP writes a fake DIP for a package to the dips directory, where A3M would store it.
+// Returns the path of the DIP.
+func WriteDIP(dipsDir, id string) (string, error) {
+	dipPath := filepath.Join(dipsDir, id)
+	if err := os.MkdirAll(filepath.Join(dipPath, "objects"), 0o750); err != nil {
+		return "", fmt.Errorf("error creating DIP: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dipPath, "METS."+id+".xml"), []byte(fmt.Sprintf(fakeMETS, id)), 0o600); err != nil {
+		return "", fmt.Errorf("error writing DIP METS: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dipPath, "objects", "a3mtest-placeholder.txt"), []byte(id+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("error writing DIP object: %w", err)
+	}
+	return dipPath, nil
+}
+
+// writeTarTree writes the contents of a local directory into the tar under prefix.
+func writeTarTree(tw *tar.Writer, root, prefix string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := prefix + "/" + filepath.ToSlash(rel)
+		if info.IsDir() {
+			return writeTarDir(tw, name)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		return writeTarFile(tw, name, f, info.Size())
+	})
+}
+
+func writeTarDir(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0o750,
+		ModTime:  time.Now(),
+	})
+}
+
+func writeTarFile(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o600,
+		Size:     size,
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
@@ -0,0 +1,312 @@
+// Package a3mtest provides an in-process fake A3M Transfer Service.
+// It allows the a3m client and the preservation pipeline to be exercised without a running A3M container.
+// Package lifecycles are scripted per submission, and fake AIP and DIP artefacts are written to the
+// configured completed and dips directories so the full pipeline can run offline.
+package a3mtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	transferservice "github.com/penwern/curate-preservation-core/common/proto/a3m/gen/go/a3m/api/transferservice/v1beta1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bufconnSize is the buffer size of the in-memory listener.
+const bufconnSize = 1024 * 1024
+
+// Outcome is the final status reported for a scripted package.
+type Outcome int
+
+const (
+	// OutcomeComplete completes the package and writes the AIP (and DIP) artefacts.
+	OutcomeComplete Outcome = iota
+	// OutcomeFailed reports the package as failed.
+	OutcomeFailed
+	// OutcomeRejected reports the package as rejected.
+	OutcomeRejected
+	// OutcomeUnspecified reports an unspecified package status.
+	OutcomeUnspecified
+)
+
+// Script describes the lifecycle of a single submitted package.
+type Script struct {
+	ProcessingPolls int                                // Number of Read calls reporting PROCESSING before the outcome
+	Outcome         Outcome                            // Final package status
+	Jobs            []*transferservice.Job             // Jobs reported by Read once the outcome is reached
+	Tasks           map[string][]*transferservice.Task // Tasks returned by ListTasks, keyed by job ID
+	Delay           time.Duration                      // Delay before every response for this package (slow A3M)
+	SubmitErr       error                              // Error returned by Submit. The package is not created
+	ReadErrs        []error                            // Errors returned by the first Read calls, in order
+	SkipAIP         bool                               // Do not write the AIP artefact on completion
+	WriteDIP        bool                               // Write a DIP artefact on completion
+}
+
+// Options represents the options for the fake server.
+type Options struct {
+	CompletedDir  string // A3M completed directory. AIPs are not written if empty
+	DipsDir       string // A3M dips directory. DIPs are not written if empty
+	DefaultScript Script // Script used once the queued scripts are exhausted
+}
+
+// Server is a fake A3M Transfer Service.
+type Server struct {
+	transferservice.UnimplementedTransferServiceServer
+
+	opt        Options
+	grpcServer *grpc.Server
+	bufLis     *bufconn.Listener
+	address    string
+
+	mu          sync.Mutex
+	scripts     []Script
+	packages    map[string]*fakePackage
+	submissions []*transferservice.SubmitRequest
+	probeErr    error
+	probeReads  int
+}
+
+// fakePackage tracks the scripted state of a submitted package.
+type fakePackage struct {
+	request *transferservice.SubmitRequest
+	script  Script
+	reads   int
+	written bool
+}
+
+// NewServer creates a new fake server. Call StartBufconn or StartTCP to serve it.
+func NewServer(options Options) *Server {
+	return &Server{
+		opt:      options,
+		packages: make(map[string]*fakePackage),
+	}
+}
+
+// StartBufconn serves the fake server over an in-memory listener.
+// Returns the dial options to pass to the a3m client, together with Address.
+func (s *Server) StartBufconn() []grpc.DialOption {
+	s.bufLis = bufconn.Listen(bufconnSize)
+	s.address = "passthrough:///bufnet"
+	s.serve(s.bufLis)
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.bufLis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+}
+
+// StartTCP serves the fake server on a local port. The address is available from Address.
+func (s *Server) StartTCP() error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	s.address = lis.Addr().String()
+	s.serve(lis)
+	return nil
+}
+
+// serve registers the service and serves on the listener in the background.
+func (s *Server) serve(lis net.Listener) {
+	s.grpcServer = grpc.NewServer()
+	transferservice.RegisterTransferServiceServer(s.grpcServer, s)
+	go func() {
+		_ = s.grpcServer.Serve(lis)
+	}()
+}
+
+// Address returns the address the a3m client should connect to.
+func (s *Server) Address() string {
+	return s.address
+}
+
+// Stop stops the server immediately. In-flight calls fail with a transport error.
+func (s *Server) Stop() {
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+}
+
+// Enqueue queues scripts for the next submissions, in order.
+func (s *Server) Enqueue(scripts ...Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, scripts...)
+}
+
+// SetProbeError sets the error returned by Read for package IDs never submitted, such as the one read by the client health probe.
+// Nil restores the NotFound answer of a healthy A3M.
+func (s *Server) SetProbeError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probeErr = err
+}
+
+// Submissions returns the submit requests received, including failed ones.
+func (s *Server) Submissions() []*transferservice.SubmitRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*transferservice.SubmitRequest(nil), s.submissions...)
+}
+
+// ProbeReads returns the number of Read calls received for package IDs never submitted.
+func (s *Server) ProbeReads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.probeReads
+}
+
+// Submit implements transferservice.TransferServiceServer.
+func (s *Server) Submit(ctx context.Context, req *transferservice.SubmitRequest) (*transferservice.SubmitResponse, error) {
+	s.mu.Lock()
+	script := s.opt.DefaultScript
+	if len(s.scripts) > 0 {
+		script = s.scripts[0]
+		s.scripts = s.scripts[1:]
+	}
+	s.submissions = append(s.submissions, req)
+	s.mu.Unlock()
+
+	if err := wait(ctx, script.Delay); err != nil {
+		return nil, err
+	}
+	if script.SubmitErr != nil {
+		return nil, script.SubmitErr
+	}
+
+	id := uuid.New().String()
+	s.mu.Lock()
+	s.packages[id] = &fakePackage{request: req, script: script}
+	s.mu.Unlock()
+	return &transferservice.SubmitResponse{Id: id}, nil
+}
+
+// Read implements transferservice.TransferServiceServer.
+func (s *Server) Read(ctx context.Context, req *transferservice.ReadRequest) (*transferservice.ReadResponse, error) {
+	s.mu.Lock()
+	pkg, ok := s.packages[req.Id]
+	if !ok {
+		s.probeReads++
+		probeErr := s.probeErr
+		s.mu.Unlock()
+		if probeErr != nil {
+			return nil, probeErr
+		}
+		return nil, status.Errorf(codes.NotFound, "package %q not found", req.Id)
+	}
+	pkg.reads++
+	reads := pkg.reads
+	script := pkg.script
+	s.mu.Unlock()
+
+	if err := wait(ctx, script.Delay); err != nil {
+		return nil, err
+	}
+	if reads <= len(script.ReadErrs) {
+		return nil, script.ReadErrs[reads-1]
+	}
+	if reads-len(script.ReadErrs) <= script.ProcessingPolls {
+		return &transferservice.ReadResponse{
+			Status: transferservice.PackageStatus_PACKAGE_STATUS_PROCESSING,
+			Job:    "Processing",
+		}, nil
+	}
+
+	resp := &transferservice.ReadResponse{Jobs: script.Jobs}
+	switch script.Outcome {
+	case OutcomeComplete:
+		resp.Status = transferservice.PackageStatus_PACKAGE_STATUS_COMPLETE
+		if err := s.writeArtefacts(req.Id); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to write artefacts: %v", err)
+		}
+	case OutcomeFailed:
+		resp.Status = transferservice.PackageStatus_PACKAGE_STATUS_FAILED
+	case OutcomeRejected:
+		resp.Status = transferservice.PackageStatus_PACKAGE_STATUS_REJECTED
+	case OutcomeUnspecified:
+		resp.Status = transferservice.PackageStatus_PACKAGE_STATUS_UNSPECIFIED
+	}
+	return resp, nil
+}
+
+// ListTasks implements transferservice.TransferServiceServer.
+func (s *Server) ListTasks(ctx context.Context, req *transferservice.ListTasksRequest) (*transferservice.ListTasksResponse, error) {
+	s.mu.Lock()
+	var (
+		tasks []*transferservice.Task
+		delay time.Duration
+	)
+	for _, pkg := range s.packages {
+		if jobTasks, ok := pkg.script.Tasks[req.JobId]; ok {
+			tasks = jobTasks
+			delay = pkg.script.Delay
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if err := wait(ctx, delay); err != nil {
+		return nil, err
+	}
+	return &transferservice.ListTasksResponse{Tasks: tasks}, nil
+}
+
+// writeArtefacts writes the AIP and DIP artefacts for a completed package once.
+func (s *Server) writeArtefacts(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pkg := s.packages[id]
+	if pkg.written {
+		return nil
+	}
+	if !pkg.script.SkipAIP && s.opt.CompletedDir != "" {
+		if _, err := WriteAIP(s.opt.CompletedDir, pkg.request.Name, id, pkg.request.Url); err != nil {
+			return err
+		}
+	}
+	if pkg.script.WriteDIP && s.opt.DipsDir != "" {
+		if _, err := WriteDIP(s.opt.DipsDir, id); err != nil {
+			return err
+		}
+	}
+	pkg.written = true
+	return nil
+}
+
+// wait sleeps for the delay unless the context is done first.
+func wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// FailedJob returns a failed job, for use in Script.Jobs.
+func FailedJob(name string) *transferservice.Job {
+	return &transferservice.Job{
+		Id:     uuid.New().String(),
+		Name:   name,
+		Group:  "Fake",
+		LinkId: uuid.New().String(),
+		Status: transferservice.Job_STATUS_FAILED,
+	}
+}
+
+// TransportError returns a transient transport error, for use in Script.SubmitErr, Script.ReadErrs and SetProbeError.
+func TransportError() error {
+	return status.Error(codes.Unavailable, "a3mtest: transport is closing")
+}
@@ -0,0 +1,247 @@
+package cells
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/utils"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// Share types of NodeAccess.
+const (
+	ShareTypeCell = "cell"
+	ShareTypeLink = "link"
+)
+
+// membershipActions are the workspace ACL actions granting or denying access to its members.
+var membershipActions = []*models.IdmACLAction{{Name: "read"}, {Name: "write"}, {Name: "deny"}}
+
+// NodeAccess records the access conditions of a node: its workspace members, its own ACLs and its shares.
+// Parts that can't be collected are listed in Errors, the rest of the record is still usable.
+type NodeAccess struct {
+	NodeUUID  string           `json:"node_uuid"`
+	NodePath  string           `json:"node_path"`
+	Collected time.Time        `json:"collected"`
+	Workspace *AccessWorkspace `json:"workspace,omitempty"`
+	NodeACLs  []AccessACL      `json:"node_acls"`
+	Shares    []AccessShare    `json:"shares"`
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+// AccessWorkspace is the workspace a node is preserved from, with its owners and members.
+type AccessWorkspace struct {
+	UUID    string      `json:"uuid"`
+	Slug    string      `json:"slug"`
+	Label   string      `json:"label"`
+	Owners  []string    `json:"owners,omitempty"` // Policy subjects, e.g. user:<uuid>
+	Members []AccessACL `json:"members"`
+}
+
+// AccessACL grants or denies an action to a role, i.e. a user, group or team.
+type AccessACL struct {
+	RoleID      string `json:"role_id"`
+	Role        string `json:"role,omitempty"` // Role label, e.g. the user login
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	Action      string `json:"action"`
+	Value       string `json:"value,omitempty"`
+}
+
+// AccessShare is a cell or public link sharing a node.
+// Public link URLs aren't recorded: they grant access to anyone holding them, and the AIP outlives the link.
+type AccessShare struct {
+	Type             string      `json:"type"` // ShareTypeCell or ShareTypeLink
+	UUID             string      `json:"uuid"`
+	Label            string      `json:"label,omitempty"`
+	Owners           []string    `json:"owners,omitempty"`
+	AccessStart      string      `json:"access_start,omitempty"`
+	AccessEnd        string      `json:"access_end,omitempty"`
+	PasswordRequired bool        `json:"password_required,omitempty"`
+	TargetUsers      []string    `json:"target_users,omitempty"`
+	Members          []AccessACL `json:"members,omitempty"`
+}
+
+// GetNodeAccess collects the access conditions of a node in a workspace, with the admin APIs.
+// ACLs and shares are those of the node itself, not of its descendants. Never fails, errors are recorded in the result.
+// Admin Task. Cells SDK.
+func (c *Client) GetNodeAccess(ctx context.Context, workspaceSlug string, node *models.TreeNode) *NodeAccess {
+	access := &NodeAccess{NodeUUID: node.UUID, NodePath: node.Path, Collected: time.Now().UTC()}
+	roles := map[string]string{}
+
+	// Workspace owners and members
+	var workspaceUUID string
+	for _, w := range c.workspaces.get(ctx).Workspaces {
+		if w.Slug != workspaceSlug {
+			continue
+		}
+		workspaceUUID = w.UUID
+		access.Workspace = &AccessWorkspace{UUID: w.UUID, Slug: w.Slug, Label: w.Label, Owners: policyOwners(w.Policies)}
+		members, err := c.searchACLs(ctx, &models.IdmACLSingleQuery{WorkspaceIDs: []string{w.UUID}, Actions: membershipActions})
+		if err != nil {
+			access.Errors = append(access.Errors, fmt.Sprintf("workspace members: %v", err))
+		}
+		access.Workspace.Members = c.accessACLs(ctx, members, roles)
+		break
+	}
+	if access.Workspace == nil {
+		access.Errors = append(access.Errors, fmt.Sprintf("workspace not found: %s", workspaceSlug))
+	}
+
+	// Node ACLs, and the cells and links sharing the node
+	nodeACLs, err := c.searchACLs(ctx, &models.IdmACLSingleQuery{NodeIDs: []string{node.UUID}})
+	if err != nil {
+		access.Errors = append(access.Errors, fmt.Sprintf("node acls: %v", err))
+	}
+	access.NodeACLs = c.accessACLs(ctx, nodeACLs, roles)
+	seen := map[string]bool{workspaceUUID: true, "": true}
+	for _, acl := range nodeACLs {
+		if seen[acl.WorkspaceID] {
+			continue
+		}
+		seen[acl.WorkspaceID] = true
+		share, err := c.accessShare(ctx, acl.WorkspaceID, roles)
+		if err != nil {
+			access.Errors = append(access.Errors, fmt.Sprintf("share %s: %v", acl.WorkspaceID, err))
+			continue
+		}
+		if share != nil {
+			access.Shares = append(access.Shares, *share)
+		}
+	}
+	logger.Debug("Collected access of %s: %d node ACLs, %d shares, %d errors", node.Path, len(access.NodeACLs), len(access.Shares), len(access.Errors))
+	return access
+}
+
+// accessShare gets the cell or link of a workspace UUID. Returns nil for other workspaces.
+func (c *Client) accessShare(ctx context.Context, workspaceUUID string, roles map[string]string) (*AccessShare, error) {
+	var workspace *models.IdmWorkspace
+	err := utils.WithRetry(func() error {
+		var err error
+		workspace, err = sdkGetWorkspaceByUUID(ctx, *c.adminClient.client, workspaceUUID)
+		return err
+	})
+	if err != nil || workspace == nil || workspace.Scope == nil {
+		return nil, err
+	}
+
+	switch *workspace.Scope {
+	case models.IdmWorkspaceScopeROOM:
+		var cell *models.RestCell
+		err := utils.WithRetry(func() error {
+			var err error
+			cell, err = sdkGetCell(ctx, *c.adminClient.client, workspaceUUID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		share := &AccessShare{Type: ShareTypeCell, UUID: cell.UUID, Label: cell.Label, Owners: policyOwners(cell.Policies), AccessEnd: cell.AccessEnd}
+		for roleID, cellACL := range cell.ACLs {
+			label := roleID
+			if cellACL.User != nil {
+				label = cellACL.User.Login
+			} else if cellACL.Group != nil {
+				label = cellACL.Group.GroupLabel
+			} else if cellACL.Role != nil {
+				label = cellACL.Role.Label
+			}
+			for _, action := range cellACL.Actions {
+				share.Members = append(share.Members, AccessACL{RoleID: roleID, Role: label, Action: action.Name, Value: action.Value})
+			}
+		}
+		sortACLs(share.Members)
+		return share, nil
+	case models.IdmWorkspaceScopeLINK:
+		var link *models.RestShareLink
+		err := utils.WithRetry(func() error {
+			var err error
+			link, err = sdkGetShareLink(ctx, *c.adminClient.client, workspaceUUID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		share := &AccessShare{
+			Type:             ShareTypeLink,
+			UUID:             link.UUID,
+			Label:            link.Label,
+			Owners:           policyOwners(link.Policies),
+			AccessStart:      link.AccessStart,
+			AccessEnd:        link.AccessEnd,
+			PasswordRequired: link.PasswordRequired,
+		}
+		if link.UserLogin != "" {
+			share.Owners = append(share.Owners, "login:"+link.UserLogin)
+		}
+		for login := range link.TargetUsers {
+			share.TargetUsers = append(share.TargetUsers, login)
+		}
+		sort.Strings(share.TargetUsers)
+		return share, nil
+	}
+	return nil, nil
+}
+
+// searchACLs lists the ACLs matching a query, a page at a time.
+func (c *Client) searchACLs(ctx context.Context, query *models.IdmACLSingleQuery) ([]*models.IdmACL, error) {
+	var acls []*models.IdmACL
+	for offset := 0; ; offset += c.pageSize {
+		var page []*models.IdmACL
+		err := utils.WithRetry(func() error {
+			var err error
+			page, err = sdkSearchACLs(ctx, *c.adminClient.client, query, offset, c.pageSize)
+			return err
+		})
+		if err != nil {
+			return acls, err
+		}
+		acls = append(acls, page...)
+		if len(page) < c.pageSize {
+			return acls, nil
+		}
+	}
+}
+
+// accessACLs converts ACLs to their record, labelling their roles. Role labels are cached in roles.
+func (c *Client) accessACLs(ctx context.Context, acls []*models.IdmACL, roles map[string]string) []AccessACL {
+	result := make([]AccessACL, 0, len(acls))
+	for _, acl := range acls {
+		if acl.Action == nil {
+			continue
+		}
+		label, ok := roles[acl.RoleID]
+		if !ok {
+			// Unlabelled roles are still recorded by ID
+			if role, err := sdkGetRole(ctx, *c.adminClient.client, acl.RoleID); err == nil && role != nil {
+				label = role.Label
+			}
+			roles[acl.RoleID] = label
+		}
+		result = append(result, AccessACL{RoleID: acl.RoleID, Role: label, WorkspaceID: acl.WorkspaceID, Action: acl.Action.Name, Value: acl.Action.Value})
+	}
+	sortACLs(result)
+	return result
+}
+
+// policyOwners returns the subjects of the owner policies of a resource.
+func policyOwners(policies []*models.ServiceResourcePolicy) []string {
+	var owners []string
+	for _, policy := range policies {
+		if policy.Action != nil && *policy.Action == models.ServiceResourcePolicyActionOWNER {
+			owners = append(owners, policy.Subject)
+		}
+	}
+	return owners
+}
+
+func sortACLs(acls []AccessACL) {
+	sort.Slice(acls, func(i, j int) bool {
+		if acls[i].RoleID != acls[j].RoleID {
+			return acls[i].RoleID < acls[j].RoleID
+		}
+		return acls[i].Action < acls[j].Action
+	})
+}
@@ -17,12 +17,13 @@ import (
 )
 
 // apiGenerateUserToken generates a user token for the given user
+// The label identifies the token when listing the user's tokens, to find its UUID.
 // https://pydio.com/en/docs/developer-guide/post-aauthtokenimpersonate
-func apiGenerateUserToken(ctx context.Context, client *utils.HTTPClient, address, username, adminToken string, timeout time.Duration) (string, error) {
+func apiGenerateUserToken(ctx context.Context, client *utils.HTTPClient, address, username, adminToken, label string, timeout time.Duration) (string, error) {
 	url := fmt.Sprintf("%s/a/auth/token/impersonate", address)
 
 	body := map[string]any{
-		"Label":     "Preservation Token",
+		"Label":     label,
 		"UserLogin": username,
 		"ExpiresAt": time.Now().Add(timeout).Unix(),
 	}
@@ -66,37 +67,98 @@ func apiGenerateUserToken(ctx context.Context, client *utils.HTTPClient, address
 	return response.AccessToken, nil
 }
 
-// apiRevokeUserToken revoke a user token from the Cells server.
+// apiToken represents a personal access token listed from the Cells server.
+type apiToken struct {
+	UUID  string `json:"Uuid"`
+	Label string `json:"Label"`
+}
+
+// apiListUserTokens lists the personal access tokens of the given user.
+// https://pydio.com/en/docs/developer-guide/post-aauthtokens
+func apiListUserTokens(ctx context.Context, client *utils.HTTPClient, address, username, adminToken string) ([]apiToken, error) {
+	url := fmt.Sprintf("%s/a/auth/tokens", address)
+
+	body := map[string]any{
+		"Type":        "PERSONAL",
+		"ByUserLogin": username,
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling body: %w", err)
+	}
+
+	headers := map[string]string{
+		"Accept":        "application/json",
+		"Authorization": "Bearer " + adminToken,
+		"Content-Type":  "application/json",
+	}
+
+	resp, err := client.DoRequest(ctx, "POST", url, bytes.NewBuffer(jsonBody), headers)
+	if err != nil {
+		return nil, fmt.Errorf("error listing user tokens: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				logger.Error("Failed to close response body: %v", err)
+			}
+		}()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body: %w", err)
+		}
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Tokens []apiToken `json:"Tokens"`
+	}
+	if err := utils.ParseResponse(resp, &response); err != nil {
+		return nil, fmt.Errorf("error parsing response: %w", err)
+	}
+
+	return response.Tokens, nil
+}
+
+// apiRevokeUserToken revokes a user token from the Cells server, using the token UUID.
 // https://pydio.com/en/docs/developer-guide/delete-aauthtokenstokenid
-// TODO: This deosn't work because the token UUID is required, not the token itself.
-// Need to get the UUID but observing difference before and after generating.
-// But it is an imperfect solution.
-// func apiRevokeUserToken(ctx context.Context, client *utils.HttpClient, address, token, userToken string) error {
-// 	url := fmt.Sprintf("%s/a/auth/tokens/%s", address, userToken)
-// 	headers := map[string]string{
-// 		"Authorization": "Bearer " + token,
-// 		"Content-Type":  "application/json",
-// 	}
-// 	resp, err := client.DoRequest(ctx, "DELETE", url, nil, headers)
-// 	if err != nil {
-// 		return fmt.Errorf("error deleting user token: %w", err)
-// 	}
-// 	defer resp.Body.Close()
-// 	if resp.StatusCode != http.StatusOK {
-// 		body, _ := io.ReadAll(resp.Body)
-// 		return fmt.Errorf("unexpected status %d %s", resp.StatusCode, string(body))
-// 	}
-// 	var response struct {
-// 		Success bool `json:"success"`
-// 	}
-// 	if err := utils.ParseResponse(resp, &response); err != nil {
-// 		return fmt.Errorf("error parsing response: %w", err)
-// 	}
-// 	if !response.Success {
-// 		return fmt.Errorf("failed to delete user token")
-// 	}
-// 	return nil
-// }
+func apiRevokeUserToken(ctx context.Context, client *utils.HTTPClient, address, adminToken, tokenUUID string) error {
+	url := fmt.Sprintf("%s/a/auth/tokens/%s", address, tokenUUID)
+
+	headers := map[string]string{
+		"Accept":        "application/json",
+		"Authorization": "Bearer " + adminToken,
+	}
+
+	resp, err := client.DoRequest(ctx, "DELETE", url, nil, headers)
+	if err != nil {
+		return fmt.Errorf("error revoking user token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				logger.Error("Failed to close response body: %v", err)
+			}
+		}()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading response body: %w", err)
+		}
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Success bool `json:"Success"`
+	}
+	if err := utils.ParseResponse(resp, &response); err != nil {
+		return fmt.Errorf("error parsing response: %w", err)
+	}
+	if !response.Success {
+		return fmt.Errorf("failed to revoke user token %s", tokenUUID)
+	}
+	return nil
+}
 
 // Support for both premis metadatas until usermeta-premis-data is phased out
 // type NodeData struct {
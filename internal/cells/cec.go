@@ -40,7 +40,7 @@ func sanitizeStringArg(arg string) string {
 
 // DownloadNode downloads a node from Cells to a local directory using the CEC binary
 // Returns the output of the command
-func cecDownloadNode(ctx context.Context, cecPath, address, username, token, cellsSrc, dest string) ([]byte, error) {
+func cecDownloadNode(ctx context.Context, cecPath, address, username, token, cellsSrc, dest string, insecure bool) ([]byte, error) {
 	// Sanitize inputs to prevent command injection
 	cecPath = sanitizePathArg(cecPath)
 	address = sanitizeStringArg(address)
@@ -50,7 +50,8 @@ func cecDownloadNode(ctx context.Context, cecPath, address, username, token, cel
 
 	logger.Debug("Downloading {cecPath: %s, address: %s, username: %s, cellsSrc: %s, dest: %s}", cecPath, address, username, cellsSrc, dest)
 	// #nosec G204 -- input arguments are sanitized above
-	cmd := exec.CommandContext(ctx, cecPath, "scp", "-n", "--url", address, "--skip-verify", "--login", username, "--token", token, fmt.Sprintf("cells://%s/", cellsSrc), dest)
+	args := append(cecConnectionArgs(address, username, token, insecure), fmt.Sprintf("cells://%s/", cellsSrc), dest)
+	cmd := exec.CommandContext(ctx, cecPath, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		logger.Error("CEC download node: %v", err)
@@ -61,7 +62,7 @@ func cecDownloadNode(ctx context.Context, cecPath, address, username, token, cel
 
 // UploadNode uploads a node from a local directory to Cells using the CEC binary
 // Returns the output of the command
-func cecUploadNode(ctx context.Context, cecPath, address, username, token, src, cellsDest string) ([]byte, error) {
+func cecUploadNode(ctx context.Context, cecPath, address, username, token, src, cellsDest string, insecure bool) ([]byte, error) {
 	// Sanitize inputs to prevent command injection
 	cecPath = sanitizePathArg(cecPath)
 	address = sanitizeStringArg(address)
@@ -71,7 +72,8 @@ func cecUploadNode(ctx context.Context, cecPath, address, username, token, src,
 
 	logger.Debug("Uploading {cecPath: %s, address: %s, username: %s, src: %s, cellsDest: %s}", cecPath, address, username, src, cellsDest)
 	// #nosec G204 -- input arguments are sanitized above
-	cmd := exec.CommandContext(ctx, cecPath, "scp", "-n", "--url", address, "--skip-verify", "--login", username, "--token", token, src, fmt.Sprintf("cells://%s/", cellsDest))
+	args := append(cecConnectionArgs(address, username, token, insecure), src, fmt.Sprintf("cells://%s/", cellsDest))
+	cmd := exec.CommandContext(ctx, cecPath, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		logger.Error("CEC upload node: %v", err)
@@ -79,3 +81,12 @@ func cecUploadNode(ctx context.Context, cecPath, address, username, token, src,
 	}
 	return output, nil
 }
+
+// cecConnectionArgs returns the cec scp arguments for the connection. TLS verification is only skipped if insecure.
+func cecConnectionArgs(address, username, token string, insecure bool) []string {
+	args := []string{"scp", "-n", "--url", address}
+	if insecure {
+		args = append(args, "--skip-verify")
+	}
+	return append(args, "--login", username, "--token", token)
+}
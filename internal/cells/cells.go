@@ -5,26 +5,43 @@ package cells
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"path"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-openapi/runtime"
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
 	"github.com/penwern/curate-preservation-core/pkg/logger"
 	"github.com/penwern/curate-preservation-core/pkg/utils"
 	"github.com/pydio/cells-sdk-go/v4/client"
 	"github.com/pydio/cells-sdk-go/v4/models"
 )
 
+// ErrNodeNotFound is returned when listing or looking up a node that doesn't exist.
+var ErrNodeNotFound = errors.New("node not found")
+
 // Client represents a Cells client.
 type Client struct {
-	address             string                          // Cells http(s) address. Remove in favour of adminClient?
-	adminClient         *AdminClient                    // Cells admin client
-	cecPath             string                          // Path to cec binary. Only used for cec binary
-	httpClient          *utils.HTTPClient               // User for generating and revoking tokens
-	workspaceCollection *models.RestWorkspaceCollection // Cells workspace collection. For parsing template paths
+	address     string            // Cells http(s) address. Remove in favour of adminClient?
+	adminClient *AdminClient      // Cells admin client
+	cecPath     string            // Path to cec binary. Only used for cec binary
+	httpClient  *utils.HTTPClient // User for generating and revoking tokens
+	insecure    bool              // Skip TLS verification for transfers
+	transfer    TransferOptions   // Content transfer backend and options
+	tokenTTL    time.Duration     // Lifetime of user impersonation tokens
+	pageSize    int               // Nodes listed per tree listing request
+	workspaces  *workspaceCache   // Cells workspace collection. For parsing template paths
+
+	sessionsMu sync.Mutex
+	sessions   map[*tokenSession]struct{} // Open user token sessions. Revoked on close
 }
 
 // AdminClient represents a Cells admin client.
@@ -34,27 +51,54 @@ type AdminClient struct {
 }
 
 // UserClient represents a Cells user client.
+// Its impersonation token is refreshed while the client is open. Close it with CloseUserClient to revoke the token.
 type UserClient struct {
-	client    *client.PydioCellsRestAPI
-	UserData  *models.IdmUser
-	userToken string
+	client   *client.PydioCellsRestAPI
+	UserData *models.IdmUser
+	session  *tokenSession
 }
 
 // ClientInterface defines the interface for the Cells client.
 type ClientInterface interface {
 	Close()
-	DownloadNode(ctx context.Context, userClient UserClient, cellsSrc, dest string) (string, error)
-	GetNodeCollection(ctx context.Context, absNodePath string) (*models.RestNodesCollection, error)
+	CloseUserClient(ctx context.Context, userClient UserClient) error
+	CreateFolder(ctx context.Context, userClient UserClient, folderPath string) error
+	DeleteNode(ctx context.Context, userClient UserClient, nodePath string) error
+	MoveNode(ctx context.Context, userClient UserClient, nodeUUID, nodePath, targetPath string) (string, error)
+	DownloadNode(ctx context.Context, userClient UserClient, jobID, cellsSrc, dest string) (string, error)
+	WalkNodeCollection(ctx context.Context, absNodePath string, fn func(node *models.TreeNode) error) (*models.TreeNode, error)
+	WalkNodeChildren(ctx context.Context, absNodePath string, fn func(node *models.TreeNode) error) (*models.TreeNode, error)
+	GetNodeByUUID(ctx context.Context, nodeUUID string) (*models.TreeNode, error)
+	GetNodeAccess(ctx context.Context, workspaceSlug string, node *models.TreeNode) *NodeAccess
 	GetNodeStats(ctx context.Context, absNodePath string) (*models.TreeReadNodeResponse, error)
 	NewUserClient(ctx context.Context, username string, insecure bool) (UserClient, error)
+	RefreshWorkspaces(ctx context.Context) error
 	ResolveCellsPath(userClient UserClient, cellsPath string) (string, error)   // e.g. personal-files/file -> personal/username/file
 	UnresolveCellsPath(userClient UserClient, cellsPath string) (string, error) // e.g. personal/username/file -> personal-files/file
 	UpdateTag(ctx context.Context, userClient UserClient, nodeUUID, namespace, content string) error
-	UploadNode(ctx context.Context, userClient UserClient, src, cellsDest string) (string, error)
+	UpdateTags(ctx context.Context, userClient UserClient, nodeUUID string, tags map[string]string) error
+	UpdateMeta(ctx context.Context, userClient UserClient, nodeUUID string, values map[string]json.RawMessage) error
+	DeleteMeta(ctx context.Context, userClient UserClient, nodeUUID string, namespaces []string) error
+	AdminUserClient() UserClient
+	UploadNode(ctx context.Context, userClient UserClient, jobID, src, cellsDest string) (string, error)
+	AbortUploads(ctx context.Context, userClient UserClient, jobID string) error
+	VerifyUploadedNode(node *models.TreeNode, localPath string) error
 }
 
+// defaultPageSize is the number of nodes listed per tree listing request.
+const defaultPageSize = 1000
+
 // NewClient creates a new Cells client for managing Cells related tasks.
-func NewClient(ctx context.Context, cecPath, address, adminToken string, insecure bool) (*Client, error) {
+// Content is transferred with the backend selected in the transfer options.
+// User impersonation tokens are issued for tokenTTL, 30 minutes if zero, and refreshed before they expire.
+// The workspace collection is refreshed once older than workspaceTTL. Only on cache misses and on demand if zero.
+// Node collections are listed pageSize nodes at a time, 1000 if zero.
+func NewClient(ctx context.Context, cecPath, address, adminToken string, insecure bool, transfer TransferOptions, tokenTTL, workspaceTTL time.Duration, pageSize int) (*Client, error) {
+	transfer = transfer.withDefaults()
+	if err := transfer.validate(); err != nil {
+		return nil, err
+	}
+
 	// We can use a short http timeout. This is only used for token gen.
 	httpClient := utils.NewHTTPClient(5*time.Second, insecure)
 
@@ -64,75 +108,108 @@ func NewClient(ctx context.Context, cecPath, address, adminToken string, insecur
 	}
 	address = url.Scheme + "://" + url.Host
 
-	adminClient, err := newSDKClient(url.Scheme, url.Host, "/a", insecure, adminToken)
+	adminClient, err := newSDKClient(url.Scheme, url.Host, "/a", insecure, httptransport.BearerToken(adminToken))
 	if err != nil {
 		return nil, fmt.Errorf("error creating admin client: %v", err)
 	}
 
+	if tokenTTL <= 0 {
+		tokenTTL = defaultTokenTTL
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
 	client := &Client{
 		cecPath:    cecPath,
 		address:    address,
 		httpClient: httpClient,
+		insecure:   insecure,
+		transfer:   transfer,
+		tokenTTL:   tokenTTL,
+		pageSize:   pageSize,
+		sessions:   make(map[*tokenSession]struct{}),
 		adminClient: &AdminClient{
 			client: adminClient,
 			token:  adminToken,
 		},
 	}
 
-	client.workspaceCollection, err = client.getWorkspaceCollection(ctx)
-	if err != nil {
+	client.workspaces = newWorkspaceCache(client.getWorkspaceCollection, workspaceTTL)
+	if err := client.workspaces.refresh(ctx); err != nil {
 		return nil, fmt.Errorf("error getting workspace collection: %v", err)
 	}
-	logger.Debug("Client created: {address: %s, cecPath: %s}", address, cecPath)
+	logger.Debug("Client created: {address: %s, cecPath: %s, transferBackend: %s}", address, cecPath, transfer.Backend)
 	return client, nil
 }
 
 // NewUserClient creates a new Cells user client.
+// The user is impersonated with a token that is refreshed until the client is closed with CloseUserClient.
 func (c *Client) NewUserClient(ctx context.Context, username string, insecure bool) (UserClient, error) {
 	url, err := url.Parse(c.address)
 	if err != nil {
 		return UserClient{}, fmt.Errorf("error parsing address: %v", err)
 	}
 
-	userToken, err := c.generateUserToken(ctx, username, 30*time.Minute)
+	userData, err := c.getUserData(ctx, username)
 	if err != nil {
-		return UserClient{}, fmt.Errorf("error generating user token: %v", err)
+		return UserClient{}, fmt.Errorf("error retrieving user data: %v", err)
 	}
-	if userToken == "" {
-		return UserClient{}, fmt.Errorf("user token is empty")
+	if userData == nil {
+		return UserClient{}, fmt.Errorf("user data is nil for username: %s", username)
 	}
 
-	userSDKClient, err := newSDKClient(url.Scheme, url.Host, "/a", insecure, userToken)
+	session, err := c.newTokenSession(ctx, username)
 	if err != nil {
-		return UserClient{}, fmt.Errorf("error creating user client: %v", err)
+		return UserClient{}, err
 	}
 
-	userData, err := c.getUserData(ctx, username)
+	// Read the token on every request, so refreshed tokens are picked up
+	auth := runtime.ClientAuthInfoWriterFunc(func(r runtime.ClientRequest, _ strfmt.Registry) error {
+		return r.SetHeaderParam(runtime.HeaderAuthorization, "Bearer "+session.token())
+	})
+	userSDKClient, err := newSDKClient(url.Scheme, url.Host, "/a", insecure, auth)
 	if err != nil {
-		return UserClient{}, fmt.Errorf("error retrieving user data: %v", err)
-	}
-	if userData == nil {
-		return UserClient{}, fmt.Errorf("user data is nil for username: %s", username)
+		if closeErr := session.close(ctx); closeErr != nil {
+			logger.Error("Error closing user token session: %v", closeErr)
+		}
+		return UserClient{}, fmt.Errorf("error creating user client: %v", err)
 	}
 
 	user := UserClient{
-		client:    userSDKClient,
-		userToken: userToken,
-		UserData:  userData,
+		client:   userSDKClient,
+		session:  session,
+		UserData: userData,
 	}
 	logger.Debug("User client created: {username: %s, login: %s}", username, userData.Login)
 	return user, nil
 }
 
-// Close closes the Cells client.
+// CloseUserClient stops refreshing the user client token and revokes every token issued for it.
+func (c *Client) CloseUserClient(ctx context.Context, userClient UserClient) error {
+	if userClient.session == nil {
+		return nil
+	}
+	return userClient.session.close(ctx)
+}
+
+// Close closes the Cells client. Tokens of user clients that are still open are revoked.
 func (c *Client) Close() {
+	c.sessionsMu.Lock()
+	sessions := make([]*tokenSession, 0, len(c.sessions))
+	for session := range c.sessions {
+		sessions = append(sessions, session)
+	}
+	c.sessionsMu.Unlock()
+	for _, session := range sessions {
+		if err := session.close(context.Background()); err != nil {
+			logger.Error("Error closing user token session: %v", err)
+		}
+	}
+
 	if c.httpClient != nil {
 		c.httpClient.Close()
 	}
-	// if err := c.removeUserToken(ctx, c.userClient.userToken); err != nil {
-	// 	log.Printf("error removing user token: %v", err)
-	// }
-	// log.Println("Removed user token")
 }
 
 ///////////////////////////////////////////////////////////////////
@@ -148,34 +225,115 @@ func (c *Client) UpdateTag(ctx context.Context, userClient UserClient, nodeUUID,
 	return err
 }
 
-// GetNodeCollection gets a collection of nodes from a given path.
-// It requires the absolute, fully qualified node path.
-// Admin Task. Cells SDK.
-func (c *Client) GetNodeCollection(ctx context.Context, absNodePath string) (*models.RestNodesCollection, error) {
-	var result *models.RestNodesCollection
-	err := utils.WithRetry(func() error {
-		var err error
-		result, err = sdkGetNodeCollection(ctx, *c.adminClient.client, absNodePath)
-		return err
-	})
-	// If 404 log node not found
-	if err != nil {
-		if strings.Contains(err.Error(), "404") {
-			logger.Debug("Node not found: %s", absNodePath)
-			return nil, fmt.Errorf("node not found: %s", absNodePath)
+// UpdateTags sets several metadata namespaces of a node at once, keyed by namespace.
+// Cells SDK.
+func (c *Client) UpdateTags(ctx context.Context, userClient UserClient, nodeUUID string, tags map[string]string) error {
+	values := make(map[string]json.RawMessage, len(tags))
+	for namespace, content := range tags {
+		jsonValue, err := json.Marshal(content)
+		if err != nil {
+			return fmt.Errorf("error encoding metadata {namespace: %s, nodeUuid: %s}: %v", namespace, nodeUUID, err)
 		}
-		return nil, err
+		values[namespace] = jsonValue
 	}
-	if result == nil {
-		return nil, fmt.Errorf("node collection is nil for path: %s", absNodePath)
+	return c.UpdateMeta(ctx, userClient, nodeUUID, values)
+}
+
+// UpdateMeta sets several metadata namespaces of a node at once to raw JSON values, keyed by namespace.
+// Cells SDK.
+func (c *Client) UpdateMeta(ctx context.Context, userClient UserClient, nodeUUID string, values map[string]json.RawMessage) error {
+	if len(values) == 0 {
+		return nil
 	}
-	if result.Parent == nil {
-		return nil, fmt.Errorf("parent node is nil for path: %s", absNodePath)
+	return utils.WithRetry(func() error {
+		return sdkUpdateUserMetas(ctx, *userClient.client, nodeUUID, values)
+	})
+}
+
+// DeleteMeta deletes metadata namespaces of a node.
+// Cells SDK.
+func (c *Client) DeleteMeta(ctx context.Context, userClient UserClient, nodeUUID string, namespaces []string) error {
+	if len(namespaces) == 0 {
+		return nil
 	}
-	return result, nil
+	return utils.WithRetry(func() error {
+		return sdkDeleteUserMetas(ctx, *userClient.client, nodeUUID, namespaces)
+	})
 }
 
-// GetNodeStats gets the stats of a node from a given path.
+// AdminUserClient returns a user client acting as the admin, for admin tasks on metadata of nodes the admin can edit.
+// It has no user data and doesn't need closing.
+func (c *Client) AdminUserClient() UserClient {
+	return UserClient{client: c.adminClient.client}
+}
+
+// WalkNodeCollection calls fn for each node below a given path, recursively, and returns the node itself.
+// Nodes are listed a page at a time, so the collection is never held in memory as a whole.
+// It requires the absolute, fully qualified node path.
+// Admin Task. Cells SDK.
+func (c *Client) WalkNodeCollection(ctx context.Context, absNodePath string, fn func(node *models.TreeNode) error) (*models.TreeNode, error) {
+	return c.walkNodes(ctx, absNodePath, true, fn)
+}
+
+// WalkNodeChildren calls fn for each direct child of a given path, and returns the node itself.
+// It requires the absolute, fully qualified node path.
+// Admin Task. Cells SDK.
+func (c *Client) WalkNodeChildren(ctx context.Context, absNodePath string, fn func(node *models.TreeNode) error) (*models.TreeNode, error) {
+	return c.walkNodes(ctx, absNodePath, false, fn)
+}
+
+// walkNodes lists the nodes below a path a page at a time, recursively or only its children.
+func (c *Client) walkNodes(ctx context.Context, absNodePath string, recursive bool, fn func(node *models.TreeNode) error) (*models.TreeNode, error) {
+	var parent *models.TreeNode
+	firstUUIDs := map[string]struct{}{} // First node of each page
+	for offset := 0; ; offset += c.pageSize {
+		var page *models.RestNodesCollection
+		err := utils.WithRetry(func() error {
+			var err error
+			page, err = sdkGetNodeCollection(ctx, *c.adminClient.client, absNodePath, recursive, offset, c.pageSize)
+			return err
+		})
+		// If 404 log node not found
+		if err != nil {
+			if strings.Contains(err.Error(), "404") {
+				logger.Debug("Node not found: %s", absNodePath)
+				return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, absNodePath)
+			}
+			return nil, err
+		}
+		if page == nil {
+			return nil, fmt.Errorf("node collection is nil for path: %s", absNodePath)
+		}
+		if parent == nil {
+			if page.Parent == nil {
+				return nil, fmt.Errorf("parent node is nil for path: %s", absNodePath)
+			}
+			parent = page.Parent
+		}
+		// A server ignoring the limit or offset would otherwise list the same nodes forever
+		if len(page.Children) > c.pageSize {
+			return nil, fmt.Errorf("listing %s returned %d nodes, more than the page size of %d", absNodePath, len(page.Children), c.pageSize)
+		}
+		if len(page.Children) > 0 {
+			first := page.Children[0].UUID
+			if _, seen := firstUUIDs[first]; seen {
+				return nil, fmt.Errorf("listing %s returned node %s again at offset %d, paging isn't supported", absNodePath, first, offset)
+			}
+			firstUUIDs[first] = struct{}{}
+		}
+		for _, node := range page.Children {
+			if err := fn(node); err != nil {
+				return nil, err
+			}
+		}
+		if len(page.Children) < c.pageSize {
+			return parent, nil
+		}
+		logger.Debug("Listed %d nodes below %s", offset+len(page.Children), absNodePath)
+	}
+}
+
+// GetNodeStats gets the stats of a node from a given path. Returns ErrNodeNotFound if there is none.
 // It requires the absolute, fully qualified node path.
 // Admin Task. Cells SDK.
 func (c *Client) GetNodeStats(ctx context.Context, absNodePath string) (*models.TreeReadNodeResponse, error) {
@@ -185,9 +343,31 @@ func (c *Client) GetNodeStats(ctx context.Context, absNodePath string) (*models.
 		result, err = sdkGetNodeStats(ctx, *c.adminClient.client, absNodePath)
 		return err
 	})
+	if err != nil && strings.Contains(err.Error(), "404") {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotFound, absNodePath)
+	}
 	return result, err
 }
 
+// GetNodeByUUID gets a node from its UUID.
+// The node path is its current absolute path, so it follows the node when it is renamed or moved.
+// Admin Task. Cells SDK.
+func (c *Client) GetNodeByUUID(ctx context.Context, nodeUUID string) (*models.TreeNode, error) {
+	var result *models.TreeReadNodeResponse
+	err := utils.WithRetry(func() error {
+		var err error
+		result, err = sdkGetNodeByUUID(ctx, *c.adminClient.client, nodeUUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || result.Node == nil {
+		return nil, fmt.Errorf("node not found: %s", nodeUUID)
+	}
+	return result.Node, nil
+}
+
 // GetWorkspaceCollection get the collection of Pydio Cells workspaces.
 // Admin not required. Used as User generated after execution. Cells SDK.
 func (c *Client) getWorkspaceCollection(ctx context.Context) (*models.RestWorkspaceCollection, error) {
@@ -214,64 +394,267 @@ func (c *Client) getUserData(ctx context.Context, username string) (*models.IdmU
 
 // generateUserToken generates a user token for a given user.
 // Admin Task. Cells API. Returns the user token.
-func (c *Client) generateUserToken(ctx context.Context, user string, duration time.Duration) (string, error) {
+func (c *Client) generateUserToken(ctx context.Context, user, label string, duration time.Duration) (string, error) {
 	var result string
 	err := utils.WithRetry(func() error {
 		var err error
-		result, err = apiGenerateUserToken(ctx, c.httpClient, c.address, user, c.adminClient.token, duration)
+		result, err = apiGenerateUserToken(ctx, c.httpClient, c.address, user, c.adminClient.token, label, duration)
 		return err
 	})
 	return result, err
 }
 
-// removeUserToken removes a user token from the Cells server.
-// Admin Task. Removed because it doesn't work. Need the token UUID
-// func (c *Client) removeUserToken(ctx context.Context, userToken string) error {
-// 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-// 	defer cancel()
-// 	return apiRevokeUserToken(ctx, c.httpClient, c.address, c.adminToken, userToken)
-// }
-
 ///////////////////////////////////////////////////////////////////
-//						  Cells CEC 							 //
+//						  Transfers 							 //
 ///////////////////////////////////////////////////////////////////
 
-// DownloadNode downloads a node from Cells to a local directory using the CEC binary.
+// DownloadNode downloads a node from Cells to a local directory using the configured transfer backend.
+// Partial sdk downloads only resume within the same job of the same user, e.g. the node UUID being preserved.
 // Returns the path of the downloaded node.
-func (c *Client) DownloadNode(ctx context.Context, userClient UserClient, cellsSrc, dest string) (string, error) {
-	if _, err := cecDownloadNode(ctx, c.cecPath, c.address, userClient.UserData.Login, userClient.userToken, cellsSrc, dest); err != nil {
+func (c *Client) DownloadNode(ctx context.Context, userClient UserClient, jobID, cellsSrc, dest string) (string, error) {
+	if c.transfer.Backend == TransferBackendSDK {
+		s3Client, err := newS3Client(ctx, c.address, userClient.session.token, c.insecure)
+		if err != nil {
+			return "", fmt.Errorf("error creating transfer client: %w", err)
+		}
+		folders, err := c.nodeFolders(ctx, userClient, cellsSrc)
+		if err != nil {
+			return "", fmt.Errorf("error listing node folders: %w", err)
+		}
+		downloadedPath, err := sdkDownloadNode(ctx, s3Client, c.transferOptions(ctx, userClient, jobID), cellsSrc, dest, folders)
+		if err != nil {
+			return "", fmt.Errorf("error downloading node: %w", err)
+		}
+		return downloadedPath, nil
+	}
+	if _, err := cecDownloadNode(ctx, c.cecPath, c.address, userClient.UserData.Login, userClient.session.token(), cellsSrc, dest, c.insecure); err != nil {
 		return "", fmt.Errorf("error downloading node: %w", err)
 	}
 	return filepath.Join(dest, filepath.Base(cellsSrc)), nil
 }
 
-// UploadNode uploads a node from a local directory to Cells using the CEC binary.
-// Returns the path of the uploaded node.
-// TODO: Confirm if the upload path is correct (coz duplication)
-func (c *Client) UploadNode(ctx context.Context, userClient UserClient, src, cellsDest string) (string, error) {
-	if _, err := cecUploadNode(ctx, c.cecPath, c.address, userClient.UserData.Login, userClient.userToken, src, cellsDest); err != nil {
+// CreateFolder creates a folder and its missing parents, from a workspace path, e.g. common-files/aips/2025.
+// An existing folder is left as it is.
+func (c *Client) CreateFolder(ctx context.Context, userClient UserClient, folderPath string) error {
+	folderPath = strings.Trim(folderPath, "/")
+	return utils.WithRetry(func() error {
+		node, err := sdkHeadNode(ctx, *userClient.client, folderPath)
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			if node.Type != nil && *node.Type != models.TreeNodeTypeCOLLECTION {
+				return fmt.Errorf("not a folder: %s", folderPath)
+			}
+			return nil
+		}
+		logger.Debug("Creating folder: %s", folderPath)
+		return sdkCreateFolder(ctx, *userClient.client, folderPath)
+	})
+}
+
+// DeleteNode deletes a node and its children, from a workspace path.
+// Deleted nodes go to the recycle bin if the workspace has one.
+func (c *Client) DeleteNode(ctx context.Context, userClient UserClient, nodePath string) error {
+	nodePath = strings.Trim(nodePath, "/")
+	return utils.WithRetry(func() error {
+		return sdkDeleteNode(ctx, *userClient.client, nodePath)
+	})
+}
+
+// moveNodeTimeout bounds the wait for a Cells move job to move a node.
+const moveNodeTimeout = 10 * time.Minute
+
+// MoveNode moves a node into a target folder, from workspace paths, and waits until it is moved.
+// Cells moves nodes with an asynchronous job, so the node is looked up by UUID until its parent is the target.
+// Returns the node's new workspace path.
+func (c *Client) MoveNode(ctx context.Context, userClient UserClient, nodeUUID, nodePath, targetPath string) (string, error) {
+	nodePath = strings.Trim(nodePath, "/")
+	targetPath = strings.Trim(targetPath, "/")
+	resolvedTarget, err := c.ResolveCellsPath(userClient, targetPath)
+	if err != nil {
+		return "", fmt.Errorf("error resolving move target: %w", err)
+	}
+	var jobUUID string
+	err = utils.WithRetry(func() error {
+		var err error
+		jobUUID, err = sdkMoveNode(ctx, *userClient.client, nodePath, targetPath)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	logger.Debug("Started move job %s: %s -> %s", jobUUID, nodePath, targetPath)
+
+	ctx, cancel := context.WithTimeout(ctx, moveNodeTimeout)
+	defer cancel()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		node, err := c.GetNodeByUUID(ctx, nodeUUID)
+		if err != nil {
+			return "", fmt.Errorf("error checking moved node: %w", err)
+		}
+		if path.Dir(strings.Trim(node.Path, "/")) == strings.Trim(resolvedTarget, "/") {
+			return path.Join(targetPath, path.Base(node.Path)), nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("move job %s didn't move %s to %s: %w", jobUUID, nodePath, targetPath, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// UploadNode uploads a node from a local directory to Cells using the configured transfer backend.
+// Partial sdk uploads only resume within the same job of the same user.
+// Returns the path the node was uploaded to. Cells may rename it on upload, so read the uploaded node back from its destination.
+func (c *Client) UploadNode(ctx context.Context, userClient UserClient, jobID, src, cellsDest string) (string, error) {
+	if c.transfer.Backend == TransferBackendSDK {
+		s3Client, err := newS3Client(ctx, c.address, userClient.session.token, c.insecure)
+		if err != nil {
+			return "", fmt.Errorf("error creating transfer client: %w", err)
+		}
+		uploadedPath, err := sdkUploadNode(ctx, s3Client, c.transferOptions(ctx, userClient, jobID), src, cellsDest)
+		if err != nil {
+			return "", fmt.Errorf("error uploading node: %w", err)
+		}
+		return uploadedPath, nil
+	}
+	if _, err := cecUploadNode(ctx, c.cecPath, c.address, userClient.UserData.Login, userClient.session.token(), src, cellsDest, c.insecure); err != nil {
 		return "", fmt.Errorf("error uploading node: %w", err)
 	}
 	return filepath.Join(cellsDest, filepath.Base(src)), nil
 }
 
+// VerifyUploadedNode verifies a Cells file node against the local file it was uploaded from: its size, and its ETag against the MD5 of the file.
+func (c *Client) VerifyUploadedNode(node *models.TreeNode, localPath string) error {
+	size, err := strconv.ParseInt(node.Size, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid node size %q: %w", node.Size, err)
+	}
+	return verifyUpload(c.transfer, localPath, size, node.Etag)
+}
+
+// nodeFolders lists the folders of a node, from a workspace path, as slash paths relative to it with "." for the node itself.
+// Returns nil if the node is a file.
+func (c *Client) nodeFolders(ctx context.Context, userClient UserClient, cellsPath string) ([]string, error) {
+	absPath, err := c.ResolveCellsPath(userClient, cellsPath)
+	if err != nil {
+		return nil, err
+	}
+	var folderPaths []string
+	root, err := c.WalkNodeCollection(ctx, strings.Trim(absPath, "/"), func(node *models.TreeNode) error {
+		if node.Type != nil && *node.Type == models.TreeNodeTypeCOLLECTION {
+			folderPaths = append(folderPaths, strings.Trim(node.Path, "/"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root.Type == nil || *root.Type != models.TreeNodeTypeCOLLECTION {
+		return nil, nil
+	}
+	rootPath := strings.Trim(root.Path, "/")
+	folders := []string{"."}
+	for _, folderPath := range folderPaths {
+		if rel, ok := strings.CutPrefix(folderPath, rootPath+"/"); ok {
+			folders = append(folders, rel)
+		}
+	}
+	return folders, nil
+}
+
+// transferOptions returns the transfer options for a job of a user, removing the abandoned transfers of previous jobs first.
+func (c *Client) transferOptions(ctx context.Context, userClient UserClient, jobID string) TransferOptions {
+	removeStaleTransferStates(c.transfer.StateDir, transferStateMaxAge, func(state *transferState) error {
+		return c.abortStaleUpload(ctx, state)
+	})
+	options := c.transfer
+	options.scope = transferScope(userClient.UserData.Login, jobID)
+	return options
+}
+
+// abortStaleUpload aborts the multipart upload of an abandoned transfer, as the user it belongs to.
+func (c *Client) abortStaleUpload(ctx context.Context, state *transferState) error {
+	userClient, err := c.NewUserClient(ctx, scopeUser(state.Scope), c.insecure)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.CloseUserClient(ctx, userClient); err != nil {
+			logger.Warn("Failed to close user client: %v", err)
+		}
+	}()
+	s3Client, err := newS3Client(ctx, c.address, userClient.session.token, c.insecure)
+	if err != nil {
+		return fmt.Errorf("error creating transfer client: %w", err)
+	}
+	return abortMultipart(ctx, s3Client, state.Key, state.UploadID)
+}
+
+// AbortUploads aborts the partial sdk uploads of a job of a user, and removes their state.
+// Uploads only resume within a job, so once the upload of a job finally fails its partial uploads are of no use.
+func (c *Client) AbortUploads(ctx context.Context, userClient UserClient, jobID string) error {
+	if c.transfer.Backend != TransferBackendSDK {
+		return nil
+	}
+	scope := transferScope(userClient.UserData.Login, jobID)
+	var states []*transferState
+	for _, state := range readTransferStates(c.transfer.StateDir, "upload") {
+		if state.Scope == scope {
+			states = append(states, state)
+		}
+	}
+	if len(states) == 0 {
+		return nil
+	}
+	s3Client, err := newS3Client(ctx, c.address, userClient.session.token, c.insecure)
+	if err != nil {
+		return fmt.Errorf("error creating transfer client: %w", err)
+	}
+	for _, state := range states {
+		if state.UploadID != "" {
+			if err := abortMultipart(ctx, s3Client, state.Key, state.UploadID); err != nil {
+				logger.Warn("Failed to abort upload of %s: %v", state.Key, err)
+			}
+		}
+		state.remove()
+	}
+	return nil
+}
+
 ///////////////////////////////////////////////////////////////////
 //						  	Utils								 //
 ///////////////////////////////////////////////////////////////////
 
 // ResolveCellsPath resolves a cells path to an absolute path.
 // The path is resolved by replacing the workspace root with the resolved workspace root.
+// The workspace collection is refreshed if the workspace is not found, in case it was created since the last refresh.
 func (c *Client) ResolveCellsPath(userClient UserClient, cellsPath string) (string, error) {
+	ctx := context.Background()
+	resolvedPath, err := c.resolveCellsPath(c.workspaces.get(ctx), userClient, cellsPath)
+	if errors.Is(err, errWorkspaceNotFound) && c.workspaces.refreshOnMiss(ctx) {
+		return c.resolveCellsPath(c.workspaces.get(ctx), userClient, cellsPath)
+	}
+	return resolvedPath, err
+}
+
+// resolveCellsPath resolves a cells path to an absolute path using the workspace collection.
+// A single root workspace maps its slug to the root, e.g. personal-files/file -> personal/username/file.
+// A multi-root workspace lists its roots by base name, e.g. projects/alpha/file -> pydiods1/alpha/file.
+func (c *Client) resolveCellsPath(workspaceCollection *models.RestWorkspaceCollection, userClient UserClient, cellsPath string) (string, error) {
 	// Get the workspace from the cells path
-	pathParts := strings.Split(cellsPath, "/")
-	if len(pathParts) == 0 {
+	pathParts := strings.Split(strings.Trim(cellsPath, "/"), "/")
+	if pathParts[0] == "" {
 		return "", fmt.Errorf("invalid cells path: %s", cellsPath)
 	}
 	workspaceRoot := pathParts[0]
 
 	// Find the workspace in the workspace collection with the same slug
 	var workspace *models.IdmWorkspace
-	for _, w := range c.workspaceCollection.Workspaces {
+	for _, w := range workspaceCollection.Workspaces {
 		if w.Slug == workspaceRoot {
 			workspace = w
 			break
@@ -280,130 +663,115 @@ func (c *Client) ResolveCellsPath(userClient UserClient, cellsPath string) (stri
 
 	// Error if workspace collection is empty
 	if workspace == nil {
-		return "", fmt.Errorf("workspace not found: %s", workspaceRoot)
+		return "", fmt.Errorf("%w: %s", errWorkspaceNotFound, workspaceRoot)
 	}
 
 	// Error if workspace has no root nodes
-	if workspace.RootNodes == nil {
+	if len(workspace.RootNodes) == 0 {
 		return "", fmt.Errorf("workspace has no root nodes: %s", workspaceRoot)
 	}
 
 	logger.Debug("Selected workspace: %s", workspace.Slug)
 
-	// Find the resolution for the workspace if it uses a template path (i.e. not a DATASOURCE root)
-	// Store the datasource path for later use if a resolution is not found
-	var resolution string
-	var datasource string
-	// TODO: The case of multiple root nodes required further testing. We will search for a resolution and fall back to the datasource if not found.
-	for root, rootNode := range workspace.RootNodes {
-		// Ignore if the root node is a DATASOURCE, a.k.a. not a templated workspace
-		if !strings.HasPrefix(root, "DATASOURCE") {
-			resolution = rootNode.MetaStore["resolution"]
-			break
-		}
-		datasource = strings.TrimSuffix(rootNode.Path, "/")
+	roots, err := workspaceRoots(workspace, newTemplateUser(userClient.UserData))
+	if err != nil {
+		return "", fmt.Errorf("error resolving workspace roots: %w", err)
 	}
-	// If no resolution is found, return the cells path because it doesn't use a template path
-	// We must fall back to the datasource path.
-	if resolution == "" {
-		if datasource == "" {
-			return "", fmt.Errorf("no resolution or datasource found for workspace: %s", workspaceRoot)
-		}
-		logger.Debug("No resolution found for cells path: %s. Falling back to datasource: %s", cellsPath, datasource)
-		resolvedPath := strings.Replace(cellsPath, workspaceRoot, datasource, 1)
 
-		// Verify the resolved path exists
-		_, err := c.GetNodeStats(context.Background(), resolvedPath)
-		if err != nil {
-			return "", fmt.Errorf("resolved path does not exist: %s (%w)", resolvedPath, err)
+	// Select the root and the remainder of the path below it
+	root := roots[0]
+	rest := pathParts[1:]
+	if len(roots) > 1 {
+		if len(rest) == 0 {
+			return "", fmt.Errorf("cells path %s must select one of the %d roots of workspace %s", cellsPath, len(roots), workspaceRoot)
 		}
-
-		return resolvedPath, nil
+		found := false
+		for _, r := range roots {
+			if path.Base(r.path) == rest[0] {
+				root, found = r, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("no root named %s in workspace %s", rest[0], workspaceRoot)
+		}
+		rest = rest[1:]
 	}
+	resolvedPath := path.Join(append([]string{root.path}, rest...)...)
 
-	// Parse resolution
-	resolutionPath, err := parseWorkspaceResolution(resolution)
-	if err != nil {
-		return "", fmt.Errorf("error parsing resolution: %w", err)
-	}
-	resolvedWorkspaceRoot, err := resolveResolution(resolutionPath, userClient.UserData.Login, userClient.UserData.GroupPath)
-	if err != nil {
-		return "", fmt.Errorf("error parsing workspace resolution: %w", err)
+	// Verify the resolved path exists if the workspace isn't templated, as the datasource root may have moved
+	if !root.templated {
+		logger.Debug("No resolution found for cells path: %s. Falling back to datasource: %s", cellsPath, root.path)
+		if _, err := c.GetNodeStats(context.Background(), resolvedPath); err != nil {
+			return "", fmt.Errorf("resolved path does not exist: %s (%w)", resolvedPath, err)
+		}
 	}
 
-	// Construct the resolved path
-	resolvedPath := strings.Replace(cellsPath, workspaceRoot, resolvedWorkspaceRoot, 1)
-
 	return resolvedPath, nil
 }
 
 // UnresolveCellsPath unresolves a cells path to a workspace path.
 // The path is unresovled by replacing the resolved workspace root with the workspace root.
+// The workspace collection is refreshed if no resolution matches, in case a template path changed since the last refresh.
 func (c *Client) UnresolveCellsPath(userClient UserClient, cellsPath string) (string, error) {
-	// Get the workspace from the cells path
-	pathParts := strings.Split(cellsPath, "/")
-	if len(pathParts) == 0 {
-		return "", fmt.Errorf("invalid cells path: %s", cellsPath)
+	ctx := context.Background()
+	unresolvedPath, resolutions, err := c.unresolveCellsPath(c.workspaces.get(ctx), userClient, cellsPath)
+	if err == nil && unresolvedPath == "" && c.workspaces.refreshOnMiss(ctx) {
+		unresolvedPath, resolutions, err = c.unresolveCellsPath(c.workspaces.get(ctx), userClient, cellsPath)
 	}
-	datasource := pathParts[0]
-
-	// Find the workspace in the workspace collection that uses the datasource
-	var resolutions []string
-	for _, w := range c.workspaceCollection.Workspaces {
-		for root, rootNode := range w.RootNodes {
-			if !strings.HasPrefix(root, "DATASOURCE") {
-				resolutionPath, err := parseWorkspaceResolution(rootNode.MetaStore["resolution"])
-				if err != nil {
-					return "", fmt.Errorf("error parsing resolution: %w", err)
-				}
-				// Check if the resolution path references the datasource
-				if strings.Contains(resolutionPath, "DataSources."+datasource) {
-					resolutions = append(resolutions, resolutionPath)
-					// Parse resolution
-					resolvedWorkspaceRoot, err := resolveResolution(resolutionPath, userClient.UserData.Login, userClient.UserData.GroupPath)
-					if err != nil {
-						return "", fmt.Errorf("error parsing workspace resolution: %w", err)
-					}
-					if strings.HasPrefix(cellsPath, resolvedWorkspaceRoot) {
-						unresolvedPath := strings.Replace(cellsPath, resolvedWorkspaceRoot, w.Slug, 1)
-						return unresolvedPath, nil
-					}
-					logger.Debug("Resolved path does not match cells path: %s != %s", resolvedWorkspaceRoot, cellsPath)
-				}
-			}
-		}
+	if err != nil {
+		return "", err
 	}
-	logger.Error("No resolution found for cells path: %s {UserLogin: %s, UserGroup: %s}", cellsPath, userClient.UserData.Login, userClient.UserData.GroupPath)
-	logger.Debug("Possible Resolutions: %v", resolutions)
-	return cellsPath, nil
+	if unresolvedPath == "" {
+		logger.Error("No resolution found for cells path: %s {UserLogin: %s, UserGroup: %s}", cellsPath, userClient.UserData.Login, userClient.UserData.GroupPath)
+		logger.Debug("Possible Resolutions: %v", resolutions)
+		return cellsPath, nil
+	}
+	return unresolvedPath, nil
 }
 
-// parseWorkspaceResolution parses the resolution of a workspace to get the full path.
-// The resolutions is a string of the template path assigned to a workspace to.
-// TODO: Add more details about the resolution format
-// https://pydio.com/en/docs/cells/v4/ent-shard-template-path
-func parseWorkspaceResolution(resolution string) (string, error) {
-	re := regexp.MustCompile(`Path\s*=\s*(.*?)\s*;`)
-	matches := re.FindStringSubmatch(resolution)
-
-	if len(matches) < 2 {
-		return "", fmt.Errorf("failed to parse resolution")
+// unresolveCellsPath unresolves a cells path using the workspace collection.
+// The root with the longest match wins. Between roots of the same length, a templated root wins, so a user's
+// personal workspace is preferred over an admin workspace exposing the same folder.
+// Returns an empty path, with the roots that were considered, if no root matches.
+func (c *Client) unresolveCellsPath(workspaceCollection *models.RestWorkspaceCollection, userClient UserClient, cellsPath string) (string, []string, error) {
+	cellsPath = strings.Trim(cellsPath, "/")
+	if cellsPath == "" {
+		return "", nil, fmt.Errorf("invalid cells path: %s", cellsPath)
 	}
 
-	return matches[1], nil
-}
+	user := newTemplateUser(userClient.UserData)
+	var considered []string
+	var best *workspaceRoot
+	var bestWorkspace *models.IdmWorkspace
+	var bestRoots int
+	for _, w := range workspaceCollection.Workspaces {
+		if len(w.RootNodes) == 0 {
+			continue
+		}
+		roots, err := workspaceRoots(w, user)
+		if err != nil {
+			// A broken template path must not prevent unresolving paths in other workspaces
+			logger.Warn("Skipping workspace %s: %v", w.Slug, err)
+			continue
+		}
+		for i, root := range roots {
+			considered = append(considered, root.path)
+			if cellsPath != root.path && !strings.HasPrefix(cellsPath, root.path+"/") {
+				continue
+			}
+			if best == nil || len(root.path) > len(best.path) || (len(root.path) == len(best.path) && root.templated && !best.templated) {
+				best, bestWorkspace, bestRoots = &roots[i], w, len(roots)
+			}
+		}
+	}
+	if best == nil {
+		return "", considered, nil
+	}
 
-func resolveResolution(resolutionPath, username, groupname string) (string, error) {
-	// Construct the admin path
-	// DataSources.personal + \"/\" + User.Name
-	// Becomes: personal/username
-	adminPath := strings.ReplaceAll(resolutionPath, " ", "")
-	replacer := strings.NewReplacer(
-		"DataSources.", "",
-		"+\"/\"+", "/",
-		"User.Name", username,
-		"User.Group", groupname,
-	)
-	adminPath = replacer.Replace(adminPath)
-	return adminPath, nil
+	unresolvedRoot := bestWorkspace.Slug
+	if bestRoots > 1 {
+		unresolvedRoot = path.Join(unresolvedRoot, path.Base(best.path))
+	}
+	return unresolvedRoot + strings.TrimPrefix(cellsPath, best.path), considered, nil
 }
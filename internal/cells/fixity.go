@@ -0,0 +1,103 @@
+package cells
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// Fixity methods of VerifyNodeContent.
+const (
+	// FixityMD5 verifies the MD5 of the local copy against the node ETag.
+	FixityMD5 = "md5"
+	// FixitySizeMTime verifies the size of the local copy, and that the node wasn't modified after it was written.
+	FixitySizeMTime = "size+mtime"
+)
+
+// FixityCheck is the result of verifying a local copy of a Cells file.
+type FixityCheck struct {
+	Method string // FixityMD5 or FixitySizeMTime
+	Value  string // Verified checksum, or size and modification time
+}
+
+// IsContentNode reports whether a node holds file content, as opposed to folders and Cells folder markers.
+func IsContentNode(node *models.TreeNode) bool {
+	if node.Type != nil && *node.Type == models.TreeNodeTypeCOLLECTION {
+		return false
+	}
+	return path.Base(node.Path) != ".pydio"
+}
+
+// VerifyNodeContent verifies a local copy of a Cells file against the size and ETag of the node.
+// ETags that aren't the MD5 of the content, e.g. of multipart uploads, can't be compared locally.
+// The size is verified instead, and the node must not have been modified after the local copy was written.
+func VerifyNodeContent(node *models.TreeNode, localPath string) (FixityCheck, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return FixityCheck{}, fmt.Errorf("local copy missing: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return FixityCheck{}, fmt.Errorf("local copy is not a file")
+	}
+
+	size, err := strconv.ParseInt(node.Size, 10, 64)
+	if err != nil {
+		return FixityCheck{}, fmt.Errorf("invalid node size %q: %w", node.Size, err)
+	}
+	if info.Size() != size {
+		return FixityCheck{}, fmt.Errorf("size mismatch: expected %d bytes, got %d", size, info.Size())
+	}
+
+	check, err := NodeFixity(node)
+	if err != nil {
+		return FixityCheck{}, err
+	}
+	if check.Method == FixityMD5 {
+		sum, err := fileChecksum(localPath, 0)
+		if err != nil {
+			return FixityCheck{}, err
+		}
+		if sum.md5 != check.Value {
+			return FixityCheck{}, fmt.Errorf("checksum mismatch: expected md5 %s, got %s", check.Value, sum.md5)
+		}
+		return check, nil
+	}
+
+	modified, _ := nodeModified(node)
+	if modified.After(info.ModTime()) {
+		return FixityCheck{}, fmt.Errorf("modified in Cells at %s, after the local copy was written at %s", modified.Format(time.RFC3339), info.ModTime().UTC().Format(time.RFC3339))
+	}
+	return check, nil
+}
+
+// NodeFixity returns the fixity check a verified local copy of a node passes, from the node metadata alone.
+// It lets the checks of a package be recorded node by node, without keeping them.
+func NodeFixity(node *models.TreeNode) (FixityCheck, error) {
+	etag := strings.ToLower(strings.Trim(node.Etag, `"`))
+	if matches := md5ETag.FindStringSubmatch(etag); matches != nil && matches[2] == "" {
+		return FixityCheck{Method: FixityMD5, Value: etag}, nil
+	}
+	size, err := strconv.ParseInt(node.Size, 10, 64)
+	if err != nil {
+		return FixityCheck{}, fmt.Errorf("invalid node size %q: %w", node.Size, err)
+	}
+	modified, err := nodeModified(node)
+	if err != nil {
+		return FixityCheck{}, fmt.Errorf("no md5 etag and %w", err)
+	}
+	return FixityCheck{Method: FixitySizeMTime, Value: fmt.Sprintf("%d bytes, modified %s", size, modified.Format(time.RFC3339))}, nil
+}
+
+// nodeModified returns the modification time of a node.
+func nodeModified(node *models.TreeNode) (time.Time, error) {
+	mtime, err := strconv.ParseInt(node.MTime, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid node modification time %q: %w", node.MTime, err)
+	}
+	return time.Unix(mtime, 0).UTC(), nil
+}
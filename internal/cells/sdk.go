@@ -3,19 +3,28 @@ package cells
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/go-openapi/runtime"
 	httptransport "github.com/go-openapi/runtime/client"
 	"github.com/pydio/cells-sdk-go/v4/client"
+	"github.com/pydio/cells-sdk-go/v4/client/acl_service"
 	"github.com/pydio/cells-sdk-go/v4/client/admin_tree_service"
+	"github.com/pydio/cells-sdk-go/v4/client/jobs_service"
+	"github.com/pydio/cells-sdk-go/v4/client/role_service"
+	"github.com/pydio/cells-sdk-go/v4/client/share_service"
+	"github.com/pydio/cells-sdk-go/v4/client/tree_service"
 	"github.com/pydio/cells-sdk-go/v4/client/user_meta_service"
 	"github.com/pydio/cells-sdk-go/v4/client/user_service"
 	"github.com/pydio/cells-sdk-go/v4/client/workspace_service"
 	"github.com/pydio/cells-sdk-go/v4/models"
 )
 
-func newSDKClient(scheme, host, basePath string, insecure bool, pat string) (*client.PydioCellsRestAPI, error) {
+func newSDKClient(scheme, host, basePath string, insecure bool, auth runtime.ClientAuthInfoWriter) (*client.PydioCellsRestAPI, error) {
 	cfg := client.DefaultTransportConfig().
 		WithHost(host).
 		WithBasePath(basePath).
@@ -31,7 +40,6 @@ func newSDKClient(scheme, host, basePath string, insecure bool, pat string) (*cl
 		// #nosec G402 -- InsecureSkipVerify is configurable via AllowInsecureTLS for development/testing environments
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
 	}
-	auth := httptransport.BearerToken(pat)
 	transport.DefaultAuthentication = auth
 	return client.New(transport, nil), nil
 }
@@ -59,13 +67,58 @@ func sdkUpdateUserMeta(ctx context.Context, client client.PydioCellsRestAPI, nod
 	return nil
 }
 
-func sdkGetNodeCollection(ctx context.Context, client client.PydioCellsRestAPI, nodePath string) (*models.RestNodesCollection, error) {
+// sdkUpdateUserMetas sets several metadata namespaces of a node in a single request. Values are raw JSON.
+func sdkUpdateUserMetas(ctx context.Context, client client.PydioCellsRestAPI, nodeUUID string, metadata map[string]json.RawMessage) error {
+	updateParams := user_meta_service.NewUpdateUserMetaParamsWithContext(ctx)
+	updateParams.Body = &models.IdmUpdateUserMetaRequest{
+		Operation: models.UpdateUserMetaRequestUserMetaOpPUT.Pointer(),
+	}
+	for namespace, jsonValue := range metadata {
+		updateParams.Body.MetaDatas = append(updateParams.Body.MetaDatas, &models.IdmUserMeta{
+			NodeUUID:  nodeUUID,
+			Namespace: namespace,
+			JSONValue: string(jsonValue),
+		})
+	}
+
+	updateUserMetaOK, err := client.UserMetaService.UpdateUserMeta(updateParams)
+	if err != nil {
+		return fmt.Errorf("error updating metadata {nodeUuid: %s}: %v", nodeUUID, err)
+	}
+	if updateUserMetaOK == nil || updateUserMetaOK.GetPayload() == nil {
+		return fmt.Errorf("no payload returned when updating metadata {nodeUuid: %s}", nodeUUID)
+	}
+	return nil
+}
+
+// sdkDeleteUserMetas deletes metadata namespaces of a node in a single request.
+func sdkDeleteUserMetas(ctx context.Context, client client.PydioCellsRestAPI, nodeUUID string, namespaces []string) error {
+	updateParams := user_meta_service.NewUpdateUserMetaParamsWithContext(ctx)
+	updateParams.Body = &models.IdmUpdateUserMetaRequest{
+		Operation: models.UpdateUserMetaRequestUserMetaOpDELETE.Pointer(),
+	}
+	for _, namespace := range namespaces {
+		updateParams.Body.MetaDatas = append(updateParams.Body.MetaDatas, &models.IdmUserMeta{
+			NodeUUID:  nodeUUID,
+			Namespace: namespace,
+		})
+	}
+	if _, err := client.UserMetaService.UpdateUserMeta(updateParams); err != nil {
+		return fmt.Errorf("error deleting metadata {namespaces: %v, nodeUuid: %s}: %v", namespaces, nodeUUID, err)
+	}
+	return nil
+}
+
+// sdkGetNodeCollection lists a page of the nodes below a path, recursively or only its children.
+func sdkGetNodeCollection(ctx context.Context, client client.PydioCellsRestAPI, nodePath string, recursive bool, offset, limit int) (*models.RestNodesCollection, error) {
 	nodeParams := admin_tree_service.NewListAdminTreeParamsWithContext(ctx)
 	nodeParams.Body = &models.TreeListNodesRequest{
 		Node: &models.TreeNode{
 			Path: nodePath,
 		},
-		Recursive: true,
+		Recursive: recursive,
+		Offset:    strconv.Itoa(offset),
+		Limit:     strconv.Itoa(limit),
 	}
 	nodeCollectionOk, err := client.AdminTreeService.ListAdminTree(nodeParams)
 	if err != nil {
@@ -88,6 +141,21 @@ func sdkGetNodeStats(ctx context.Context, client client.PydioCellsRestAPI, nodeP
 	return nodeStatsOk.GetPayload(), nil
 }
 
+// sdkGetNodeByUUID stats a node by UUID. The returned node holds its current path, wherever it has been moved.
+func sdkGetNodeByUUID(ctx context.Context, client client.PydioCellsRestAPI, nodeUUID string) (*models.TreeReadNodeResponse, error) {
+	nodeParams := admin_tree_service.NewStatAdminTreeParamsWithContext(ctx)
+	nodeParams.Body = &models.TreeReadNodeRequest{
+		Node: &models.TreeNode{
+			UUID: nodeUUID,
+		},
+	}
+	nodeStatsOk, err := client.AdminTreeService.StatAdminTree(nodeParams)
+	if err != nil {
+		return nil, fmt.Errorf("error getting node by uuid: %v", err)
+	}
+	return nodeStatsOk.GetPayload(), nil
+}
+
 func sdkGetWorkspaceCollection(ctx context.Context, client client.PydioCellsRestAPI) (*models.RestWorkspaceCollection, error) {
 	workspaceParams := workspace_service.NewSearchWorkspacesParamsWithContext(ctx)
 	workspaceParams.Body = &models.RestSearchWorkspaceRequest{
@@ -122,8 +190,135 @@ func sdkGetUserData(ctx context.Context, client client.PydioCellsRestAPI, user s
 	return payload, nil
 }
 
-// TODO: Implement sdkUploadNode function
-// func sdkUploadNode() {}
+// sdkHeadNode checks a node exists, from a workspace path. Returns the node, or nil if not found.
+func sdkHeadNode(ctx context.Context, client client.PydioCellsRestAPI, nodePath string) (*models.TreeNode, error) {
+	headParams := tree_service.NewHeadNodeParamsWithContext(ctx)
+	headParams.Node = nodePath
+	headNodeOK, err := client.TreeService.HeadNode(headParams)
+	if err != nil {
+		var notFound *tree_service.HeadNodeNotFound
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting node {path: %s}: %v", nodePath, err)
+	}
+	if headNodeOK == nil || headNodeOK.GetPayload() == nil {
+		return nil, fmt.Errorf("no payload returned when getting node {path: %s}", nodePath)
+	}
+	return headNodeOK.GetPayload().Node, nil
+}
 
-// TODO: Implement sdkDownloadNode function
-// func sdkDownloadNode() {}
+// sdkCreateFolder creates a folder and its missing parents, from a workspace path.
+func sdkCreateFolder(ctx context.Context, client client.PydioCellsRestAPI, folderPath string) error {
+	createParams := tree_service.NewCreateNodesParamsWithContext(ctx)
+	createParams.Body = &models.RestCreateNodesRequest{
+		Nodes: []*models.TreeNode{{
+			Path: folderPath,
+			Type: models.NewTreeNodeType(models.TreeNodeTypeCOLLECTION),
+		}},
+		Recursive: true,
+	}
+	if _, err := client.TreeService.CreateNodes(createParams); err != nil {
+		return fmt.Errorf("error creating folder {path: %s}: %v", folderPath, err)
+	}
+	return nil
+}
+
+// sdkDeleteNode deletes a node and its children, from a workspace path. Deleted nodes go to the recycle bin if the workspace has one.
+func sdkDeleteNode(ctx context.Context, client client.PydioCellsRestAPI, nodePath string) error {
+	deleteParams := tree_service.NewDeleteNodesParamsWithContext(ctx)
+	deleteParams.Body = &models.RestDeleteNodesRequest{
+		Nodes:     []*models.TreeNode{{Path: nodePath}},
+		Recursive: true,
+	}
+	if _, err := client.TreeService.DeleteNodes(deleteParams); err != nil {
+		return fmt.Errorf("error deleting node {path: %s}: %v", nodePath, err)
+	}
+	return nil
+}
+
+// sdkSearchACLs lists a page of the ACLs matching a query.
+func sdkSearchACLs(ctx context.Context, client client.PydioCellsRestAPI, query *models.IdmACLSingleQuery, offset, limit int) ([]*models.IdmACL, error) {
+	aclParams := acl_service.NewSearchAclsParamsWithContext(ctx)
+	aclParams.Body = &models.RestSearchACLRequest{
+		Queries: []*models.IdmACLSingleQuery{query},
+		Offset:  strconv.Itoa(offset),
+		Limit:   strconv.Itoa(limit),
+	}
+	aclsOK, err := client.ACLService.SearchAcls(aclParams)
+	if err != nil {
+		return nil, fmt.Errorf("error searching acls: %v", err)
+	}
+	return aclsOK.GetPayload().ACLs, nil
+}
+
+// sdkGetWorkspaceByUUID gets a workspace of any scope, including cells and share links. Returns nil if not found.
+func sdkGetWorkspaceByUUID(ctx context.Context, client client.PydioCellsRestAPI, workspaceUUID string) (*models.IdmWorkspace, error) {
+	workspaceParams := workspace_service.NewSearchWorkspacesParamsWithContext(ctx)
+	workspaceParams.Body = &models.RestSearchWorkspaceRequest{
+		Queries: []*models.IdmWorkspaceSingleQuery{
+			{
+				UUID:  workspaceUUID,
+				Scope: models.IdmWorkspaceScopeANY.Pointer(),
+			},
+		},
+	}
+	workspacesOk, err := client.WorkspaceService.SearchWorkspaces(workspaceParams)
+	if err != nil {
+		return nil, fmt.Errorf("error getting workspace {uuid: %s}: %v", workspaceUUID, err)
+	}
+	if workspaces := workspacesOk.GetPayload().Workspaces; len(workspaces) > 0 {
+		return workspaces[0], nil
+	}
+	return nil, nil
+}
+
+func sdkGetCell(ctx context.Context, client client.PydioCellsRestAPI, cellUUID string) (*models.RestCell, error) {
+	cellParams := share_service.NewGetCellParamsWithContext(ctx)
+	cellParams.UUID = cellUUID
+	cellOK, err := client.ShareService.GetCell(cellParams)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cell {uuid: %s}: %v", cellUUID, err)
+	}
+	return cellOK.GetPayload(), nil
+}
+
+func sdkGetShareLink(ctx context.Context, client client.PydioCellsRestAPI, linkUUID string) (*models.RestShareLink, error) {
+	linkParams := share_service.NewGetShareLinkParamsWithContext(ctx)
+	linkParams.UUID = linkUUID
+	linkOK, err := client.ShareService.GetShareLink(linkParams)
+	if err != nil {
+		return nil, fmt.Errorf("error getting share link {uuid: %s}: %v", linkUUID, err)
+	}
+	return linkOK.GetPayload(), nil
+}
+
+func sdkGetRole(ctx context.Context, client client.PydioCellsRestAPI, roleUUID string) (*models.IdmRole, error) {
+	roleParams := role_service.NewGetRoleParamsWithContext(ctx)
+	roleParams.UUID = roleUUID
+	roleOK, err := client.RoleService.GetRole(roleParams)
+	if err != nil {
+		return nil, fmt.Errorf("error getting role {uuid: %s}: %v", roleUUID, err)
+	}
+	return roleOK.GetPayload(), nil
+}
+
+// sdkMoveNode starts a Cells move job, moving a node into a target folder, from workspace paths. Returns the job UUID.
+func sdkMoveNode(ctx context.Context, client client.PydioCellsRestAPI, nodePath, targetPath string) (string, error) {
+	jobParameters, err := json.Marshal(map[string]any{
+		"nodes":        []string{nodePath},
+		"target":       targetPath,
+		"targetParent": true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding move job parameters: %v", err)
+	}
+	jobParams := jobs_service.NewUserCreateJobParamsWithContext(ctx)
+	jobParams.JobName = "move"
+	jobParams.Body = jobs_service.UserCreateJobBody{JSONParameters: string(jobParameters)}
+	jobOK, err := client.JobsService.UserCreateJob(jobParams)
+	if err != nil {
+		return "", fmt.Errorf("error moving node {path: %s, target: %s}: %v", nodePath, targetPath, err)
+	}
+	return jobOK.GetPayload().JobUUID, nil
+}
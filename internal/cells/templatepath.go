@@ -0,0 +1,792 @@
+package cells
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// Cells template paths are small scripts, attached to a workspace root, that compute the root for the current user.
+// https://pydio.com/en/docs/cells/v4/ent-shard-template-path
+//
+// The supported subset is:
+//
+//	Statements   Path = <expr>;   var name = <expr>;   name = <expr>;
+//	             if (<expr>) { ... } else if (<expr>) { ... } else { ... }
+//	Expressions  "string" 'string' true false (<expr>)
+//	             <expr> + <expr>          string concatenation
+//	             == != === !==            comparison
+//	             && || !                  logic, with JavaScript truthiness
+//	Variables    DataSources.<name>       DataSources["<name>"]   the datasource root
+//	             User.Name User.Login     the user login
+//	             User.Uuid                the user UUID
+//	             User.GroupPath           the user group path (User.Group is accepted as an alias)
+//	             User.Profile User.DisplayName
+//	             User.Attributes.<name>   User.Attributes["<name>"]   a custom user attribute
+//
+// Anything else, such as function calls, numbers or loops, is rejected with an error giving its position.
+// The script must assign a string to Path. Unset user attributes are undefined, so they can be tested in
+// conditions, but concatenating them is an error.
+
+// TemplateUser holds the user variables available to template paths.
+type TemplateUser struct {
+	Login      string
+	UUID       string
+	GroupPath  string
+	Attributes map[string]string
+}
+
+// newTemplateUser creates the template path user from Cells user data.
+func newTemplateUser(user *models.IdmUser) TemplateUser {
+	if user == nil {
+		return TemplateUser{}
+	}
+	return TemplateUser{
+		Login:      user.Login,
+		UUID:       user.UUID,
+		GroupPath:  user.GroupPath,
+		Attributes: user.Attributes,
+	}
+}
+
+// TemplatePathError reports an invalid or unsupported template path, with the position of the problem.
+type TemplatePathError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *TemplatePathError) Error() string {
+	return fmt.Sprintf("template path %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// TemplatePath is a parsed Cells template path.
+type TemplatePath struct {
+	source string
+	stmts  []tplStmt
+}
+
+// ParseTemplatePath parses a Cells template path script.
+func ParseTemplatePath(source string) (*TemplatePath, error) {
+	tokens, err := lexTemplatePath(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &tplParser{tokens: tokens}
+	var stmts []tplStmt
+	for !p.at(tokEOF, "") {
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return &TemplatePath{source: source, stmts: stmts}, nil
+}
+
+// Evaluate runs the template path for a user and returns the resolved root, e.g. personal/username.
+func (t *TemplatePath) Evaluate(user TemplateUser) (string, error) {
+	env := &tplEnv{user: user, vars: map[string]tplValue{}}
+	if err := env.exec(t.stmts); err != nil {
+		return "", err
+	}
+	result, ok := env.vars["Path"]
+	if !ok {
+		return "", &TemplatePathError{Line: 1, Col: 1, Msg: "Path is never assigned"}
+	}
+	if result.kind != valString {
+		return "", &TemplatePathError{Line: 1, Col: 1, Msg: fmt.Sprintf("Path must be a string, got %s", result.kind)}
+	}
+	cleaned := strings.Trim(path.Clean("/"+result.str), "/")
+	if cleaned == "" {
+		return "", &TemplatePathError{Line: 1, Col: 1, Msg: "Path is empty"}
+	}
+	return cleaned, nil
+}
+
+// evaluateTemplatePath parses and evaluates a template path for a user.
+// The source may be the JSON encoded meta value of a root node resolution.
+func evaluateTemplatePath(source string, user TemplateUser) (string, error) {
+	tpl, err := ParseTemplatePath(templateResolution(source))
+	if err != nil {
+		return "", err
+	}
+	return tpl.Evaluate(user)
+}
+
+///////////////////////////////////////////////////////////////////
+//						  	Lexer								 //
+///////////////////////////////////////////////////////////////////
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind      tokKind
+	text      string
+	line, col int
+}
+
+// punctuators are matched longest first.
+var punctuators = []string{"===", "!==", "==", "!=", "&&", "||", "=", "+", "!", "(", ")", "{", "}", "[", "]", ".", ";", ","}
+
+func lexTemplatePath(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	line, col := 1, 1
+	advance := func(n int) {
+		for i := 0; i < n; i++ {
+			if runes[0] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+			runes = runes[1:]
+		}
+	}
+	for len(runes) > 0 {
+		r := runes[0]
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+		case r == '/' && len(runes) > 1 && runes[1] == '/':
+			for len(runes) > 0 && runes[0] != '\n' {
+				advance(1)
+			}
+		case r == '/' && len(runes) > 1 && runes[1] == '*':
+			startLine, startCol := line, col
+			advance(2)
+			for len(runes) > 1 && (runes[0] != '*' || runes[1] != '/') {
+				advance(1)
+			}
+			if len(runes) < 2 {
+				return nil, &TemplatePathError{Line: startLine, Col: startCol, Msg: "unterminated comment"}
+			}
+			advance(2)
+		case r == '"' || r == '\'':
+			startLine, startCol := line, col
+			advance(1)
+			var sb strings.Builder
+			for {
+				if len(runes) == 0 || runes[0] == '\n' {
+					return nil, &TemplatePathError{Line: startLine, Col: startCol, Msg: "unterminated string"}
+				}
+				c := runes[0]
+				if c == r {
+					advance(1)
+					break
+				}
+				if c == '\\' && len(runes) > 1 {
+					escaped := runes[1]
+					switch escaped {
+					case 'n':
+						escaped = '\n'
+					case 't':
+						escaped = '\t'
+					}
+					sb.WriteRune(escaped)
+					advance(2)
+					continue
+				}
+				sb.WriteRune(c)
+				advance(1)
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), line: startLine, col: startCol})
+		case r == '_' || r == '$' || unicode.IsLetter(r):
+			n := 0
+			for n < len(runes) && (runes[n] == '_' || runes[n] == '$' || unicode.IsLetter(runes[n]) || unicode.IsDigit(runes[n])) {
+				n++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[:n]), line: line, col: col})
+			advance(n)
+		default:
+			matched := ""
+			for _, p := range punctuators {
+				if strings.HasPrefix(string(runes[:min(len(runes), len(p))]), p) {
+					matched = p
+					break
+				}
+			}
+			if matched == "" {
+				msg := fmt.Sprintf("unsupported character %q", r)
+				if unicode.IsDigit(r) {
+					msg = "numbers are not supported"
+				}
+				return nil, &TemplatePathError{Line: line, Col: col, Msg: msg}
+			}
+			tokens = append(tokens, token{kind: tokPunct, text: matched, line: line, col: col})
+			advance(len(matched))
+		}
+	}
+	return append(tokens, token{kind: tokEOF, line: line, col: col}), nil
+}
+
+///////////////////////////////////////////////////////////////////
+//						  	Parser								 //
+///////////////////////////////////////////////////////////////////
+
+type tplStmt interface{}
+
+type tplAssign struct {
+	name  token
+	value tplExpr
+}
+
+type tplIf struct {
+	cond tplExpr
+	then []tplStmt
+	els  []tplStmt
+}
+
+type tplExpr interface{}
+
+type tplLiteral struct{ value tplValue }
+
+// tplVar is a variable reference with an optional chain of members, e.g. User.Attributes.name.
+type tplVar struct {
+	name    token
+	members []string
+}
+
+type tplUnary struct {
+	op      token
+	operand tplExpr
+}
+
+type tplBinary struct {
+	op          token
+	left, right tplExpr
+}
+
+// reserved words that are valid JavaScript but outside the supported subset.
+var unsupportedKeywords = map[string]bool{
+	"for": true, "while": true, "do": true, "function": true, "return": true, "switch": true,
+	"let": true, "const": true, "new": true, "null": true, "undefined": true, "typeof": true,
+}
+
+type tplParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *tplParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *tplParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *tplParser) at(kind tokKind, text string) bool {
+	t := p.peek()
+	return t.kind == kind && (text == "" || t.text == text)
+}
+
+func (p *tplParser) accept(text string) bool {
+	if p.at(tokPunct, text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *tplParser) expect(text string) (token, error) {
+	t := p.peek()
+	if t.kind != tokPunct || t.text != text {
+		return t, p.errorf(t, "expected %q, found %s", text, describe(t))
+	}
+	return p.next(), nil
+}
+
+func (p *tplParser) errorf(t token, format string, args ...any) error {
+	return &TemplatePathError{Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of script"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func (p *tplParser) statement() (tplStmt, error) {
+	t := p.peek()
+	switch {
+	case p.accept(";"):
+		return nil, nil
+	case t.kind == tokIdent && t.text == "if":
+		return p.ifStatement()
+	case t.kind == tokIdent && t.text == "var":
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, p.errorf(name, "expected variable name, found %s", describe(name))
+		}
+		return p.assignment(name)
+	case t.kind == tokIdent && unsupportedKeywords[t.text]:
+		return nil, p.errorf(t, "%q is not supported", t.text)
+	case t.kind == tokIdent:
+		return p.assignment(p.next())
+	default:
+		return nil, p.errorf(t, "expected statement, found %s", describe(t))
+	}
+}
+
+func (p *tplParser) assignment(name token) (tplStmt, error) {
+	if name.text == "DataSources" || name.text == "User" {
+		return nil, p.errorf(name, "%s is read-only", name.text)
+	}
+	if p.at(tokPunct, ".") || p.at(tokPunct, "[") {
+		return nil, p.errorf(p.peek(), "only variables can be assigned")
+	}
+	if p.at(tokPunct, "(") {
+		return nil, p.errorf(name, "function calls are not supported")
+	}
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(";") && !p.at(tokPunct, "}") && !p.at(tokEOF, "") {
+		// Allow newline separated statements, as JavaScript does
+		if p.peek().line == name.line {
+			return nil, p.errorf(p.peek(), "expected \";\", found %s", describe(p.peek()))
+		}
+	}
+	return &tplAssign{name: name, value: value}, nil
+}
+
+func (p *tplParser) ifStatement() (tplStmt, error) {
+	p.next() // if
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &tplIf{cond: cond, then: then}
+	if p.at(tokIdent, "else") {
+		p.next()
+		if p.at(tokIdent, "if") {
+			elseIf, err := p.ifStatement()
+			if err != nil {
+				return nil, err
+			}
+			stmt.els = []tplStmt{elseIf}
+		} else if stmt.els, err = p.block(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+// block parses a braced block, or a single statement.
+func (p *tplParser) block() ([]tplStmt, error) {
+	if !p.accept("{") {
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt == nil {
+			return nil, nil
+		}
+		return []tplStmt{stmt}, nil
+	}
+	var stmts []tplStmt
+	for !p.accept("}") {
+		if p.at(tokEOF, "") {
+			return nil, p.errorf(p.peek(), "expected \"}\", found end of script")
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts, nil
+}
+
+func (p *tplParser) expression() (tplExpr, error) {
+	return p.binary(0)
+}
+
+// binaryLevels lists the binary operators from the lowest to the highest precedence.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"+"},
+}
+
+func (p *tplParser) binary(level int) (tplExpr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op.kind != tokPunct || !contains(binaryLevels[level], op.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &tplBinary{op: op, left: left, right: right}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *tplParser) unary() (tplExpr, error) {
+	if p.at(tokPunct, "!") {
+		op := p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &tplUnary{op: op, operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *tplParser) primary() (tplExpr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString:
+		return &tplLiteral{value: stringValue(t.text)}, nil
+	case t.kind == tokPunct && t.text == "(":
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		return &tplLiteral{value: boolValue(t.text == "true")}, nil
+	case t.kind == tokIdent && unsupportedKeywords[t.text]:
+		return nil, p.errorf(t, "%q is not supported", t.text)
+	case t.kind == tokIdent:
+		v := &tplVar{name: t}
+		for {
+			switch {
+			case p.accept("."):
+				member := p.next()
+				if member.kind != tokIdent {
+					return nil, p.errorf(member, "expected member name, found %s", describe(member))
+				}
+				v.members = append(v.members, member.text)
+			case p.accept("["):
+				member := p.next()
+				if member.kind != tokString {
+					return nil, p.errorf(member, "only string indexes are supported")
+				}
+				if _, err := p.expect("]"); err != nil {
+					return nil, err
+				}
+				v.members = append(v.members, member.text)
+			case p.at(tokPunct, "("):
+				return nil, p.errorf(p.peek(), "function calls are not supported")
+			default:
+				return v, nil
+			}
+		}
+	default:
+		return nil, p.errorf(t, "expected expression, found %s", describe(t))
+	}
+}
+
+///////////////////////////////////////////////////////////////////
+//						  	Evaluator							 //
+///////////////////////////////////////////////////////////////////
+
+type valueKind int
+
+const (
+	valUndefined valueKind = iota
+	valString
+	valBool
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case valString:
+		return "string"
+	case valBool:
+		return "boolean"
+	default:
+		return "undefined"
+	}
+}
+
+type tplValue struct {
+	kind valueKind
+	str  string
+	b    bool
+}
+
+func stringValue(s string) tplValue { return tplValue{kind: valString, str: s} }
+func boolValue(b bool) tplValue     { return tplValue{kind: valBool, b: b} }
+
+// truthy applies JavaScript truthiness.
+func (v tplValue) truthy() bool {
+	switch v.kind {
+	case valString:
+		return v.str != ""
+	case valBool:
+		return v.b
+	default:
+		return false
+	}
+}
+
+type tplEnv struct {
+	user TemplateUser
+	vars map[string]tplValue
+}
+
+func (e *tplEnv) exec(stmts []tplStmt) error {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *tplAssign:
+			value, err := e.eval(s.value)
+			if err != nil {
+				return err
+			}
+			e.vars[s.name.text] = value
+		case *tplIf:
+			cond, err := e.eval(s.cond)
+			if err != nil {
+				return err
+			}
+			branch := s.els
+			if cond.truthy() {
+				branch = s.then
+			}
+			if err := e.exec(branch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *tplEnv) eval(expr tplExpr) (tplValue, error) {
+	switch x := expr.(type) {
+	case *tplLiteral:
+		return x.value, nil
+	case *tplVar:
+		return e.lookup(x)
+	case *tplUnary:
+		operand, err := e.eval(x.operand)
+		if err != nil {
+			return tplValue{}, err
+		}
+		return boolValue(!operand.truthy()), nil
+	case *tplBinary:
+		return e.evalBinary(x)
+	default:
+		return tplValue{}, fmt.Errorf("unexpected template path expression %T", expr)
+	}
+}
+
+func (e *tplEnv) evalBinary(x *tplBinary) (tplValue, error) {
+	left, err := e.eval(x.left)
+	if err != nil {
+		return tplValue{}, err
+	}
+	// Short-circuit like JavaScript, returning the deciding operand
+	switch x.op.text {
+	case "||":
+		if left.truthy() {
+			return left, nil
+		}
+		return e.eval(x.right)
+	case "&&":
+		if !left.truthy() {
+			return left, nil
+		}
+		return e.eval(x.right)
+	}
+
+	right, err := e.eval(x.right)
+	if err != nil {
+		return tplValue{}, err
+	}
+	switch x.op.text {
+	case "+":
+		if left.kind != valString || right.kind != valString {
+			return tplValue{}, &TemplatePathError{Line: x.op.line, Col: x.op.col,
+				Msg: fmt.Sprintf("cannot concatenate %s and %s", left.kind, right.kind)}
+		}
+		return stringValue(left.str + right.str), nil
+	case "==", "===":
+		return boolValue(left == right), nil
+	case "!=", "!==":
+		return boolValue(left != right), nil
+	default:
+		return tplValue{}, &TemplatePathError{Line: x.op.line, Col: x.op.col, Msg: fmt.Sprintf("unsupported operator %q", x.op.text)}
+	}
+}
+
+func (e *tplEnv) lookup(v *tplVar) (tplValue, error) {
+	fail := func(format string, args ...any) (tplValue, error) {
+		return tplValue{}, &TemplatePathError{Line: v.name.line, Col: v.name.col, Msg: fmt.Sprintf(format, args...)}
+	}
+	switch v.name.text {
+	case "DataSources":
+		if len(v.members) != 1 {
+			return fail("expected DataSources.<name>")
+		}
+		return stringValue(v.members[0]), nil
+	case "User":
+		if len(v.members) == 0 {
+			return fail("expected User.<property>")
+		}
+		member, rest := v.members[0], v.members[1:]
+		if member == "Attributes" {
+			if len(rest) != 1 {
+				return fail("expected User.Attributes.<name>")
+			}
+			value, ok := e.user.Attributes[rest[0]]
+			if !ok {
+				return tplValue{}, nil
+			}
+			return stringValue(value), nil
+		}
+		if len(rest) > 0 {
+			return fail("User.%s has no properties", member)
+		}
+		switch member {
+		case "Name", "Login":
+			return stringValue(e.user.Login), nil
+		case "Uuid":
+			return stringValue(e.user.UUID), nil
+		case "GroupPath", "Group":
+			return stringValue(e.user.GroupPath), nil
+		case "Profile":
+			return e.attribute("profile"), nil
+		case "DisplayName":
+			return e.attribute("displayName"), nil
+		default:
+			return fail("unsupported user property User.%s", member)
+		}
+	default:
+		value, ok := e.vars[v.name.text]
+		if !ok {
+			return fail("undefined variable %s", v.name.text)
+		}
+		if len(v.members) > 0 {
+			return fail("%s has no properties", v.name.text)
+		}
+		return value, nil
+	}
+}
+
+func (e *tplEnv) attribute(name string) tplValue {
+	value, ok := e.user.Attributes[name]
+	if !ok {
+		return tplValue{}
+	}
+	return stringValue(value)
+}
+
+///////////////////////////////////////////////////////////////////
+//						  	Workspace Roots						 //
+///////////////////////////////////////////////////////////////////
+
+// workspaceRoot is a workspace root node resolved for a user.
+type workspaceRoot struct {
+	key       string // Root node key
+	path      string // Resolved datasource path, e.g. personal/username
+	templated bool   // Resolved from a template path rather than a datasource root
+}
+
+// workspaceRoots resolves every root node of a workspace for a user, ordered by key.
+func workspaceRoots(workspace *models.IdmWorkspace, user TemplateUser) ([]workspaceRoot, error) {
+	keys := make([]string, 0, len(workspace.RootNodes))
+	for key := range workspace.RootNodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	roots := make([]workspaceRoot, 0, len(keys))
+	for _, key := range keys {
+		rootNode := workspace.RootNodes[key]
+		// DATASOURCE roots, a.k.a. not templated, point straight at a datasource folder
+		if strings.HasPrefix(key, "DATASOURCE") {
+			rootPath := strings.Trim(rootNode.Path, "/")
+			if rootPath == "" {
+				return nil, fmt.Errorf("workspace %s root %s has no path", workspace.Slug, key)
+			}
+			roots = append(roots, workspaceRoot{key: key, path: rootPath})
+			continue
+		}
+		resolution := rootNode.MetaStore["resolution"]
+		if templateResolution(resolution) == "" {
+			return nil, fmt.Errorf("workspace %s root %s has no template path", workspace.Slug, key)
+		}
+		rootPath, err := evaluateTemplatePath(resolution, user)
+		if err != nil {
+			return nil, fmt.Errorf("workspace %s root %s: %w", workspace.Slug, key, err)
+		}
+		roots = append(roots, workspaceRoot{key: key, path: rootPath, templated: true})
+	}
+	return roots, nil
+}
+
+// templateResolution returns the template path script of a root node resolution.
+// Cells stores meta values JSON encoded, so the script is usually a quoted string.
+func templateResolution(resolution string) string {
+	var decoded string
+	if err := json.Unmarshal([]byte(resolution), &decoded); err == nil {
+		return decoded
+	}
+	return resolution
+}
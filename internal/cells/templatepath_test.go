@@ -0,0 +1,151 @@
+package cells
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// templatePathFixtures are the evaluator and workspace cases of testdata/template_paths.json.
+type templatePathFixtures struct {
+	Users map[string]*models.IdmUser `json:"users"`
+	Cases []struct {
+		Name   string `json:"name"`
+		User   string `json:"user"`
+		Script string `json:"script"`
+		Path   string `json:"path"`
+		Error  string `json:"error"`
+	} `json:"cases"`
+	Workspaces []struct {
+		Name          string               `json:"name"`
+		User          string               `json:"user"`
+		Workspace     *models.IdmWorkspace `json:"workspace"`
+		Resolve       map[string]string    `json:"resolve"`
+		ResolveErrors map[string]string    `json:"resolveErrors"`
+		Unresolve     map[string]string    `json:"unresolve"`
+	} `json:"workspaces"`
+}
+
+func loadTemplatePathFixtures(t *testing.T) templatePathFixtures {
+	t.Helper()
+	data, err := os.ReadFile("testdata/template_paths.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures templatePathFixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+// newStatClient creates a client whose admin node stats always succeed, as datasource roots are checked to exist on resolve.
+func newStatClient(t *testing.T) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var request models.TreeReadNodeRequest
+		_ = json.Unmarshal(body, &request)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(models.TreeReadNodeResponse{Node: request.Node, Success: true})
+	}))
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdk, err := newSDKClient(serverURL.Scheme, serverURL.Host, "/a", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{adminClient: &AdminClient{client: sdk}}
+}
+
+func TestTemplatePathCases(t *testing.T) {
+	fixtures := loadTemplatePathFixtures(t)
+	client := newStatClient(t)
+	for _, tc := range fixtures.Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			user, ok := fixtures.Users[tc.User]
+			if !ok {
+				t.Fatalf("unknown user %q", tc.User)
+			}
+			got, err := evaluateTemplatePath(tc.Script, newTemplateUser(user))
+			if tc.Error != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.Error) {
+					t.Fatalf("evaluate: got (%q, %v), want error containing %q", got, err, tc.Error)
+				}
+			} else if err != nil || got != tc.Path {
+				t.Fatalf("evaluate: got (%q, %v), want %q", got, err, tc.Path)
+			}
+
+			// The script as the template root of a workspace
+			workspaces := &models.RestWorkspaceCollection{Workspaces: []*models.IdmWorkspace{{
+				Slug:      "template",
+				RootNodes: map[string]models.TreeNode{"template-root": {MetaStore: map[string]string{"resolution": tc.Script}}},
+			}}}
+			userClient := UserClient{UserData: user}
+			resolved, err := client.resolveCellsPath(workspaces, userClient, "template/file.txt")
+			if tc.Error != "" {
+				// Roots without a script fail before it is evaluated
+				if err == nil || (tc.Script != "" && !strings.Contains(err.Error(), tc.Error)) {
+					t.Fatalf("resolve: got (%q, %v), want error containing %q", resolved, err, tc.Error)
+				}
+				unresolved, _, err := client.unresolveCellsPath(workspaces, userClient, "personal/alice/file.txt")
+				if err != nil || unresolved != "" {
+					t.Fatalf("unresolve: got (%q, %v), want no match", unresolved, err)
+				}
+				return
+			}
+			if want := path.Join(tc.Path, "file.txt"); err != nil || resolved != want {
+				t.Fatalf("resolve: got (%q, %v), want %q", resolved, err, want)
+			}
+			unresolved, _, err := client.unresolveCellsPath(workspaces, userClient, path.Join(tc.Path, "file.txt"))
+			if err != nil || unresolved != "template/file.txt" {
+				t.Fatalf("unresolve: got (%q, %v), want %q", unresolved, err, "template/file.txt")
+			}
+		})
+	}
+}
+
+func TestTemplatePathWorkspaces(t *testing.T) {
+	fixtures := loadTemplatePathFixtures(t)
+	client := newStatClient(t)
+	for _, tc := range fixtures.Workspaces {
+		t.Run(tc.Name, func(t *testing.T) {
+			user, ok := fixtures.Users[tc.User]
+			if !ok {
+				t.Fatalf("unknown user %q", tc.User)
+			}
+			workspaces := &models.RestWorkspaceCollection{Workspaces: []*models.IdmWorkspace{tc.Workspace}}
+			userClient := UserClient{UserData: user}
+			for cellsPath, want := range tc.Resolve {
+				got, err := client.resolveCellsPath(workspaces, userClient, cellsPath)
+				if err != nil || got != want {
+					t.Errorf("resolve %s: got (%q, %v), want %q", cellsPath, got, err, want)
+				}
+			}
+			for cellsPath, want := range tc.ResolveErrors {
+				got, err := client.resolveCellsPath(workspaces, userClient, cellsPath)
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("resolve %s: got (%q, %v), want error containing %q", cellsPath, got, err, want)
+				}
+			}
+			for cellsPath, want := range tc.Unresolve {
+				got, considered, err := client.unresolveCellsPath(workspaces, userClient, cellsPath)
+				if err != nil || got != want {
+					t.Errorf("unresolve %s: got (%q, %v), want %q. Considered: %s", cellsPath, got, err, want, fmt.Sprint(considered))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package cells
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/utils"
+)
+
+const (
+	// tokenLabelPrefix prefixes the label of every impersonation token. Each label is suffixed with a UUID to find the token UUID.
+	tokenLabelPrefix = "Preservation Token"
+	// defaultTokenTTL is the lifetime of an impersonation token.
+	defaultTokenTTL = 30 * time.Minute
+	// tokenRefreshRetry is the delay before retrying a failed token refresh.
+	tokenRefreshRetry = 30 * time.Second
+	// tokenRevokeTimeout bounds the revocation of the tokens of a session.
+	tokenRevokeTimeout = 10 * time.Second
+)
+
+// userToken represents an impersonation token issued for a user.
+type userToken struct {
+	id        string // Token UUID. Empty if it could not be found, in which case the token is left to expire
+	value     string
+	expiresAt time.Time
+}
+
+// tokenSession holds the impersonation token of a user client.
+// The token is refreshed in the background before it expires, so long jobs keep a valid token,
+// and every token issued for the session is revoked when the session is closed.
+type tokenSession struct {
+	client   *Client
+	username string
+	ttl      time.Duration
+
+	mu      sync.RWMutex
+	current userToken
+	retired []userToken // Replaced tokens. Kept until close, as in-flight transfers may still use them
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// newTokenSession issues a token for the user and starts refreshing it.
+func (c *Client) newTokenSession(ctx context.Context, username string) (*tokenSession, error) {
+	token, err := c.issueUserToken(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	loopCtx, cancel := context.WithCancel(context.Background())
+	session := &tokenSession{
+		client:   c,
+		username: username,
+		ttl:      c.tokenTTL,
+		current:  token,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	c.sessionsMu.Lock()
+	c.sessions[session] = struct{}{}
+	c.sessionsMu.Unlock()
+
+	go session.refreshLoop(loopCtx)
+	return session, nil
+}
+
+// issueUserToken generates an impersonation token for the user and finds its UUID, so it can be revoked.
+func (c *Client) issueUserToken(ctx context.Context, username string) (userToken, error) {
+	label := fmt.Sprintf("%s %s", tokenLabelPrefix, uuid.New().String())
+	expiresAt := time.Now().Add(c.tokenTTL)
+	value, err := c.generateUserToken(ctx, username, label, c.tokenTTL)
+	if err != nil {
+		return userToken{}, fmt.Errorf("error generating user token: %w", err)
+	}
+	if value == "" {
+		return userToken{}, fmt.Errorf("user token is empty")
+	}
+
+	token := userToken{value: value, expiresAt: expiresAt}
+	tokens, err := c.listUserTokens(ctx, username)
+	if err != nil {
+		logger.Warn("Unable to find user token %q, it will expire at %s instead of being revoked: %v", label, expiresAt.Format(time.RFC3339), err)
+		return token, nil
+	}
+	for _, t := range tokens {
+		if t.Label == label {
+			token.id = t.UUID
+			break
+		}
+	}
+	if token.id == "" {
+		logger.Warn("User token %q not found, it will expire at %s instead of being revoked", label, expiresAt.Format(time.RFC3339))
+	}
+	return token, nil
+}
+
+// token returns the current token value.
+func (s *tokenSession) token() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.value
+}
+
+// refreshAt returns the time the current token is refreshed, once two thirds of its lifetime have passed.
+func (s *tokenSession) refreshAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.expiresAt.Add(-s.ttl / 3)
+}
+
+// refreshLoop refreshes the token before it expires until the session is closed.
+func (s *tokenSession) refreshLoop(ctx context.Context) {
+	defer close(s.done)
+	timer := time.NewTimer(time.Until(s.refreshAt()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := s.refresh(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Error("Failed to refresh user token for %s. Retrying in %s: %v", s.username, tokenRefreshRetry, err)
+				timer.Reset(tokenRefreshRetry)
+				continue
+			}
+			timer.Reset(time.Until(s.refreshAt()))
+		}
+	}
+}
+
+// refresh replaces the current token with a new one. The replaced token is revoked when the session closes.
+func (s *tokenSession) refresh(ctx context.Context) error {
+	token, err := s.client.issueUserToken(ctx, s.username)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.retired = append(s.retired, s.current)
+	s.current = token
+	s.mu.Unlock()
+	logger.Debug("Refreshed user token for %s. Expires at %s", s.username, token.expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// close stops refreshing and revokes every token issued for the session. It is safe to call more than once.
+func (s *tokenSession) close(ctx context.Context) error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		<-s.done
+
+		s.client.sessionsMu.Lock()
+		delete(s.client.sessions, s)
+		s.client.sessionsMu.Unlock()
+
+		s.mu.Lock()
+		tokens := append(s.retired, s.current)
+		s.retired = nil
+		s.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(ctx, tokenRevokeTimeout)
+		defer cancel()
+		var failed int
+		for _, token := range tokens {
+			if token.id == "" || time.Now().After(token.expiresAt) {
+				continue
+			}
+			if revokeErr := s.client.revokeUserToken(ctx, token.id); revokeErr != nil {
+				logger.Error("Failed to revoke user token for %s: %v", s.username, revokeErr)
+				failed++
+			}
+		}
+		if failed > 0 {
+			err = fmt.Errorf("failed to revoke %d user token(s) for %s", failed, s.username)
+			return
+		}
+		logger.Debug("Revoked user token(s) for %s", s.username)
+	})
+	return err
+}
+
+// listUserTokens lists the personal access tokens of a user.
+// Admin Task. Cells API.
+func (c *Client) listUserTokens(ctx context.Context, username string) ([]apiToken, error) {
+	var result []apiToken
+	err := utils.WithRetry(func() error {
+		var err error
+		result, err = apiListUserTokens(ctx, c.httpClient, c.address, username, c.adminClient.token)
+		return err
+	})
+	return result, err
+}
+
+// revokeUserToken revokes a user token by UUID.
+// Admin Task. Cells API.
+func (c *Client) revokeUserToken(ctx context.Context, tokenUUID string) error {
+	return utils.WithRetry(func() error {
+		return apiRevokeUserToken(ctx, c.httpClient, c.address, c.adminClient.token, tokenUUID)
+	})
+}
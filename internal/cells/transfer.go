@@ -0,0 +1,689 @@
+package cells
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- MD5 is only used to compare against S3 ETags, not for security
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/utils"
+	cellssdk "github.com/pydio/cells-sdk-go/v4"
+	cellss3 "github.com/pydio/cells-sdk-go/v4/transport/s3"
+)
+
+const (
+	// TransferBackendCEC transfers content by shelling out to the cec binary.
+	TransferBackendCEC = "cec"
+	// TransferBackendSDK transfers content natively through the Cells S3 gateway.
+	TransferBackendSDK = "sdk"
+
+	defaultPartSizeMB  = 50
+	defaultConcurrency = 3
+	maxUploadParts     = 10000 // S3 multipart upload limit
+)
+
+// md5ETag matches an ETag holding the MD5 of the object. Multipart ETags are suffixed with the part count, e.g. "<md5>-3".
+var md5ETag = regexp.MustCompile(`^([0-9a-f]{32})(-\d+)?$`)
+
+// TransferOptions represents the options for content transfers between Cells and the local filesystem.
+type TransferOptions struct {
+	Backend     string       // TransferBackendSDK or TransferBackendCEC. Defaults to TransferBackendSDK
+	PartSizeMB  int64        // Multipart part size in MB. Must be a multiple of 10 (Cells requirement)
+	Concurrency int          // Number of parts transferred in parallel for each file
+	Progress    ProgressFunc // Called as content is transferred. Progress is logged at debug level if nil
+	StateDir    string       // Directory persisting partial transfers, so they resume after errors and restarts. Not persisted if empty
+
+	scope string // User and job of the transfer, see transferScope
+}
+
+// TransferProgress reports the progress of a single file transfer.
+type TransferProgress struct {
+	Path        string // Cells path of the file
+	Transferred int64  // Bytes transferred so far
+	Total       int64  // Size of the file in bytes
+}
+
+// ProgressFunc is called as file content is transferred.
+// It is called as parts complete, at most once per 10% of each file, and may be called concurrently.
+type ProgressFunc func(TransferProgress)
+
+// withDefaults returns the options with the defaults applied.
+func (o TransferOptions) withDefaults() TransferOptions {
+	if o.Backend == "" {
+		o.Backend = TransferBackendSDK
+	}
+	if o.PartSizeMB <= 0 {
+		o.PartSizeMB = defaultPartSizeMB
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.Progress == nil {
+		o.Progress = logProgress
+	}
+	return o
+}
+
+// validate checks the options can be used for transfers.
+func (o TransferOptions) validate() error {
+	switch o.Backend {
+	case TransferBackendCEC, TransferBackendSDK:
+	default:
+		return fmt.Errorf("unknown transfer backend: %q", o.Backend)
+	}
+	if _, err := cellss3.ComputePartSize(0, o.PartSizeMB, maxUploadParts); err != nil {
+		return fmt.Errorf("invalid transfer part size: %w", err)
+	}
+	return nil
+}
+
+func logProgress(p TransferProgress) {
+	logger.Debug("Transferred %s: %d/%d bytes", p.Path, p.Transferred, p.Total)
+}
+
+// newS3Client creates an S3 client for the Cells gateway authenticated with the user token.
+// The token is read again every minute, so refreshed tokens are picked up by long transfers.
+func newS3Client(ctx context.Context, address string, userToken func() string, insecure bool) (*s3.Client, error) {
+	sdkConfig := &cellssdk.SdkConfig{
+		Url:        address,
+		AuthType:   cellssdk.AuthTypePat,
+		IdToken:    userToken(),
+		SkipVerify: insecure,
+	}
+	credentials := cellssdk.CredentialProviderOption(func(aws.CredentialsProvider) aws.CredentialsProvider {
+		return aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     userToken(),
+				SecretAccessKey: cellssdk.DefaultS3ApiSecret,
+				Source:          address,
+				CanExpire:       true,
+				Expires:         time.Now().Add(time.Minute),
+			}, nil
+		})
+	})
+	awsConfig, err := cellss3.LoadConfig(ctx, sdkConfig, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error loading s3 config: %w", err)
+	}
+	// The Cells gateway doesn't support the flexible checksums sent by default
+	awsConfig.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+	awsConfig.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+
+	return s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(address)
+		o.UsePathStyle = true
+	}), nil
+}
+
+// sdkDownloadNode downloads a node, recursively, from the Cells S3 gateway to a local directory.
+// The cells source is the workspace path of the node, e.g. personal-files/folder.
+// Folders are the slash paths of the folders of the node, relative to it, with "." for the node itself, or nil if the node is a file.
+// They come from the Cells node listing, as the S3 listing only holds files, so empty folders are downloaded too.
+// Returns the path of the downloaded node.
+func sdkDownloadNode(ctx context.Context, s3Client *s3.Client, options TransferOptions, cellsSrc, dest string, folders []string) (string, error) {
+	cellsSrc = strings.Trim(path.Clean(cellsSrc), "/")
+	localRoot := filepath.Join(dest, path.Base(cellsSrc))
+
+	for _, folder := range folders {
+		if err := os.MkdirAll(filepath.Join(localRoot, filepath.FromSlash(folder)), 0o750); err != nil {
+			return "", fmt.Errorf("error creating download directory: %w", err)
+		}
+	}
+	objects, err := listObjects(ctx, s3Client, cellsSrc+"/")
+	if err != nil {
+		return "", err
+	}
+	// A file has no children
+	if len(objects) == 0 && folders == nil {
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(cellssdk.DefaultS3Bucket),
+			Key:    aws.String(cellsSrc),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error reading node %s: %w", cellsSrc, err)
+		}
+		objects = []objectInfo{{key: cellsSrc, size: aws.ToInt64(head.ContentLength), etag: aws.ToString(head.ETag)}}
+	}
+
+	for _, object := range objects {
+		localPath := localRoot
+		if object.key != cellsSrc {
+			localPath = filepath.Join(localRoot, filepath.FromSlash(strings.TrimPrefix(object.key, cellsSrc+"/")))
+		}
+		if err := downloadObject(ctx, s3Client, options, object, localPath); err != nil {
+			return "", err
+		}
+	}
+	logger.Debug("Downloaded %d file(s) and %d folder(s) from %s", len(objects), len(folders), cellsSrc)
+	return localRoot, nil
+}
+
+// downloadObject downloads a single object to a local file in ranged parts and verifies its checksum.
+// The content is written to a partial file and only moved to the local path once verified.
+// Completed parts are recorded in the transfer state, so an interrupted download resumes from the last completed part,
+// unless the object changed in the meantime.
+func downloadObject(ctx context.Context, s3Client *s3.Client, options TransferOptions, object objectInfo, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o750); err != nil {
+		return fmt.Errorf("error creating download directory: %w", err)
+	}
+	state, err := loadTransferState(options.StateDir, "download", options.scope, object.key)
+	if err != nil {
+		return err
+	}
+	partSize := options.PartSizeMB * 1024 * 1024
+	if !state.matches(object.size, partSize, object.etag, "") {
+		state.reset(object.size, partSize)
+		state.ETag = object.etag
+	} else {
+		logger.Info("Resuming download of %s from part %d/%d", object.key, len(state.Completed)+1, state.numParts())
+	}
+	partialPath := state.partialPath(localPath)
+
+	file, err := os.OpenFile(filepath.Clean(partialPath), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	// A fresh download starts from an empty file
+	if len(state.Completed) == 0 {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		err = file.Truncate(object.size)
+	}
+	if err == nil {
+		err = state.save()
+	}
+	if err == nil {
+		tracker := newProgressTracker(object.key, object.size, options.Progress)
+		tracker.add(state.completedBytes())
+		err = transferParts(ctx, options.Concurrency, state, tracker, func(ctx context.Context, _ int32, offset, length int64) (string, error) {
+			return "", downloadPart(ctx, s3Client, object.key, file, offset, length)
+		})
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %w", object.key, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("error closing file: %w", closeErr)
+	}
+
+	// Hash-verified completion. A corrupt download can't be resumed, so it is discarded
+	sum, err := fileChecksum(partialPath, 0)
+	if err != nil {
+		return err
+	}
+	if err := sum.verify(object.size, object.etag); err != nil {
+		state.remove()
+		_ = os.Remove(partialPath)
+		return fmt.Errorf("error verifying download of %s: %w", object.key, err)
+	}
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return fmt.Errorf("error moving download to %s: %w", localPath, err)
+	}
+	state.remove()
+	return nil
+}
+
+// downloadPart downloads a byte range of an object into the file at the same offset.
+func downloadPart(ctx context.Context, s3Client *s3.Client, key string, file *os.File, offset, length int64) error {
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cellssdk.DefaultS3Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Body.Close()
+	}()
+	n, err := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(out.Body, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("short read: expected %d bytes, got %d: %w", length, n, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// sdkUploadNode uploads a local file or directory, recursively, to a Cells folder through the S3 gateway.
+// The cells destination is the workspace path of the folder, e.g. personal-files/archive.
+// Returns the path of the uploaded node.
+func sdkUploadNode(ctx context.Context, s3Client *s3.Client, options TransferOptions, src, cellsDest string) (string, error) {
+	cellsDest = strings.Trim(path.Clean(cellsDest), "/")
+	cellsRoot := path.Join(cellsDest, filepath.Base(src))
+
+	var count int
+	err := filepath.Walk(src, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, localPath)
+		if err != nil {
+			return err
+		}
+		key := cellsRoot
+		if rel != "." {
+			key = path.Join(cellsRoot, filepath.ToSlash(rel))
+		}
+		count++
+		return uploadFile(ctx, s3Client, options, localPath, key, info.Size())
+	})
+	if err != nil {
+		return "", err
+	}
+	logger.Debug("Uploaded %d file(s) to %s", count, cellsRoot)
+	return cellsRoot, nil
+}
+
+// uploadFile uploads a single file, in parts if it is larger than the part size, and verifies the stored checksum.
+func uploadFile(ctx context.Context, s3Client *s3.Client, options TransferOptions, localPath, key string, size int64) error {
+	partSize, err := cellss3.ComputePartSize(size, options.PartSizeMB, maxUploadParts)
+	if err != nil {
+		return fmt.Errorf("error computing part size: %w", err)
+	}
+	file, err := os.Open(filepath.Clean(localPath))
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	tracker := newProgressTracker(key, size, options.Progress)
+	if size <= partSize {
+		err = utils.Retry(utils.DefaultRetryAttempts, utils.DefaultInitialDelay, func() error {
+			_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:        aws.String(cellssdk.DefaultS3Bucket),
+				Key:           aws.String(key),
+				Body:          io.NewSectionReader(file, 0, size),
+				ContentLength: aws.Int64(size),
+			})
+			return err
+		}, utils.IsTransientError)
+		tracker.add(size)
+	} else {
+		err = uploadMultipart(ctx, s3Client, options, file, key, size, partSize, tracker)
+	}
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %w", key, err)
+	}
+
+	// Hash-verified completion
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(cellssdk.DefaultS3Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("error reading uploaded node %s: %w", key, err)
+	}
+	sum, err := fileChecksum(localPath, partSize)
+	if err != nil {
+		return err
+	}
+	if err := sum.verify(aws.ToInt64(head.ContentLength), aws.ToString(head.ETag)); err != nil {
+		return fmt.Errorf("error verifying upload of %s: %w", key, err)
+	}
+	return nil
+}
+
+// verifyUpload verifies a Cells file against the local file it was uploaded from, computing multipart ETags with the part size of sdk uploads.
+// The part size of cec uploads isn't known, so their multipart ETags are only verified by size.
+func verifyUpload(options TransferOptions, localPath string, size int64, etag string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("local file missing: %w", err)
+	}
+	var partSize int64
+	if options.Backend == TransferBackendSDK {
+		partSize, err = cellss3.ComputePartSize(info.Size(), options.PartSizeMB, maxUploadParts)
+		if err != nil {
+			return fmt.Errorf("error computing part size: %w", err)
+		}
+	}
+	sum, err := fileChecksum(localPath, partSize)
+	if err != nil {
+		return err
+	}
+	return sum.verify(size, etag)
+}
+
+// uploadMultipart uploads a file as a multipart upload.
+// Completed parts are recorded in the transfer state. An interrupted upload resumes with the same multipart upload,
+// skipping the parts Cells already holds with the ETag of the local part.
+func uploadMultipart(ctx context.Context, s3Client *s3.Client, options TransferOptions, file *os.File, key string, size, partSize int64, tracker *progressTracker) error {
+	state, err := loadTransferState(options.StateDir, "upload", options.scope, key)
+	if err != nil {
+		return err
+	}
+	if state.UploadID != "" {
+		resumeErr := fmt.Errorf("the file changed since it was started")
+		if state.matches(size, partSize, "", state.UploadID) {
+			resumeErr = resumeMultipart(ctx, s3Client, state, file)
+		}
+		if resumeErr != nil {
+			logger.Warn("Unable to resume upload of %s. Restarting: %v", key, resumeErr)
+			// Discard the parts of the previous upload
+			if err := abortMultipart(ctx, s3Client, key, state.UploadID); err != nil {
+				logger.Warn("Failed to abort previous upload of %s: %v", key, err)
+			}
+			state.reset(size, partSize)
+		} else {
+			logger.Info("Resuming upload of %s from part %d/%d", key, len(state.Completed)+1, state.numParts())
+		}
+	} else {
+		state.reset(size, partSize)
+	}
+
+	if state.UploadID == "" {
+		out, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(cellssdk.DefaultS3Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating multipart upload: %w", err)
+		}
+		state.UploadID = aws.ToString(out.UploadId)
+		if err := state.save(); err != nil {
+			return err
+		}
+	}
+
+	tracker.add(state.completedBytes())
+	err = transferParts(ctx, options.Concurrency, state, tracker, func(ctx context.Context, part int32, offset, length int64) (string, error) {
+		out, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(cellssdk.DefaultS3Bucket),
+			Key:           aws.String(key),
+			UploadId:      aws.String(state.UploadID),
+			PartNumber:    aws.Int32(part),
+			Body:          io.NewSectionReader(file, offset, length),
+			ContentLength: aws.Int64(length),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.ETag), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var completed []types.CompletedPart
+	for _, part := range state.completedParts() {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(part),
+			ETag:       aws.String(state.Completed[part]),
+		})
+	}
+	err = utils.Retry(utils.DefaultRetryAttempts, utils.DefaultInitialDelay, func() error {
+		_, err := s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(cellssdk.DefaultS3Bucket),
+			Key:             aws.String(key),
+			UploadId:        aws.String(state.UploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+		})
+		return err
+	}, utils.IsTransientError)
+	if err != nil {
+		return fmt.Errorf("error completing multipart upload: %w", err)
+	}
+	state.remove()
+	return nil
+}
+
+// abortMultipart aborts a multipart upload, so Cells discards its uploaded parts.
+func abortMultipart(ctx context.Context, s3Client *s3.Client, key, uploadID string) error {
+	_, err := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(cellssdk.DefaultS3Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug("Aborted upload of %s", key)
+	return nil
+}
+
+// resumeMultipart keeps the completed parts of the state that Cells holds with the ETag of the local part.
+// Returns an error if the multipart upload no longer exists.
+func resumeMultipart(ctx context.Context, s3Client *s3.Client, state *transferState, file *os.File) error {
+	uploaded := map[int32]string{}
+	paginator := s3.NewListPartsPaginator(s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(cellssdk.DefaultS3Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing uploaded parts: %w", err)
+		}
+		for _, part := range page.Parts {
+			uploaded[aws.ToInt32(part.PartNumber)] = aws.ToString(part.ETag)
+		}
+	}
+
+	completed := map[int32]string{}
+	for part, etag := range uploaded {
+		if part < 1 || part > state.numParts() {
+			continue
+		}
+		offset, length := state.partRange(part)
+		hash := md5.New() // #nosec G401 -- see import
+		if _, err := io.Copy(hash, io.NewSectionReader(file, offset, length)); err != nil {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+		if strings.Trim(etag, `"`) == hex.EncodeToString(hash.Sum(nil)) {
+			completed[part] = etag
+		}
+	}
+	state.Completed = completed
+	return state.save()
+}
+
+// transferParts transfers the pending parts of the state concurrently, recording each completed part.
+// Transient errors are retried for each part, so a network error only repeats the failed part.
+func transferParts(ctx context.Context, concurrency int, state *transferState, tracker *progressTracker, transfer func(ctx context.Context, part int32, offset, length int64) (string, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+schedule:
+	for _, part := range state.pendingParts() {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break schedule
+		}
+		wg.Add(1)
+		go func(part int32) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			offset, length := state.partRange(part)
+			var etag string
+			err := utils.Retry(utils.DefaultRetryAttempts, utils.DefaultInitialDelay, func() error {
+				var err error
+				etag, err = transfer(ctx, part, offset, length)
+				return err
+			}, utils.IsTransientError)
+			if err != nil {
+				fail(fmt.Errorf("part %d/%d: %w", part, state.numParts(), err))
+				return
+			}
+			if err := state.complete(part, etag); err != nil {
+				fail(err)
+				return
+			}
+			tracker.add(length)
+		}(part)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// objectInfo represents an object listed from the Cells gateway.
+type objectInfo struct {
+	key  string
+	size int64
+	etag string
+}
+
+// listObjects lists all objects under the prefix, recursively.
+func listObjects(ctx context.Context, s3Client *s3.Client, prefix string) ([]objectInfo, error) {
+	var objects []objectInfo
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(cellssdk.DefaultS3Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			// Skip folder markers
+			if strings.HasSuffix(key, "/") || path.Base(key) == ".pydio" {
+				continue
+			}
+			objects = append(objects, objectInfo{key: key, size: aws.ToInt64(object.Size), etag: aws.ToString(object.ETag)})
+		}
+	}
+	return objects, nil
+}
+
+// checksum holds the local checksums of a file, for comparison with the ETag stored by Cells.
+type checksum struct {
+	size          int64
+	md5           string // MD5 of the whole file
+	multipartETag string // ETag of a multipart upload with the part size. Empty if not uploaded in parts
+}
+
+// fileChecksum computes the checksums of a local file.
+// If partSize is positive and the file spans multiple parts, the multipart ETag is computed too.
+func fileChecksum(localPath string, partSize int64) (checksum, error) {
+	file, err := os.Open(filepath.Clean(localPath))
+	if err != nil {
+		return checksum{}, fmt.Errorf("error opening file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	whole := md5.New() // #nosec G401 -- see import
+	var partSums []byte
+	var size int64
+	for {
+		part := md5.New() // #nosec G401 -- see import
+		limit := partSize
+		if limit <= 0 {
+			limit = 1<<63 - 1
+		}
+		n, err := io.Copy(io.MultiWriter(whole, part), io.LimitReader(file, limit))
+		if err != nil {
+			return checksum{}, fmt.Errorf("error reading file: %w", err)
+		}
+		if n == 0 && size > 0 {
+			break
+		}
+		size += n
+		partSums = append(partSums, part.Sum(nil)...)
+		if n < limit {
+			break
+		}
+	}
+
+	sum := checksum{size: size, md5: hex.EncodeToString(whole.Sum(nil))}
+	if parts := len(partSums) / md5.Size; parts > 1 {
+		multipart := md5.Sum(partSums) // #nosec G401 -- see import
+		sum.multipartETag = fmt.Sprintf("%s-%d", hex.EncodeToString(multipart[:]), parts)
+	}
+	return sum, nil
+}
+
+// verify compares the local checksum with the size and ETag stored by Cells.
+// ETags that aren't MD5 based can't be compared, so only the size is verified for them.
+func (c checksum) verify(size int64, etag string) error {
+	if size != c.size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, c.size)
+	}
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+	matches := md5ETag.FindStringSubmatch(etag)
+	if matches == nil {
+		logger.Debug("ETag %q is not MD5 based. Only the size was verified", etag)
+		return nil
+	}
+	if etag == c.md5 || etag == c.multipartETag {
+		return nil
+	}
+	// Multipart ETags computed with a different part size (e.g. downloads) can only be verified by size
+	if matches[2] != "" && c.multipartETag == "" {
+		logger.Debug("Multipart ETag %q can't be compared locally. Only the size was verified", etag)
+		return nil
+	}
+	return fmt.Errorf("checksum mismatch: expected %s, got %s", etag, c.md5)
+}
+
+// progressTracker reports the progress of a file transfer at every 10%.
+type progressTracker struct {
+	path        string
+	total       int64
+	progress    ProgressFunc
+	transferred atomic.Int64
+	mu          sync.Mutex
+	reported    int64 // Last reported decile
+}
+
+func newProgressTracker(cellsPath string, total int64, progress ProgressFunc) *progressTracker {
+	return &progressTracker{path: cellsPath, total: total, progress: progress, reported: -1}
+}
+
+// add records transferred bytes and reports progress when a new decile is reached.
+func (t *progressTracker) add(n int64) {
+	if n <= 0 {
+		return
+	}
+	transferred := min(t.transferred.Add(n), t.total)
+	decile := transferred * 10 / t.total
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if decile <= t.reported {
+		return
+	}
+	t.reported = decile
+	t.progress(TransferProgress{Path: t.path, Transferred: transferred, Total: t.total})
+}
@@ -0,0 +1,268 @@
+package cells
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// transferState records the completed parts of a file transfer.
+// It is persisted in the transfer state directory after every part, so an interrupted transfer resumes from the last completed part.
+// Downloads resume across process restarts. Uploads only resume within a job, as each job uploads a new AIP under a new name.
+type transferState struct {
+	Scope     string           `json:"scope"`              // User and job the transfer belongs to
+	Key       string           `json:"key"`                // Cells path of the file
+	Size      int64            `json:"size"`               // Size of the file in bytes
+	PartSize  int64            `json:"partSize"`           // Part size in bytes
+	ETag      string           `json:"etag,omitempty"`     // Download: ETag of the object when the download started
+	UploadID  string           `json:"uploadId,omitempty"` // Upload: ID of the multipart upload
+	Completed map[int32]string `json:"completed"`          // Completed part numbers. Uploads record the part ETag
+
+	mu   sync.Mutex
+	path string // State file. The state isn't persisted if empty
+}
+
+// transferStateMaxAge is the age after which the state and partial content of an abandoned transfer are removed.
+const transferStateMaxAge = 7 * 24 * time.Hour
+
+// transferScope identifies the transfers of a user for a job, so concurrent jobs never share partial content.
+func transferScope(username, jobID string) string {
+	return username + ":" + jobID
+}
+
+// scopeUser returns the user of a transfer scope. Job IDs are node UUIDs, so the user is everything before the last colon.
+func scopeUser(scope string) string {
+	if i := strings.LastIndex(scope, ":"); i >= 0 {
+		return scope[:i]
+	}
+	return scope
+}
+
+// loadTransferState loads the persisted state of a transfer, or returns an empty state if there is none.
+// Transfers are identified by their scope and Cells path. The state is only kept in memory if stateDir is empty.
+func loadTransferState(stateDir, operation, scope, key string) (*transferState, error) {
+	state := &transferState{Scope: scope, Key: key, Completed: map[int32]string{}}
+	if stateDir == "" {
+		return state, nil
+	}
+	if err := os.MkdirAll(stateDir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating transfer state directory: %w", err)
+	}
+	id := sha256.Sum256([]byte(operation + ":" + scope + ":" + key))
+	state.path = filepath.Join(stateDir, operation+"-"+hex.EncodeToString(id[:8])+".json")
+
+	data, err := os.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading transfer state: %w", err)
+	}
+	persisted := &transferState{}
+	if err := json.Unmarshal(data, persisted); err != nil || persisted.Scope != scope || persisted.Key != key {
+		logger.Warn("Ignoring invalid transfer state %s: %v", state.path, err)
+		return state, nil
+	}
+	persisted.path = state.path
+	if persisted.Completed == nil {
+		persisted.Completed = map[int32]string{}
+	}
+	return persisted, nil
+}
+
+// matches checks if the state belongs to a transfer of the same content.
+func (s *transferState) matches(size, partSize int64, etag, uploadID string) bool {
+	return s.Size == size && s.PartSize == partSize && s.ETag == etag && s.UploadID == uploadID
+}
+
+// reset discards the completed parts and starts a new transfer.
+func (s *transferState) reset(size, partSize int64) {
+	s.Size = size
+	s.PartSize = partSize
+	s.ETag = ""
+	s.UploadID = ""
+	s.Completed = map[int32]string{}
+}
+
+// numParts returns the number of parts of the transfer.
+func (s *transferState) numParts() int32 {
+	if s.Size == 0 || s.PartSize == 0 {
+		return 0
+	}
+	return int32((s.Size + s.PartSize - 1) / s.PartSize)
+}
+
+// partRange returns the offset and length of a part. Parts are numbered from 1.
+func (s *transferState) partRange(part int32) (int64, int64) {
+	offset := int64(part-1) * s.PartSize
+	return offset, min(s.PartSize, s.Size-offset)
+}
+
+// pendingParts returns the part numbers not completed yet, in order.
+func (s *transferState) pendingParts() []int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []int32
+	for part := int32(1); part <= s.numParts(); part++ {
+		if _, ok := s.Completed[part]; !ok {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// completedParts returns the completed part numbers, in order.
+func (s *transferState) completedParts() []int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := make([]int32, 0, len(s.Completed))
+	for part := range s.Completed {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
+	return parts
+}
+
+// completedBytes returns the number of bytes transferred by the completed parts.
+func (s *transferState) completedBytes() int64 {
+	var total int64
+	for _, part := range s.completedParts() {
+		_, length := s.partRange(part)
+		total += length
+	}
+	return total
+}
+
+// complete records a completed part and persists the state.
+func (s *transferState) complete(part int32, etag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Completed[part] = etag
+	return s.saveLocked()
+}
+
+// save persists the state.
+func (s *transferState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+func (s *transferState) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error encoding transfer state: %w", err)
+	}
+	// Write then rename, so an interrupted save never corrupts the state
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("error writing transfer state: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("error saving transfer state: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the persisted state once the transfer is complete.
+func (s *transferState) remove() {
+	if s.path == "" {
+		return
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to remove transfer state %s: %v", s.path, err)
+	}
+}
+
+// partialPath returns the path the content of a download is written to until it is verified.
+// It is kept in the state directory when the state is persisted, so it survives the processing directory.
+func (s *transferState) partialPath(localPath string) string {
+	if s.path == "" {
+		return localPath + ".partial"
+	}
+	return s.path[:len(s.path)-len(filepath.Ext(s.path))] + ".partial"
+}
+
+// readTransferStates reads the persisted states of an operation, e.g. upload. Unreadable states are skipped.
+func readTransferStates(stateDir, operation string) []*transferState {
+	if stateDir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(stateDir, operation+"-*.json"))
+	if err != nil {
+		return nil
+	}
+	var states []*transferState
+	for _, statePath := range paths {
+		if state := readTransferState(statePath); state != nil {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// readTransferState reads a persisted state file, or returns nil if it can't be read.
+func readTransferState(statePath string) *transferState {
+	data, err := os.ReadFile(filepath.Clean(statePath))
+	if err != nil {
+		return nil
+	}
+	state := &transferState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil
+	}
+	state.path = statePath
+	return state
+}
+
+// removeStaleTransferStates removes the state and partial content of transfers not updated for longer than maxAge,
+// e.g. those of jobs that failed and weren't run again. The multipart upload of a stale upload state is aborted with abort first,
+// so Cells discards its parts. The state is removed even if it fails, abandoned uploads are never resumed.
+func removeStaleTransferStates(stateDir string, maxAge time.Duration, abort func(state *transferState) error) {
+	if stateDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to list transfer states: %v", err)
+		}
+		return
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasPrefix(name, "download-") || strings.HasPrefix(name, "upload-")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		statePath := filepath.Join(stateDir, name)
+		if strings.HasPrefix(name, "upload-") && filepath.Ext(name) == ".json" {
+			if state := readTransferState(statePath); state != nil && state.UploadID != "" {
+				if err := abort(state); err != nil {
+					logger.Warn("Failed to abort stale upload of %s: %v", state.Key, err)
+				}
+			}
+		}
+		if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove stale transfer state %s: %v", statePath, err)
+			continue
+		}
+		logger.Debug("Removed stale transfer state %s", statePath)
+	}
+}
@@ -0,0 +1,106 @@
+package cells
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// missRefreshInterval is the minimum time between refreshes triggered by cache misses,
+// so repeated lookups of a workspace that doesn't exist don't hammer Cells.
+const missRefreshInterval = 10 * time.Second
+
+// errWorkspaceNotFound is returned when a cells path references a workspace that is not in the collection.
+var errWorkspaceNotFound = errors.New("workspace not found")
+
+// workspaceCache caches the Cells workspace collection used to resolve template paths.
+// It is safe for concurrent use. Concurrent refreshes are coalesced into a single request.
+type workspaceCache struct {
+	fetch func(context.Context) (*models.RestWorkspaceCollection, error)
+	ttl   time.Duration // Refresh once the collection is older. Disabled if zero
+
+	mu         sync.RWMutex
+	collection *models.RestWorkspaceCollection
+	fetchedAt  time.Time
+
+	refreshMu sync.Mutex // Serialises refreshes
+}
+
+func newWorkspaceCache(fetch func(context.Context) (*models.RestWorkspaceCollection, error), ttl time.Duration) *workspaceCache {
+	return &workspaceCache{fetch: fetch, ttl: ttl}
+}
+
+// get returns the workspace collection, refreshing it first if it is older than the TTL.
+// The stale collection is returned if the refresh fails.
+func (w *workspaceCache) get(ctx context.Context) *models.RestWorkspaceCollection {
+	if w.ttl > 0 && time.Since(w.lastFetch()) > w.ttl {
+		if err := w.refreshIfOlder(ctx, time.Now().Add(-w.ttl)); err != nil {
+			logger.Error("Failed to refresh workspace collection. Using cached collection: %v", err)
+		}
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.collection == nil {
+		return &models.RestWorkspaceCollection{}
+	}
+	return w.collection
+}
+
+// refresh fetches the workspace collection now.
+func (w *workspaceCache) refresh(ctx context.Context) error {
+	return w.refreshIfOlder(ctx, time.Now())
+}
+
+// refreshOnMiss refreshes the workspace collection after a lookup missed, unless it was refreshed very recently.
+// Returns true if the collection was refreshed and the lookup is worth retrying.
+func (w *workspaceCache) refreshOnMiss(ctx context.Context) bool {
+	before := w.lastFetch()
+	if err := w.refreshIfOlder(ctx, time.Now().Add(-missRefreshInterval)); err != nil {
+		logger.Error("Failed to refresh workspace collection: %v", err)
+		return false
+	}
+	return w.lastFetch().After(before)
+}
+
+// refreshIfOlder fetches the workspace collection if it was last fetched before the given time.
+// Callers waiting on a refresh in progress reuse its result.
+func (w *workspaceCache) refreshIfOlder(ctx context.Context, t time.Time) error {
+	w.refreshMu.Lock()
+	defer w.refreshMu.Unlock()
+	if w.lastFetch().After(t) {
+		return nil
+	}
+	collection, err := w.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if collection == nil {
+		return fmt.Errorf("workspace collection is nil")
+	}
+	w.mu.Lock()
+	w.collection = collection
+	w.fetchedAt = time.Now()
+	w.mu.Unlock()
+	logger.Debug("Refreshed workspace collection: %d workspace(s)", len(collection.Workspaces))
+	return nil
+}
+
+func (w *workspaceCache) lastFetch() time.Time {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.fetchedAt
+}
+
+// RefreshWorkspaces fetches the Cells workspace collection now.
+// Use it after creating a workspace or changing a template path in Cells.
+func (c *Client) RefreshWorkspaces(ctx context.Context) error {
+	if err := c.workspaces.refresh(ctx); err != nil {
+		return fmt.Errorf("error refreshing workspace collection: %w", err)
+	}
+	return nil
+}
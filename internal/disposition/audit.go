@@ -0,0 +1,130 @@
+package disposition
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/penwern/curate-preservation-core/pkg/version"
+)
+
+// Audit event types.
+const (
+	EventRelocation        = "relocation"
+	EventDeletionScheduled = "deletion scheduled"
+	EventDeletion          = "deletion"
+)
+
+// Audit event outcomes.
+const (
+	OutcomeSuccess   = "success"
+	OutcomeFailure   = "failure"
+	OutcomeCancelled = "cancelled"
+)
+
+// AuditEvent is a disposition audit entry, in the PREMIS event JSON shape Cells stores in node metadata.
+type AuditEvent struct {
+	EventIdentifier          EventIdentifier           `json:"event_identifier"`
+	EventType                string                    `json:"event_type"`
+	EventDateTime            string                    `json:"event_date_time"`
+	EventDetailInformation   DetailInformation         `json:"event_detail_information"`
+	EventOutcomeInformation  OutcomeInformation        `json:"event_outcome_information"`
+	LinkingAgentIdentifiers  []LinkingAgentIdentifier  `json:"linking_agent_identifiers"`
+	LinkingObjectIdentifiers []LinkingObjectIdentifier `json:"linking_object_identifiers"`
+}
+
+// EventIdentifier identifies an audit event.
+type EventIdentifier struct {
+	Type  string `json:"event_identifier_type"`
+	Value string `json:"event_identifier_value"`
+}
+
+// LinkingAgentIdentifier identifies an agent of an audit event.
+type LinkingAgentIdentifier struct {
+	Type  string `json:"linking_agent_identifier_type"`
+	Value string `json:"linking_agent_identifier_value"`
+}
+
+// LinkingObjectIdentifier identifies an object of an audit event.
+type LinkingObjectIdentifier struct {
+	Type  string `json:"linking_object_identifier_type"`
+	Value string `json:"linking_object_identifier_value"`
+}
+
+// DetailInformation describes an audit event.
+type DetailInformation struct {
+	EventDetail string `json:"event_detail"`
+}
+
+// OutcomeInformation holds the outcome of an audit event.
+type OutcomeInformation struct {
+	EventOutcome       string        `json:"event_outcome"`
+	EventOutcomeDetail OutcomeDetail `json:"event_outcome_detail"`
+}
+
+// OutcomeDetail details the outcome of an audit event.
+type OutcomeDetail struct {
+	EventOutcomeDetailNote string `json:"event_outcome_detail_note"`
+}
+
+// NewAuditEvent creates an audit event on a source node and its AIP, by the preservation system for a user.
+func NewAuditEvent(eventType, detail, outcome, note, username, nodeUUID, aipUUID string) AuditEvent {
+	event := AuditEvent{
+		EventIdentifier: EventIdentifier{Type: "UUID", Value: uuid.New().String()},
+		EventType:       eventType,
+		EventDateTime:   time.Now().UTC().Format(time.RFC3339),
+		EventDetailInformation: DetailInformation{
+			EventDetail: detail,
+		},
+		EventOutcomeInformation: OutcomeInformation{
+			EventOutcome:       outcome,
+			EventOutcomeDetail: OutcomeDetail{EventOutcomeDetailNote: note},
+		},
+		LinkingAgentIdentifiers: []LinkingAgentIdentifier{
+			{Type: "Preservation System", Value: version.Identifier()},
+			{Type: "Cells User Login", Value: username},
+		},
+		LinkingObjectIdentifiers: []LinkingObjectIdentifier{{Type: "UUID", Value: nodeUUID}},
+	}
+	if aipUUID != "" {
+		event.LinkingObjectIdentifiers = append(event.LinkingObjectIdentifiers, LinkingObjectIdentifier{Type: "UUID", Value: aipUUID})
+	}
+	return event
+}
+
+// AuditLog appends audit events to a JSON lines file.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewAuditLog opens the audit log at a path. The file is created on the first event.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record appends an event to the audit log.
+func (a *AuditLog) Record(event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o750); err != nil {
+		return fmt.Errorf("error creating disposition directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Clean(a.path), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,181 @@
+// Package disposition applies the records policy to the source of a preserved package once its AIP is verified:
+// leave it, move it to a preserved folder, or delete it after a review period.
+// Scheduled deletions wait in a reviewable queue, and every action is recorded in a PREMIS-style audit log.
+package disposition
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Disposition actions.
+const (
+	ActionLeave  = "leave"  // Leave the source in place
+	ActionMove   = "move"   // Move the source to the configured folder
+	ActionDelete = "delete" // Schedule the source for deletion after the review period
+)
+
+// Files, within the disposition directory.
+const (
+	queueFile = "queue.json"
+	auditFile = "audit.jsonl"
+)
+
+// Validate checks a disposition action. Empty selects the configured action.
+func Validate(action string) error {
+	switch action {
+	case "", ActionLeave, ActionMove, ActionDelete:
+		return nil
+	}
+	return fmt.Errorf("invalid disposition %q: must be %s, %s or %s", action, ActionLeave, ActionMove, ActionDelete)
+}
+
+// QueuePath returns the path of the deletion queue in a disposition directory.
+func QueuePath(dir string) string {
+	return filepath.Join(dir, queueFile)
+}
+
+// AuditPath returns the path of the audit log in a disposition directory.
+func AuditPath(dir string) string {
+	return filepath.Join(dir, auditFile)
+}
+
+// Entry is a source node scheduled for deletion once its review period ends.
+type Entry struct {
+	NodeUUID    string    `json:"node_uuid"`
+	NodePath    string    `json:"node_path"` // Workspace path when it was preserved
+	NodeEtag    string    `json:"node_etag"` // Deletion is cancelled if the node changed since it was preserved
+	Username    string    `json:"username"`  // The node is deleted as this user
+	AipUUID     string    `json:"aip_uuid"`
+	AipLocation string    `json:"aip_location"`
+	Preserved   time.Time `json:"preserved"`
+	Due         time.Time `json:"due"`
+}
+
+// Queue is the reviewable queue of scheduled deletions, persisted as a JSON file.
+// Entries are keyed by node UUID, scheduling a node again replaces its entry.
+// The server and the disposition commands share the file, so every change is made under a file lock held across processes.
+type Queue struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewQueue opens the deletion queue persisted at a path. The file is created on the first entry.
+func NewQueue(path string) *Queue {
+	return &Queue{path: path}
+}
+
+// Add schedules a node for deletion.
+func (q *Queue) Add(entry Entry) error {
+	return q.locked(func() error {
+		entries, err := q.load()
+		if err != nil {
+			return err
+		}
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.NodeUUID != entry.NodeUUID {
+				kept = append(kept, e)
+			}
+		}
+		return q.save(append(kept, entry))
+	})
+}
+
+// List returns the scheduled deletions, the earliest due first.
+func (q *Queue) List() ([]Entry, error) {
+	var entries []Entry
+	err := q.locked(func() error {
+		var err error
+		entries, err = q.load()
+		return err
+	})
+	return entries, err
+}
+
+// Remove removes the entry of a node. Reports whether the node was scheduled.
+func (q *Queue) Remove(nodeUUID string) (bool, error) {
+	removed := false
+	err := q.locked(func() error {
+		entries, err := q.load()
+		if err != nil {
+			return err
+		}
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.NodeUUID != nodeUUID {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(entries) {
+			return nil
+		}
+		removed = true
+		return q.save(kept)
+	})
+	return removed, err
+}
+
+// locked runs fn holding the queue lock, within the process and across processes sharing the queue file.
+func (q *Queue) locked(fn func() error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(q.path), 0o750); err != nil {
+		return fmt.Errorf("error creating disposition directory: %w", err)
+	}
+	lock, err := os.OpenFile(filepath.Clean(q.path+".lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening disposition queue lock: %w", err)
+	}
+	defer func() { _ = lock.Close() }()
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("error locking disposition queue: %w", err)
+	}
+	defer func() { _ = unlockFile(lock) }()
+	return fn()
+}
+
+func (q *Queue) load() ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Clean(q.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading disposition queue: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing disposition queue %s: %w", q.path, err)
+	}
+	return entries, nil
+}
+
+// save persists the entries atomically, through a temporary file of its own. The queue must be locked.
+func (q *Queue) save(entries []Entry) error {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Due.Before(entries[j].Due) })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing disposition queue: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error writing disposition queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing disposition queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("error writing disposition queue: %w", err)
+	}
+	return nil
+}
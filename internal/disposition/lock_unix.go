@@ -0,0 +1,22 @@
+//go:build !windows
+
+package disposition
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on an open file, shared with other processes. Blocks until it is taken.
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,139 @@
+package disposition
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// Report outcomes, besides the audit event outcomes.
+const (
+	OutcomeDue = "due" // Dry runs only
+)
+
+// Report is the outcome of processing the due deletions of the queue.
+type Report struct {
+	DryRun bool         `json:"dry_run"`
+	Items  []ReportItem `json:"items"`
+	Failed int          `json:"failed"`
+}
+
+// ReportItem is the outcome of a due deletion.
+type ReportItem struct {
+	Entry   Entry  `json:"entry"`
+	Outcome string `json:"outcome"`
+	Note    string `json:"note,omitempty"`
+}
+
+// Runner deletes the sources whose review period ended.
+type Runner struct {
+	cellsClient cells.ClientInterface
+	queue       *Queue
+	audit       *AuditLog
+	insecure    bool
+}
+
+// NewRunner creates a runner for the deletion queue. Nodes are deleted as the user who preserved them.
+func NewRunner(cellsClient cells.ClientInterface, queue *Queue, audit *AuditLog, insecure bool) *Runner {
+	return &Runner{cellsClient: cellsClient, queue: queue, audit: audit, insecure: insecure}
+}
+
+// Run deletes the nodes due at a time. Nodes changed since they were preserved aren't deleted, and leave the queue.
+// Failed deletions stay in the queue, to be retried on the next run. A dry run only reports the due nodes.
+func (r *Runner) Run(ctx context.Context, now time.Time, dryRun bool) (*Report, error) {
+	entries, err := r.queue.List()
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{DryRun: dryRun}
+	userClients := map[string]cells.UserClient{}
+	defer func() {
+		for _, userClient := range userClients {
+			if err := r.cellsClient.CloseUserClient(context.WithoutCancel(ctx), userClient); err != nil {
+				logger.Error("Failed to close user client: %v", err)
+			}
+		}
+	}()
+
+	for _, entry := range entries {
+		if entry.Due.After(now) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		item := r.delete(ctx, entry, userClients, dryRun)
+		if item.Outcome == OutcomeFailure {
+			report.Failed++
+			logger.Error("Failed to delete %s (%s): %s", entry.NodePath, entry.NodeUUID, item.Note)
+		}
+		report.Items = append(report.Items, item)
+	}
+	return report, nil
+}
+
+// delete deletes a due node, records the audit event and removes it from the queue, unless it failed.
+func (r *Runner) delete(ctx context.Context, entry Entry, userClients map[string]cells.UserClient, dryRun bool) ReportItem {
+	item := ReportItem{Entry: entry}
+	outcome, note, err := r.deleteNode(ctx, entry, userClients, dryRun)
+	item.Outcome, item.Note = outcome, note
+	if err != nil {
+		item.Outcome, item.Note = OutcomeFailure, err.Error()
+	}
+	if dryRun {
+		return item
+	}
+
+	detail := fmt.Sprintf("Source %s deleted after its review period, preserved in AIP %s at %s", entry.NodePath, entry.AipUUID, entry.AipLocation)
+	if item.Outcome != OutcomeSuccess {
+		detail = fmt.Sprintf("Deletion of source %s, preserved in AIP %s at %s", entry.NodePath, entry.AipUUID, entry.AipLocation)
+	}
+	if err := r.audit.Record(NewAuditEvent(EventDeletion, detail, item.Outcome, item.Note, entry.Username, entry.NodeUUID, entry.AipUUID)); err != nil {
+		logger.Error("Failed to record deletion of %s: %v", entry.NodePath, err)
+	}
+	if item.Outcome != OutcomeFailure {
+		if _, err := r.queue.Remove(entry.NodeUUID); err != nil {
+			item.Outcome, item.Note = OutcomeFailure, fmt.Sprintf("%s, but not removed from the queue: %v", item.Outcome, err)
+		}
+	}
+	return item
+}
+
+// deleteNode deletes a due node as its user. Returns the outcome and a note.
+func (r *Runner) deleteNode(ctx context.Context, entry Entry, userClients map[string]cells.UserClient, dryRun bool) (string, string, error) {
+	node, err := r.cellsClient.GetNodeByUUID(ctx, entry.NodeUUID)
+	if err != nil {
+		return "", "", fmt.Errorf("error getting node: %w", err)
+	}
+	if strings.Contains(node.Path, "/recycle_bin/") {
+		return OutcomeCancelled, "already deleted: " + node.Path, nil
+	}
+	if entry.NodeEtag != "" && node.Etag != entry.NodeEtag {
+		return OutcomeCancelled, "changed since it was preserved, preserve it again to schedule its deletion", nil
+	}
+
+	userClient, ok := userClients[entry.Username]
+	if !ok {
+		userClient, err = r.cellsClient.NewUserClient(ctx, entry.Username, r.insecure)
+		if err != nil {
+			return "", "", fmt.Errorf("error creating user client: %w", err)
+		}
+		userClients[entry.Username] = userClient
+	}
+	nodePath, err := r.cellsClient.UnresolveCellsPath(userClient, node.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("error finding node workspace path: %w", err)
+	}
+	if dryRun {
+		return OutcomeDue, nodePath, nil
+	}
+	if err := r.cellsClient.DeleteNode(ctx, userClient, nodePath); err != nil {
+		return "", "", err
+	}
+	logger.Info("Deleted %s (%s), preserved in AIP %s", nodePath, entry.NodeUUID, entry.AipUUID)
+	return OutcomeSuccess, nodePath, nil
+}
@@ -0,0 +1,429 @@
+// Package migration rewrites deprecated Cells metadata namespaces into their current equivalents.
+// Once every node is migrated, the compatibility code reading the deprecated namespaces can be removed.
+package migration
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+const (
+	// premisNamespace holds the PREMIS events of a node.
+	premisNamespace = "Premis"
+	// legacyPremisNamespace is the deprecated namespace of PREMIS events, merged into premisNamespace.
+	legacyPremisNamespace = "usermeta-premis-data"
+)
+
+// Options configures a migration.
+type Options struct {
+	DryRun    bool   // Report the changes without writing them
+	StatePath string // Records migrated nodes, so an interrupted migration resumes where it stopped. Disabled if empty
+}
+
+// NodeChange is the migration of a single node.
+type NodeChange struct {
+	UUID   string                     `json:"uuid"`
+	Path   string                     `json:"path"`
+	Set    map[string]json.RawMessage `json:"set,omitempty"`    // Namespaces written, with their new raw JSON value
+	Delete []string                   `json:"delete,omitempty"` // Deprecated namespaces removed
+	Notes  []string                   `json:"notes,omitempty"`
+}
+
+// Report summarises a migration.
+type Report struct {
+	Root     string       `json:"root"`
+	DryRun   bool         `json:"dryRun"`
+	Scanned  int          `json:"scanned"`
+	Migrated int          `json:"migrated"`
+	Resumed  int          `json:"resumed"` // Nodes skipped as already migrated by a previous run
+	Failed   int          `json:"failed"`
+	Changes  []NodeChange `json:"changes"`
+	Errors   []string     `json:"errors,omitempty"`
+}
+
+// statusRule migrates a deprecated status namespace to its current label and code namespaces.
+type statusRule struct {
+	legacy  string
+	current string
+	code    string // Empty if status codes are disabled
+}
+
+// Migrator migrates deprecated metadata namespaces of the nodes of a Cells subtree.
+type Migrator struct {
+	client       cells.ClientInterface
+	userClient   cells.UserClient // Writes the metadata. Must be able to edit the nodes
+	statusRules  []statusRule
+	vocabularies config.StatusVocabularies
+}
+
+// NewMigrator creates a migrator. The status namespaces and vocabularies come from the environment configuration,
+// so migrated status labels also get their status code.
+func NewMigrator(client cells.ClientInterface, userClient cells.UserClient, cfg *config.Config, vocabularies config.StatusVocabularies) *Migrator {
+	m := &Migrator{
+		client:       client,
+		userClient:   userClient,
+		vocabularies: vocabularies,
+	}
+	status := cfg.Status
+	if status.LegacyPreservationNamespace != "" && status.PreservationNamespace != "" {
+		m.statusRules = append(m.statusRules, statusRule{status.LegacyPreservationNamespace, status.PreservationNamespace, status.PreservationCodeNamespace})
+	}
+	if status.LegacyDipNamespace != "" && status.DipNamespace != "" {
+		m.statusRules = append(m.statusRules, statusRule{status.LegacyDipNamespace, status.DipNamespace, status.DipCodeNamespace})
+	}
+	return m
+}
+
+// Run migrates the nodes below an absolute Cells path, e.g. personal/username, including the node itself.
+// Failed nodes are reported and retried by the next run.
+func (m *Migrator) Run(ctx context.Context, root string, opts Options) (*Report, error) {
+	root = strings.Trim(root, "/")
+	report := &Report{Root: root, DryRun: opts.DryRun}
+
+	var state *migrationState
+	if opts.StatePath != "" && !opts.DryRun {
+		var err error
+		state, err = loadMigrationState(opts.StatePath, root)
+		if err != nil {
+			return nil, err
+		}
+		defer state.close()
+	}
+
+	migrate := func(node *models.TreeNode) error {
+		return m.migrateNode(ctx, node, opts, state, report)
+	}
+	parent, err := m.client.WalkNodeCollection(ctx, root, migrate)
+	if err != nil {
+		return report, fmt.Errorf("error listing %s: %w", root, err)
+	}
+	if err := migrate(parent); err != nil {
+		return report, err
+	}
+
+	// A complete migration needs no resuming
+	if state != nil && report.Failed == 0 {
+		state.remove()
+	}
+	return report, nil
+}
+
+// migrateNode migrates a single node, recording it in the report. Only errors stopping the migration are returned.
+func (m *Migrator) migrateNode(ctx context.Context, node *models.TreeNode, opts Options, state *migrationState, report *Report) error {
+	if node == nil || node.UUID == "" {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	report.Scanned++
+	if state != nil && state.done(node.UUID) {
+		report.Resumed++
+		return nil
+	}
+
+	change, err := m.plan(node)
+	if err != nil {
+		report.Failed++
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", node.Path, err))
+		return nil
+	}
+	if len(change.Set) == 0 && len(change.Delete) == 0 {
+		return nil
+	}
+	report.Changes = append(report.Changes, change)
+	if opts.DryRun {
+		return nil
+	}
+
+	if err := m.apply(ctx, change); err != nil {
+		report.Failed++
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", node.Path, err))
+		logger.Error("Failed to migrate %s: %v", node.Path, err)
+		return nil
+	}
+	report.Migrated++
+	logger.Debug("Migrated %s", node.Path)
+	if state != nil {
+		return state.add(node.UUID)
+	}
+	return nil
+}
+
+// plan works out the migration of a node from its metadata.
+func (m *Migrator) plan(node *models.TreeNode) (NodeChange, error) {
+	change := NodeChange{UUID: node.UUID, Path: node.Path, Set: map[string]json.RawMessage{}}
+
+	for _, rule := range m.statusRules {
+		legacyValue, ok := node.MetaStore[rule.legacy]
+		if !ok {
+			continue
+		}
+		change.Delete = append(change.Delete, rule.legacy)
+		label := decodeString(legacyValue)
+		if label == "" {
+			continue
+		}
+		if decodeString(node.MetaStore[rule.current]) != "" {
+			change.Notes = append(change.Notes, fmt.Sprintf("%s already set, dropping %s value %q", rule.current, rule.legacy, label))
+			continue
+		}
+		change.Set[rule.current] = encodeString(label)
+		if rule.code == "" || decodeString(node.MetaStore[rule.code]) != "" {
+			continue
+		}
+		if code, ok := m.statusCode(label); ok {
+			change.Set[rule.code] = encodeString(string(code))
+		} else {
+			change.Notes = append(change.Notes, fmt.Sprintf("no status code for %q", label))
+		}
+	}
+
+	if legacyValue, ok := node.MetaStore[legacyPremisNamespace]; ok {
+		merged, added, err := mergePremisEvents(node.MetaStore[premisNamespace], legacyValue)
+		if err != nil {
+			return NodeChange{}, err
+		}
+		if added > 0 {
+			change.Set[premisNamespace] = merged
+			change.Notes = append(change.Notes, fmt.Sprintf("merged %d PREMIS event(s)", added))
+		}
+		change.Delete = append(change.Delete, legacyPremisNamespace)
+	}
+	return change, nil
+}
+
+// apply writes the new namespaces before deleting the deprecated ones, so an interruption never loses a value.
+func (m *Migrator) apply(ctx context.Context, change NodeChange) error {
+	if err := m.client.UpdateMeta(ctx, m.userClient, change.UUID, change.Set); err != nil {
+		return err
+	}
+	return m.client.DeleteMeta(ctx, m.userClient, change.UUID, change.Delete)
+}
+
+// statusCode finds the status code of a status label in any vocabulary. Labels may carry a detail, e.g. "❌ Failed: error".
+func (m *Migrator) statusCode(label string) (config.StatusCode, bool) {
+	names := make([]string, 0, len(m.vocabularies))
+	for name := range m.vocabularies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for code, vocabularyLabel := range m.vocabularies[name] {
+			if label == vocabularyLabel || strings.HasPrefix(label, vocabularyLabel+": ") {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mergePremisEvents appends the legacy PREMIS events missing from the current ones.
+// Returns the merged JSON array and the number of events added.
+func mergePremisEvents(current, legacy string) (json.RawMessage, int, error) {
+	var events, legacyEvents []json.RawMessage
+	if strings.TrimSpace(current) != "" {
+		if err := json.Unmarshal([]byte(current), &events); err != nil {
+			return nil, 0, fmt.Errorf("error unmarshalling %s: %w", premisNamespace, err)
+		}
+	}
+	if strings.TrimSpace(legacy) != "" {
+		if err := json.Unmarshal([]byte(legacy), &legacyEvents); err != nil {
+			return nil, 0, fmt.Errorf("error unmarshalling %s: %w", legacyPremisNamespace, err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, event := range events {
+		seen[canonicalJSON(event)] = true
+	}
+	added := 0
+	for _, event := range legacyEvents {
+		key := canonicalJSON(event)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		events = append(events, event)
+		added++
+	}
+	merged, err := json.Marshal(events)
+	if err != nil {
+		return nil, 0, err
+	}
+	return merged, added, nil
+}
+
+// canonicalJSON re-encodes a JSON value with sorted keys, so equal events compare equal.
+func canonicalJSON(raw json.RawMessage) string {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return string(raw)
+	}
+	return string(canonical)
+}
+
+// decodeString unwraps a JSON encoded metadata string. Other values are returned as they are.
+func decodeString(value string) string {
+	var decoded string
+	if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+		return decoded
+	}
+	return value
+}
+
+func encodeString(value string) json.RawMessage {
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
+
+// migrationState records the nodes migrated below a root in an append-only JSON lines log, written after each node:
+// a header line naming the root, then a line per migrated node. Appending keeps each write the size of a line,
+// however many nodes were migrated.
+type migrationState struct {
+	path     string
+	file     *os.File
+	migrated map[string]bool
+}
+
+// migrationStateHeader is the first line of a migration state log.
+type migrationStateHeader struct {
+	Root string `json:"root"`
+}
+
+// migrationStateEntry is a line of a migration state log recording a migrated node.
+type migrationStateEntry struct {
+	UUID string `json:"uuid"`
+}
+
+func loadMigrationState(path, root string) (*migrationState, error) {
+	state := &migrationState{path: path, migrated: map[string]bool{}}
+	hasHeader, terminated, err := state.read(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("error creating migration state directory: %w", err)
+	}
+	state.file, err = os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening migration state: %w", err)
+	}
+	if !hasHeader {
+		if err := state.append(migrationStateHeader{Root: root}); err != nil {
+			state.close()
+			return nil, err
+		}
+		return state, nil
+	}
+	// Terminate a line cut short by an interruption, so the next entry starts on its own line
+	if !terminated {
+		if _, err := state.file.Write([]byte{'\n'}); err != nil {
+			state.close()
+			return nil, fmt.Errorf("error writing migration state: %w", err)
+		}
+	}
+	logger.Info("Resuming migration of %s: %d node(s) already migrated", root, len(state.migrated))
+	return state, nil
+}
+
+// read loads the migrated nodes from an existing log. Returns false if there is no log yet,
+// and whether its last line is terminated. A line cut short by an interruption is ignored, the node is migrated again.
+func (s *migrationState) read(root string) (bool, bool, error) {
+	file, err := os.Open(filepath.Clean(s.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, true, nil
+		}
+		return false, false, fmt.Errorf("error reading migration state: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return false, true, scanner.Err()
+	}
+	var header migrationStateHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return false, false, fmt.Errorf("error parsing migration state %s: %w", s.path, err)
+	}
+	if header.Root != root {
+		return false, false, fmt.Errorf("migration state %s belongs to %s, not %s", s.path, header.Root, root)
+	}
+	for scanner.Scan() {
+		var entry migrationStateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.UUID == "" {
+			logger.Warn("Ignoring invalid line in migration state %s: %q", s.path, scanner.Text())
+			continue
+		}
+		s.migrated[entry.UUID] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return false, false, fmt.Errorf("error reading migration state: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, false, fmt.Errorf("error reading migration state: %w", err)
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, false, fmt.Errorf("error reading migration state: %w", err)
+	}
+	return true, last[0] == '\n', nil
+}
+
+func (s *migrationState) done(uuid string) bool {
+	return s.migrated[uuid]
+}
+
+// add records a migrated node, appending it to the log.
+func (s *migrationState) add(uuid string) error {
+	s.migrated[uuid] = true
+	return s.append(migrationStateEntry{UUID: uuid})
+}
+
+// append writes a line to the log.
+func (s *migrationState) append(line any) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing migration state: %w", err)
+	}
+	return nil
+}
+
+// close closes the log. It may be called more than once.
+func (s *migrationState) close() {
+	if s.file == nil {
+		return
+	}
+	if err := s.file.Close(); err != nil {
+		logger.Warn("Failed to close migration state %s: %v", s.path, err)
+	}
+	s.file = nil
+}
+
+func (s *migrationState) remove() {
+	s.close()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to remove migration state %s: %v", s.path, err)
+	}
+}
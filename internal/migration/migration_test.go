@@ -0,0 +1,243 @@
+package migration
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// newTestMigrator creates a migrator of the legacy preservation and DIP status namespaces, without a Cells client.
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+	logger.Initialize("error", filepath.Join(t.TempDir(), "test.log"))
+	cfg := &config.Config{}
+	cfg.Status.LegacyPreservationNamespace = "usermeta-a3m-progress"
+	cfg.Status.PreservationNamespace = "usermeta-preservation-status"
+	cfg.Status.PreservationCodeNamespace = "usermeta-preservation-status-code"
+	cfg.Status.LegacyDipNamespace = "usermeta-atom-progress"
+	cfg.Status.DipNamespace = "usermeta-dip-status"
+	vocabularies, err := config.LoadStatusVocabularies("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMigrator(nil, cells.UserClient{}, cfg, vocabularies)
+}
+
+func TestPlan(t *testing.T) {
+	m := newTestMigrator(t)
+	cases := []struct {
+		name   string
+		meta   map[string]string
+		set    map[string]string // Namespace to decoded value. PREMIS values are compared as raw JSON
+		delete []string
+		notes  []string
+	}{
+		{name: "current metadata only", meta: map[string]string{"usermeta-preservation-status": `"Preserved"`}},
+		{
+			name:   "status with code",
+			meta:   map[string]string{"usermeta-a3m-progress": `"🔒 Preserved"`},
+			set:    map[string]string{"usermeta-preservation-status": "🔒 Preserved", "usermeta-preservation-status-code": "preserved"},
+			delete: []string{"usermeta-a3m-progress"},
+		},
+		{
+			name:   "status with detail",
+			meta:   map[string]string{"usermeta-a3m-progress": `"Failed: A3M unavailable"`},
+			set:    map[string]string{"usermeta-preservation-status": "Failed: A3M unavailable", "usermeta-preservation-status-code": "failed"},
+			delete: []string{"usermeta-a3m-progress"},
+		},
+		{
+			name:   "status without code namespace",
+			meta:   map[string]string{"usermeta-atom-progress": `"Deposited"`},
+			set:    map[string]string{"usermeta-dip-status": "Deposited"},
+			delete: []string{"usermeta-atom-progress"},
+		},
+		{
+			name:   "unknown status",
+			meta:   map[string]string{"usermeta-a3m-progress": `"Archived by hand"`},
+			set:    map[string]string{"usermeta-preservation-status": "Archived by hand"},
+			delete: []string{"usermeta-a3m-progress"},
+			notes:  []string{`no status code for "Archived by hand"`},
+		},
+		{
+			name:   "status already set",
+			meta:   map[string]string{"usermeta-a3m-progress": `"Failed"`, "usermeta-preservation-status": `"Preserved"`},
+			delete: []string{"usermeta-a3m-progress"},
+			notes:  []string{`usermeta-preservation-status already set, dropping usermeta-a3m-progress value "Failed"`},
+		},
+		{
+			name:   "code already set",
+			meta:   map[string]string{"usermeta-a3m-progress": `"Preserved"`, "usermeta-preservation-status-code": `"failed"`},
+			set:    map[string]string{"usermeta-preservation-status": "Preserved"},
+			delete: []string{"usermeta-a3m-progress"},
+		},
+		{name: "empty status", meta: map[string]string{"usermeta-a3m-progress": `""`}, delete: []string{"usermeta-a3m-progress"}},
+		{
+			name:   "legacy PREMIS events",
+			meta:   map[string]string{"Premis": `[{"event_type":"a"}]`, "usermeta-premis-data": `[{"event_type":"a"},{"event_type":"b"}]`},
+			set:    map[string]string{"Premis": `[{"event_type":"a"},{"event_type":"b"}]`},
+			delete: []string{"usermeta-premis-data"},
+			notes:  []string{"merged 1 PREMIS event(s)"},
+		},
+		{
+			name:   "legacy PREMIS events already merged",
+			meta:   map[string]string{"Premis": `[{"event_type":"a"}]`, "usermeta-premis-data": `[{"event_type":"a"}]`},
+			delete: []string{"usermeta-premis-data"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			change, err := m.plan(&models.TreeNode{UUID: "n1", Path: "personal/alice/a.txt", MetaStore: tc.meta})
+			if err != nil {
+				t.Fatalf("plan: %v", err)
+			}
+			if len(change.Set) != len(tc.set) {
+				t.Fatalf("set: got %v, want %v", change.Set, tc.set)
+			}
+			for namespace, want := range tc.set {
+				got := string(change.Set[namespace])
+				if namespace != premisNamespace {
+					got = decodeString(got)
+				}
+				if got != want {
+					t.Errorf("set %s: got %s, want %s", namespace, got, want)
+				}
+			}
+			sort.Strings(change.Delete)
+			sort.Strings(tc.delete)
+			if strings.Join(change.Delete, ",") != strings.Join(tc.delete, ",") {
+				t.Errorf("delete: got %v, want %v", change.Delete, tc.delete)
+			}
+			if strings.Join(change.Notes, "|") != strings.Join(tc.notes, "|") {
+				t.Errorf("notes: got %q, want %q", change.Notes, tc.notes)
+			}
+		})
+	}
+
+	// Malformed legacy events fail the node, not the migration
+	_, err := m.plan(&models.TreeNode{UUID: "n1", MetaStore: map[string]string{"usermeta-premis-data": `{"event_type":`}})
+	if err == nil || !strings.Contains(err.Error(), "usermeta-premis-data") {
+		t.Fatalf("plan: got %v, want an error naming the legacy namespace", err)
+	}
+}
+
+func TestMergePremisEvents(t *testing.T) {
+	cases := []struct {
+		name    string
+		current string
+		legacy  string
+		want    string
+		added   int
+		wantErr string
+	}{
+		{name: "no current events", legacy: `[{"event_type":"a"}]`, want: `[{"event_type":"a"}]`, added: 1},
+		{name: "no legacy events", current: `[{"event_type":"a"}]`, legacy: " ", want: `[{"event_type":"a"}]`},
+		{
+			name:    "duplicates with other key order and spacing",
+			current: `[{"event_type":"a","event_date_time":"2025"}]`,
+			legacy:  `[{ "event_date_time": "2025", "event_type": "a" }, {"event_type":"b"}, {"event_type":"b"}]`,
+			want:    `[{"event_type":"a","event_date_time":"2025"},{"event_type":"b"}]`,
+			added:   1,
+		},
+		{name: "all duplicates", current: `[{"event_type":"a"}]`, legacy: `[{"event_type":"a"}]`, want: `[{"event_type":"a"}]`},
+		{name: "malformed current events", current: `{`, legacy: `[]`, wantErr: "Premis"},
+		{name: "legacy object", current: `[]`, legacy: `{"event_type":"a"}`, wantErr: "usermeta-premis-data"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, added, err := mergePremisEvents(tc.current, tc.legacy)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("merge: got %v, want an error naming %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("merge: %v", err)
+			}
+			if string(merged) != tc.want || added != tc.added {
+				t.Errorf("merge: got (%s, %d), want (%s, %d)", merged, added, tc.want, tc.added)
+			}
+		})
+	}
+}
+
+func TestLoadMigrationState(t *testing.T) {
+	logger.Initialize("error", filepath.Join(t.TempDir(), "test.log"))
+	header := `{"root":"personal/alice"}` + "\n"
+	cases := []struct {
+		name     string
+		log      string // Empty if there is no log yet
+		root     string
+		migrated []string
+		wantErr  string
+	}{
+		{name: "no log", root: "personal/alice"},
+		{name: "complete lines", log: header + `{"uuid":"a"}` + "\n" + `{"uuid":"b"}` + "\n", root: "personal/alice", migrated: []string{"a", "b"}},
+		{name: "truncated last line", log: header + `{"uuid":"a"}` + "\n" + `{"uu`, root: "personal/alice", migrated: []string{"a"}},
+		{name: "truncated header", log: `{"ro`, root: "personal/alice", wantErr: "error parsing migration state"},
+		{name: "other root", log: header, root: "personal/bob", wantErr: "belongs to personal/alice"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state", "migration.jsonl")
+			if tc.log != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tc.log), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			state, err := loadMigrationState(path, tc.root)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("load: got %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			defer state.close()
+			if len(state.migrated) != len(tc.migrated) {
+				t.Fatalf("migrated: got %v, want %v", state.migrated, tc.migrated)
+			}
+			for _, uuid := range tc.migrated {
+				if !state.done(uuid) {
+					t.Errorf("migrated: got %v, want %s", state.migrated, uuid)
+				}
+			}
+
+			// The next node is recorded on its own line, and read back on resume
+			if err := state.add("c"); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			state.close()
+			resumed, err := loadMigrationState(path, tc.root)
+			if err != nil {
+				t.Fatalf("resume: %v", err)
+			}
+			defer resumed.close()
+			if !resumed.done("c") || len(resumed.migrated) != len(tc.migrated)+1 {
+				t.Fatalf("resume: got %v, want the recorded nodes and c", resumed.migrated)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			var last migrationStateEntry
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil || last.UUID != "c" {
+				t.Errorf("log: got last line %q, want the entry of c", lines[len(lines)-1])
+			}
+		})
+	}
+}
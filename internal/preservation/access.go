@@ -0,0 +1,43 @@
+package preservation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// accessDocument is the submission document recording the access conditions of a package, below the transfer metadata folder.
+const accessDocument = "submissionDocumentation/cells-access.json"
+
+// writeAccessMetadata records who owned, could access and shared the package node in the transfer, as provenance.
+// Parts that can't be collected are listed in the document and don't fail the job.
+func (p *Preserver) writeAccessMetadata(ctx context.Context, transferPath, workspacePath string, node *models.TreeNode) error {
+	if !p.envConfig.Premis.RecordAccess {
+		return nil
+	}
+	workspace, _, _ := strings.Cut(strings.Trim(workspacePath, "/"), "/")
+	access := p.cellsClient.GetNodeAccess(ctx, workspace, node)
+	for _, accessErr := range access.Errors {
+		logger.Warn("Incomplete access metadata for %s: %s", node.Path, accessErr)
+	}
+
+	data, err := json.MarshalIndent(access, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding access metadata: %w", err)
+	}
+	documentPath := filepath.Join(transferPath, "metadata", filepath.FromSlash(accessDocument))
+	if err := os.MkdirAll(filepath.Dir(documentPath), 0o750); err != nil {
+		return fmt.Errorf("error creating submission documentation directory: %w", err)
+	}
+	if err := os.WriteFile(documentPath, data, 0o600); err != nil {
+		return fmt.Errorf("error writing access metadata: %w", err)
+	}
+	logger.Debug("Wrote access metadata: %s", documentPath)
+	return nil
+}
@@ -0,0 +1,109 @@
+package preservation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// archiveDirVars holds the variables of archive directory templates, e.g. common-files/aips/{{.Workspace}}/{{.Year}}/.
+type archiveDirVars struct {
+	Login     string // Job user login
+	GroupPath string // Job user group path, e.g. /org/team
+	Workspace string // Source workspace slug, e.g. personal-files
+	AipUUID   string
+	Date      string // YYYY-MM-DD
+	Year      string
+	Month     string // 01-12
+	Day       string // 01-31
+}
+
+func newArchiveDirVars(login, groupPath, workspacePath, aipUUID string, date time.Time) archiveDirVars {
+	workspace, _, _ := strings.Cut(strings.Trim(workspacePath, "/"), "/")
+	date = date.UTC()
+	return archiveDirVars{
+		Login:     login,
+		GroupPath: groupPath,
+		Workspace: workspace,
+		AipUUID:   aipUUID,
+		Date:      date.Format(time.DateOnly),
+		Year:      date.Format("2006"),
+		Month:     date.Format("01"),
+		Day:       date.Format("02"),
+	}
+}
+
+// ParseArchiveDir parses an archive directory template. Plain workspace paths, e.g. common-files, are valid templates.
+func ParseArchiveDir(dir string) (*template.Template, error) {
+	tmpl, err := template.New("archiveDir").Parse(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive directory %q: %w", dir, err)
+	}
+	// Unknown variables only fail on execution, so try it
+	if _, err := executeArchiveDir(tmpl, archiveDirVars{}); err != nil {
+		return nil, fmt.Errorf("invalid archive directory %q: %w", dir, err)
+	}
+	return tmpl, nil
+}
+
+// renderArchiveDir renders an archive directory template to a workspace path, e.g. common-files/aips/personal-files/2025.
+func renderArchiveDir(dir string, vars archiveDirVars) (string, error) {
+	tmpl, err := ParseArchiveDir(dir)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := executeArchiveDir(tmpl, vars)
+	if err != nil {
+		return "", fmt.Errorf("error rendering archive directory %q: %w", dir, err)
+	}
+	for _, segment := range strings.Split(rendered, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("archive directory %q rendered outside its workspace: %s", dir, rendered)
+		}
+	}
+	cleaned := strings.Trim(path.Clean("/"+rendered), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("archive directory %q rendered empty", dir)
+	}
+	return cleaned, nil
+}
+
+func executeArchiveDir(tmpl *template.Template, vars archiveDirVars) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// archiveDestination renders the job's archive directory, the configured archive workspace if empty, and creates its missing folders.
+func (p *Preserver) archiveDestination(ctx context.Context, userClient cells.UserClient, archiveDir, workspacePath, aipUUID string) (string, error) {
+	destination, err := p.renderDestination(userClient, archiveDir, workspacePath, aipUUID)
+	if err != nil {
+		return "", err
+	}
+	// Workspace roots can't be created
+	if strings.Contains(destination, "/") {
+		if err := p.cellsClient.CreateFolder(ctx, userClient, destination); err != nil {
+			return "", fmt.Errorf("error creating archive directory %s: %w", destination, err)
+		}
+	}
+	logger.Debug("Archive destination: %s", destination)
+	return destination, nil
+}
+
+// renderDestination renders the job's archive directory, the configured archive workspace if empty.
+func (p *Preserver) renderDestination(userClient cells.UserClient, archiveDir, workspacePath, aipUUID string) (string, error) {
+	if archiveDir == "" {
+		archiveDir = p.envConfig.Cells.ArchiveWorkspace
+	}
+	vars := newArchiveDirVars(userClient.UserData.Login, userClient.UserData.GroupPath, workspacePath, aipUUID, time.Now())
+	return renderArchiveDir(archiveDir, vars)
+}
@@ -0,0 +1,218 @@
+package preservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// Collision policies, applied when an AIP of the same package is already archived in the destination.
+const (
+	CollisionVersion = "version" // Upload the new AIP alongside the archived ones
+	CollisionSkip    = "skip"    // Keep the archived AIPs and discard the new one
+	CollisionReplace = "replace" // Upload the new AIP, then delete the archived ones
+	CollisionFail    = "fail"    // Fail the job
+)
+
+// renameSuffix matches the suffixes Cells adds to uploads named as an existing node, e.g. aip-1.7z or aip (1).7z.
+const renameSuffix = `(?:-\d+| \(\d+\))`
+
+// archivedAIPs lists the AIPs of a source node already archived in the destination workspace path.
+// AIPs are matched on the source node UUID written to them with the results, as different sources may share a transfer name.
+// The AIP location recorded on the source by its last preservation is looked up first, and the destination only listed if it isn't there,
+// so large destinations aren't listed for every job. A destination that doesn't exist yet holds no AIPs.
+func (p *Preserver) archivedAIPs(ctx context.Context, userClient cells.UserClient, destination string, source *models.TreeNode) ([]*models.TreeNode, error) {
+	ns := p.envConfig.Results
+	if ns.SourceUUIDNamespace == "" {
+		return nil, fmt.Errorf("collision policy %s requires CA4M_RESULTS_SOURCE_UUID_NAMESPACE", p.envConfig.Cells.CollisionPolicy)
+	}
+	if ns.AipLocationNamespace != "" {
+		if location := metaString(source.MetaStore[ns.AipLocationNamespace]); location != "" && path.Dir(strings.Trim(location, "/")) == strings.Trim(destination, "/") {
+			node, err := p.statDestination(ctx, userClient, destination, path.Base(location))
+			if err != nil {
+				return nil, err
+			}
+			if node != nil && metaString(node.MetaStore[ns.SourceUUIDNamespace]) == source.UUID {
+				return []*models.TreeNode{node}, nil
+			}
+		}
+	}
+
+	var aips []*models.TreeNode
+	err := p.walkDestination(ctx, userClient, destination, func(node *models.TreeNode) error {
+		if metaString(node.MetaStore[ns.SourceUUIDNamespace]) == source.UUID {
+			aips = append(aips, node)
+		}
+		return nil
+	})
+	if errors.Is(err, cells.ErrNodeNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return aips, nil
+}
+
+// collidingAIPs lists the AIPs of a source node archived in the job's archive directory, before its new AIP is produced.
+// Archive directories rendering a folder per AIP never collide.
+func (p *Preserver) collidingAIPs(ctx context.Context, userClient cells.UserClient, archiveDir, workspacePath string, source *models.TreeNode) (string, []*models.TreeNode, error) {
+	destination, err := p.renderDestination(userClient, archiveDir, workspacePath, "")
+	if err != nil {
+		return "", nil, err
+	}
+	perAIP, err := p.renderDestination(userClient, archiveDir, workspacePath, source.UUID)
+	if err != nil {
+		return "", nil, err
+	}
+	if perAIP != destination {
+		return destination, nil, nil
+	}
+	aips, err := p.archivedAIPs(ctx, userClient, destination, source)
+	return destination, aips, err
+}
+
+// archivedResults returns the results of the latest archived AIP of a source node, read from the AIP node metadata.
+// The content fingerprint is left out, the source may have changed since it was archived.
+func (p *Preserver) archivedResults(destination string, aips []*models.TreeNode, sourceUUID, sourcePath string) Results {
+	var latest *models.TreeNode
+	var latestMTime int64
+	for _, aip := range aips {
+		mtime, _ := strconv.ParseInt(aip.MTime, 10, 64)
+		if latest == nil || mtime > latestMTime {
+			latest, latestMTime = aip, mtime
+		}
+	}
+	ns := p.envConfig.Results
+	meta := func(namespace string) string {
+		if namespace == "" {
+			return ""
+		}
+		return metaString(latest.MetaStore[namespace])
+	}
+	results := Results{
+		AipUUID:         meta(ns.AipUUIDNamespace),
+		AipLocation:     path.Join(destination, path.Base(latest.Path)),
+		PreviousAipUUID: meta(ns.PreviousAipUUIDNamespace),
+		AipChecksum:     meta(ns.AipChecksumNamespace),
+		Profile:         meta(ns.ProfileNamespace),
+		AtomSlug:        meta(ns.AtomSlugNamespace),
+		DipURL:          meta(ns.DipURLNamespace),
+		SourceUUID:      sourceUUID,
+		SourcePath:      sourcePath,
+	}
+	results.Version, _ = strconv.Atoi(meta(ns.VersionNamespace))
+	results.AipSize, _ = strconv.ParseInt(meta(ns.AipSizeNamespace), 10, 64)
+	results.Date, _ = time.Parse(time.RFC3339, meta(ns.DateNamespace))
+	return results
+}
+
+// uploadedAIP reads the uploaded AIP back from its destination, as Cells may rename uploads named as an existing node.
+// The node named as uploaded is preferred, otherwise the latest renamed node of the AIP's size.
+// The node named as uploaded is looked up first, and the destination only listed for renamed nodes if it isn't there.
+// Returns the workspace path of the AIP and its node.
+func (p *Preserver) uploadedAIP(ctx context.Context, userClient cells.UserClient, destination, aipName string, aipSize int64) (string, *models.TreeNode, error) {
+	ext := path.Ext(aipName)
+	if strings.HasSuffix(strings.TrimSuffix(aipName, ext), ".tar") {
+		ext = ".tar" + ext
+	}
+	// Folder sizes aren't comparable, uncompressed AIPs are matched by name only
+	sameSize := func(node *models.TreeNode) bool {
+		if node.Type != nil && *node.Type == models.TreeNodeTypeCOLLECTION {
+			return true
+		}
+		size, err := strconv.ParseInt(node.Size, 10, 64)
+		return err == nil && size == aipSize
+	}
+	exact, err := p.statDestination(ctx, userClient, destination, aipName)
+	if err != nil {
+		return "", nil, err
+	}
+	if exact != nil && sameSize(exact) {
+		return path.Join(destination, aipName), exact, nil
+	}
+
+	renamed := regexp.MustCompile("^" + regexp.QuoteMeta(strings.TrimSuffix(aipName, ext)) + renameSuffix + regexp.QuoteMeta(ext) + "$")
+
+	var latest *models.TreeNode
+	var latestMTime int64
+	err = p.walkDestination(ctx, userClient, destination, func(node *models.TreeNode) error {
+		if !renamed.MatchString(path.Base(node.Path)) || !sameSize(node) {
+			return nil
+		}
+		mtime, _ := strconv.ParseInt(node.MTime, 10, 64)
+		if latest == nil || mtime > latestMTime {
+			latest, latestMTime = node, mtime
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if latest == nil {
+		return "", nil, fmt.Errorf("uploaded AIP %s not found in %s", aipName, destination)
+	}
+	logger.Warn("AIP %s was renamed on upload to %s", aipName, path.Base(latest.Path))
+	return path.Join(destination, path.Base(latest.Path)), latest, nil
+}
+
+// replaceArchivedAIPs deletes the archived AIPs replaced by a new AIP. Failures are logged, the new AIP is already archived.
+func (p *Preserver) replaceArchivedAIPs(ctx context.Context, userClient cells.UserClient, destination string, aips []*models.TreeNode) {
+	for _, aip := range aips {
+		aipPath := path.Join(destination, path.Base(aip.Path))
+		if err := p.cellsClient.DeleteNode(ctx, userClient, aipPath); err != nil {
+			logger.Error("Failed to delete replaced AIP %s: %v", aipPath, err)
+			continue
+		}
+		logger.Info("Deleted replaced AIP %s", aipPath)
+	}
+}
+
+// walkDestination calls fn for each node directly in a destination workspace path.
+func (p *Preserver) walkDestination(ctx context.Context, userClient cells.UserClient, destination string, fn func(node *models.TreeNode) error) error {
+	resolvedPath, err := p.cellsClient.ResolveCellsPath(userClient, destination)
+	if err != nil {
+		return fmt.Errorf("error resolving archive destination: %w", err)
+	}
+	if _, err := p.cellsClient.WalkNodeChildren(ctx, resolvedPath, fn); err != nil {
+		return fmt.Errorf("error listing archive destination: %w", err)
+	}
+	return nil
+}
+
+// statDestination looks up a node directly in a destination workspace path, or returns nil if there is none.
+func (p *Preserver) statDestination(ctx context.Context, userClient cells.UserClient, destination, name string) (*models.TreeNode, error) {
+	resolvedPath, err := p.cellsClient.ResolveCellsPath(userClient, destination)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving archive destination: %w", err)
+	}
+	stats, err := p.cellsClient.GetNodeStats(ctx, path.Join(resolvedPath, name))
+	if errors.Is(err, cells.ErrNodeNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s in archive destination: %w", name, err)
+	}
+	if stats == nil || stats.Node == nil {
+		return nil, nil
+	}
+	return stats.Node, nil
+}
+
+// aipNames joins the base names of AIP nodes, e.g. for logs and tag details.
+func aipNames(aips []*models.TreeNode) string {
+	names := make([]string, 0, len(aips))
+	for _, aip := range aips {
+		names = append(names, path.Base(aip.Path))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,116 @@
+package preservation
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/internal/disposition"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// dispositionDir is the directory, within the processing base directory, holding the deletion queue and disposition audit log.
+const dispositionDir = ".disposition"
+
+// DispositionDir returns the disposition directory of the environment configuration.
+func DispositionDir(processingBaseDir string) string {
+	return filepath.Join(processingBaseDir, dispositionDir)
+}
+
+// dispose applies the disposition action to a source preserved and verified in an AIP, the configured action if empty.
+// The source must be unlocked first. Failures are logged and audited, the AIP is already archived.
+func (p *Preserver) dispose(ctx context.Context, userClient cells.UserClient, action, nodeUUID string, results Results) {
+	if action == "" {
+		action = p.envConfig.Disposition.Action
+	}
+	if action == disposition.ActionLeave {
+		return
+	}
+	username := userClient.UserData.Login
+	// Look up the current path, in case the node moved since it was downloaded
+	workspacePath, err := p.workspacePath(ctx, userClient, nodeUUID)
+	if err != nil {
+		logger.Error("Failed to apply disposition %s to preserved source %s: %v", action, nodeUUID, err)
+		eventType := disposition.EventRelocation
+		if action == disposition.ActionDelete {
+			eventType = disposition.EventDeletionScheduled
+		}
+		detail := fmt.Sprintf("Disposition %s of source %s after its preservation in AIP %s at %s", action, nodeUUID, results.AipUUID, results.AipLocation)
+		p.recordDisposition(disposition.NewAuditEvent(eventType, detail, disposition.OutcomeFailure, err.Error(), username, nodeUUID, results.AipUUID))
+		return
+	}
+
+	switch action {
+	case disposition.ActionMove:
+		detail := fmt.Sprintf("Source %s relocated after its preservation in AIP %s at %s", workspacePath, results.AipUUID, results.AipLocation)
+		movedPath, err := p.moveSource(ctx, userClient, nodeUUID, workspacePath, results.AipUUID)
+		outcome, note := disposition.OutcomeSuccess, movedPath
+		if err != nil {
+			logger.Error("Failed to move preserved source %s: %v", workspacePath, err)
+			outcome, note = disposition.OutcomeFailure, err.Error()
+		} else {
+			logger.Info("Moved preserved source %s to %s", workspacePath, movedPath)
+		}
+		p.recordDisposition(disposition.NewAuditEvent(disposition.EventRelocation, detail, outcome, note, username, nodeUUID, results.AipUUID))
+	case disposition.ActionDelete:
+		detail := fmt.Sprintf("Source %s scheduled for deletion after its preservation in AIP %s at %s", workspacePath, results.AipUUID, results.AipLocation)
+		due, err := p.scheduleDeletion(ctx, username, nodeUUID, workspacePath, results)
+		outcome, note := disposition.OutcomeSuccess, "due "+due.Format(time.RFC3339)
+		if err != nil {
+			logger.Error("Failed to schedule deletion of preserved source %s: %v", workspacePath, err)
+			outcome, note = disposition.OutcomeFailure, err.Error()
+		} else {
+			logger.Info("Scheduled deletion of preserved source %s, due %s", workspacePath, due.Format(time.RFC3339))
+		}
+		p.recordDisposition(disposition.NewAuditEvent(disposition.EventDeletionScheduled, detail, outcome, note, username, nodeUUID, results.AipUUID))
+	}
+}
+
+// moveSource moves the source into the rendered disposition folder, creating its missing folders. Returns its new workspace path.
+func (p *Preserver) moveSource(ctx context.Context, userClient cells.UserClient, nodeUUID, workspacePath, aipUUID string) (string, error) {
+	vars := newArchiveDirVars(userClient.UserData.Login, userClient.UserData.GroupPath, workspacePath, aipUUID, time.Now())
+	target, err := renderArchiveDir(p.envConfig.Disposition.MoveDir, vars)
+	if err != nil {
+		return "", err
+	}
+	// Workspace roots can't be created
+	if strings.Contains(target, "/") {
+		if err := p.cellsClient.CreateFolder(ctx, userClient, target); err != nil {
+			return "", fmt.Errorf("error creating disposition folder %s: %w", target, err)
+		}
+	}
+	return p.cellsClient.MoveNode(ctx, userClient, nodeUUID, workspacePath, target)
+}
+
+// scheduleDeletion queues the source for deletion once the review period ends. Returns when it is due.
+func (p *Preserver) scheduleDeletion(ctx context.Context, username, nodeUUID, workspacePath string, results Results) (time.Time, error) {
+	// Deletion is cancelled if the node changes from now on
+	node, err := p.cellsClient.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting node %s: %w", nodeUUID, err)
+	}
+	now := time.Now().UTC()
+	entry := disposition.Entry{
+		NodeUUID:    nodeUUID,
+		NodePath:    workspacePath,
+		NodeEtag:    node.Etag,
+		Username:    username,
+		AipUUID:     results.AipUUID,
+		AipLocation: results.AipLocation,
+		Preserved:   now,
+		Due:         now.Add(p.envConfig.Disposition.ReviewPeriod),
+	}
+	if err := p.dispositionQueue.Add(entry); err != nil {
+		return time.Time{}, err
+	}
+	return entry.Due, nil
+}
+
+func (p *Preserver) recordDisposition(event disposition.AuditEvent) {
+	if err := p.dispositionAudit.Record(event); err != nil {
+		logger.Error("Failed to record %s of %s: %v", event.EventType, event.LinkingObjectIdentifiers[0].Value, err)
+	}
+}
@@ -0,0 +1,62 @@
+package preservation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/penwern/curate-preservation-core/pkg/premis"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// serviceEvents are the workflow events of a package recorded by the service in the transfer's PREMIS XML.
+// Package events are recorded on the package node object, fixity checks on each verified file.
+type serviceEvents struct {
+	rootUUID      string
+	workspacePath string // Workspace path the package was transferred from
+	transferred   time.Time
+	fixity        fixityChecks
+	a3mAddress    string
+}
+
+// premisEvents returns the service events of a node. Implements processor.NodeEvents.
+func (e serviceEvents) premisEvents(node *models.TreeNode) []premis.Event {
+	events := e.fixity.premisEvents(node)
+	if node.UUID != e.rootUUID {
+		return events
+	}
+
+	packageEvents := []premis.Event{
+		newServiceEvent("transfer", e.transferred, "Transfer from Cells", "success", "Downloaded from "+e.workspacePath),
+	}
+	// Single file packages record their own fixity check
+	if len(events) == 0 {
+		packageEvents = append(packageEvents, newServiceEvent("fixity check", e.fixity.date,
+			"Fixity check of the package transferred from Cells", "pass", fmt.Sprintf("%d file(s) verified against Cells", e.fixity.verified)))
+	}
+	packageEvents = append(packageEvents, events...)
+	// The record is written before the transfer is submitted, so it records the attempt without an outcome.
+	// A3M records the outcome of the ingestion in the AIP with its own events.
+	return append(packageEvents, newServiceEvent("ingestion start", time.Now(), "Submission to A3M", "", "Submitting to A3M at "+e.a3mAddress))
+}
+
+// newServiceEvent creates a service event, without links. The outcome is left out if empty.
+func newServiceEvent(eventType string, date time.Time, detail, outcome, note string) premis.Event {
+	return premis.Event{
+		EventIdentifier: premis.EventIdentifier{
+			IdentifierType:  "UUID",
+			IdentifierValue: uuid.New().String(),
+		},
+		EventType:     eventType,
+		EventDateTime: date.UTC().Format(time.RFC3339),
+		EventDetailInformation: []premis.EventDetailInformation{{
+			EventDetail: detail,
+		}},
+		EventOutcomeInformation: []premis.EventOutcomeInformation{{
+			EventOutcome: outcome,
+			EventOutcomeDetails: []premis.EventOutcomeDetail{{
+				EventOutcomeDetailNote: note,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,66 @@
+package preservation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// contentFingerprint hashes the relative paths, sizes and Cells hashes of the file nodes of a package.
+// Node hashes are combined in any order, so the package is fingerprinted while it is listed a page at a time.
+type contentFingerprint struct {
+	rootPath string
+	count    int
+	sum      [sha256.Size]byte
+}
+
+func newContentFingerprint(rootPath string) *contentFingerprint {
+	return &contentFingerprint{rootPath: strings.Trim(rootPath, "/")}
+}
+
+// add adds a file node to the fingerprint. Relative paths are unique, so no node cancels another out.
+func (f *contentFingerprint) add(node *models.TreeNode) {
+	relPath := strings.TrimPrefix(strings.Trim(node.Path, "/"), f.rootPath)
+	h := sha256.Sum256([]byte(relPath + "\x00" + node.Size + "\x00" + strings.Trim(node.Etag, `"`)))
+	for i := range f.sum {
+		f.sum[i] ^= h[i]
+	}
+	f.count++
+}
+
+// String returns the fingerprint as recorded in the results, e.g. 42:<hex>.
+func (f *contentFingerprint) String() string {
+	return fmt.Sprintf("%d:%s", f.count, hex.EncodeToString(f.sum[:]))
+}
+
+// processingFingerprint appends the hash of the resolved processing configuration to a content fingerprint, e.g. 42:<hex>:<hex>,
+// so content preserved with other settings is never unchanged. The profile name is left out, profiles with the same settings match.
+func processingFingerprint(content string, pcfg *config.PreservationConfig) (string, error) {
+	settings := *pcfg
+	settings.Profile = ""
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("error encoding processing configuration: %w", err)
+	}
+	h := sha256.Sum256(data)
+	return content + ":" + hex.EncodeToString(h[:8]), nil
+}
+
+// unchangedSince reports whether a package was last preserved with the same content and processing fingerprint, and when.
+// Packages are never unchanged if the fingerprint namespace is disabled.
+func (p *Preserver) unchangedSince(node *models.TreeNode, fingerprint string) (string, bool) {
+	ns := p.envConfig.Results
+	if ns.ContentFingerprintNamespace == "" || metaString(node.MetaStore[ns.ContentFingerprintNamespace]) != fingerprint {
+		return "", false
+	}
+	since := metaString(node.MetaStore[ns.DateNamespace])
+	if since == "" {
+		since = "its last preservation"
+	}
+	return since, true
+}
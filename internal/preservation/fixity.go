@@ -0,0 +1,192 @@
+package preservation
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/premis"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// maxFixityFailuresReported bounds the mismatches listed in the job error. Every mismatch is logged.
+const maxFixityFailuresReported = 50
+
+// fixityChecks is the successful verification of the downloaded files of a package.
+// The check of each file is derived from its node when its PREMIS object is written, so checks aren't kept per file.
+type fixityChecks struct {
+	date     time.Time // When the package was verified
+	verified int       // Number of files verified
+}
+
+// fixityFailures collects the files failing verification. Every failure is logged, the first ones are reported in the job error.
+type fixityFailures struct {
+	count    int
+	reported []string
+}
+
+func (f *fixityFailures) add(nodePath string, err error) {
+	f.count++
+	logger.Error("Fixity check failed for %s: %v", nodePath, err)
+	if len(f.reported) < maxFixityFailuresReported {
+		f.reported = append(f.reported, fmt.Sprintf("%s: %v", nodePath, err))
+	}
+}
+
+// err returns the report of the failures, or nil if every file passed.
+func (f *fixityFailures) err(verified int) error {
+	if f.count == 0 {
+		return nil
+	}
+	report := strings.Join(f.reported, "\n")
+	if f.count > len(f.reported) {
+		report += fmt.Sprintf("\n... and %d more", f.count-len(f.reported))
+	}
+	return fmt.Errorf("fixity check failed for %d of %d files:\n%s", f.count, f.count+verified, report)
+}
+
+// verifyDownload verifies each downloaded file against its Cells node, before preprocessing.
+// The package is listed a page at a time. Every file is verified, and the job fails with a report of all mismatches.
+func (p *Preserver) verifyDownload(ctx context.Context, nodeUUID, downloadedPath string) (fixityChecks, error) {
+	root, err := p.cellsClient.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return fixityChecks{}, fmt.Errorf("error getting node %s: %w", nodeUUID, err)
+	}
+	rootPath := strings.Trim(root.Path, "/")
+
+	var checks fixityChecks
+	var failures fixityFailures
+	verify := func(node *models.TreeNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !cells.IsContentNode(node) {
+			return nil
+		}
+		relPath := strings.TrimPrefix(strings.Trim(node.Path, "/"), rootPath)
+		localPath := filepath.Join(downloadedPath, filepath.FromSlash(relPath))
+		check, err := cells.VerifyNodeContent(node, localPath)
+		if err != nil {
+			failures.add(node.Path, err)
+			return nil
+		}
+		logger.Debug("Fixity check passed for %s: %s %s", node.Path, check.Method, check.Value)
+		checks.verified++
+		return nil
+	}
+
+	info, err := os.Stat(downloadedPath)
+	if err != nil {
+		return fixityChecks{}, fmt.Errorf("error checking downloaded package: %w", err)
+	}
+	if info.IsDir() {
+		parent, err := p.cellsClient.WalkNodeCollection(ctx, rootPath, verify)
+		if err != nil {
+			return fixityChecks{}, fmt.Errorf("error walking node collection: %w", err)
+		}
+		// The node may have moved while listing it
+		if parent.UUID != nodeUUID {
+			return fixityChecks{}, fmt.Errorf("node %s moved while listing it: %s is now %s", nodeUUID, rootPath, parent.UUID)
+		}
+	} else if err := verify(root); err != nil {
+		return fixityChecks{}, err
+	}
+
+	if err := failures.err(checks.verified); err != nil {
+		return fixityChecks{}, err
+	}
+	logger.Info("Fixity check passed for %d files", checks.verified)
+	checks.date = time.Now()
+	return checks, nil
+}
+
+// verifyUpload verifies each file of the uploaded AIP against the local AIP: its size, and its ETag against the MD5 of the local file.
+// The AIP is listed a page at a time. Every file is verified, and the job fails with a report of all mismatches,
+// so the source is never disposed of for an incomplete or corrupt AIP.
+func (p *Preserver) verifyUpload(ctx context.Context, aipNode *models.TreeNode, aipPath string) error {
+	info, err := os.Stat(aipPath)
+	if err != nil {
+		return fmt.Errorf("error checking AIP: %w", err)
+	}
+	if !info.IsDir() {
+		if err := p.cellsClient.VerifyUploadedNode(aipNode, aipPath); err != nil {
+			return fmt.Errorf("fixity check failed for %s: %w", aipNode.Path, err)
+		}
+		logger.Info("Fixity check passed for uploaded AIP %s", aipNode.Path)
+		return nil
+	}
+
+	// Count the local files, to detect files missing from the upload
+	localFiles := 0
+	err = filepath.WalkDir(aipPath, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			localFiles++
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing AIP: %w", err)
+	}
+
+	rootPath := strings.Trim(aipNode.Path, "/")
+	verified := 0
+	var failures fixityFailures
+	verify := func(node *models.TreeNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !cells.IsContentNode(node) {
+			return nil
+		}
+		relPath := strings.TrimPrefix(strings.Trim(node.Path, "/"), rootPath)
+		if err := p.cellsClient.VerifyUploadedNode(node, filepath.Join(aipPath, filepath.FromSlash(relPath))); err != nil {
+			failures.add(node.Path, err)
+			return nil
+		}
+		verified++
+		return nil
+	}
+	parent, err := p.cellsClient.WalkNodeCollection(ctx, rootPath, verify)
+	if err != nil {
+		return fmt.Errorf("error walking node collection: %w", err)
+	}
+	// The AIP may have moved while listing it
+	if parent.UUID != aipNode.UUID {
+		return fmt.Errorf("AIP node %s moved while listing it: %s is now %s", aipNode.UUID, rootPath, parent.UUID)
+	}
+	if err := failures.err(verified); err != nil {
+		return err
+	}
+	if verified != localFiles {
+		return fmt.Errorf("uploaded AIP %s holds %d of its %d files", aipNode.Path, verified, localFiles)
+	}
+	logger.Info("Fixity check passed for %d files of uploaded AIP %s", verified, aipNode.Path)
+	return nil
+}
+
+// premisEvents returns the fixity check event of a node, recorded in the transfer's PREMIS XML.
+// Nodes modified since the package was verified, e.g. added since, weren't verified so record no check.
+func (c fixityChecks) premisEvents(node *models.TreeNode) []premis.Event {
+	if c.verified == 0 || !cells.IsContentNode(node) {
+		return nil
+	}
+	if mtime, err := strconv.ParseInt(node.MTime, 10, 64); err == nil && time.Unix(mtime, 0).After(c.date) {
+		return nil
+	}
+	check, err := cells.NodeFixity(node)
+	if err != nil {
+		return nil
+	}
+	detail := "Downloaded content verified against the MD5 ETag stored by Cells"
+	if check.Method == cells.FixitySizeMTime {
+		detail = "Downloaded content size verified against Cells, which stores no MD5 ETag for it. The node was not modified since the download"
+	}
+	return []premis.Event{newServiceEvent("fixity check", c.date, detail, "pass", fmt.Sprintf("%s: %s", check.Method, check.Value))}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/penwern/curate-preservation-core/internal/a3mclient"
 	"github.com/penwern/curate-preservation-core/internal/atom"
 	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/internal/disposition"
 	"github.com/penwern/curate-preservation-core/internal/processor"
 	"github.com/penwern/curate-preservation-core/pkg/config"
 	"github.com/penwern/curate-preservation-core/pkg/logger"
@@ -22,48 +23,55 @@ import (
 	"github.com/pydio/cells-sdk-go/v4/models"
 )
 
-var (
-	preservationTagNamespace     = "usermeta-preservation-status"
-	dipTagNamespace              = "usermeta-dip-status"
-	atomSlugTagNamespace         = "usermeta-atom-slug"
-	preservationTagStarting      = "🟢 Starting..."
-	preservationTagDownloading   = "🌐 Downloading..."
-	preservationTagPreprocessing = "🗂️ Preprocessing..."
-	preservationTagPackaging     = "📦 Packaging..."
-	preservationTagExtracting    = "🗃️ Extracting..."
-	preservationTagCompressing   = "🗃️ Compressing..."
-	preservationTagWaiting       = "⏳ Waiting..."
-	preservationTagUploading     = "🌐 Uploading..."
-	preservationTagCompleted     = "🔒 Preserved"
-	preservationTagFailed        = "❌ Failed"
-	preservationTagDipFailed     = "❌ DIP Failed"
-	dipTagWaiting                = "⏳ Waiting..."
-	dipTagStarting               = preservationTagStarting
-	dipTagMigrating              = "📨 Migrating..."
-	dipTagDepositing             = "🌐 Depositing..."
-	dipTagCompleted              = "🖼️ Deposited"
-	dipTagFailed                 = preservationTagFailed
-)
+// transferStateDir is the directory, within the processing base directory, persisting partial Cells transfers across jobs and restarts.
+const transferStateDir = ".transfers"
+
+// Target identifies a Cells node to preserve.
+// Jobs are keyed by node UUID, and the node's current path is looked up from it at each stage,
+// so a job survives the node being renamed or moved before or while it is processed.
+// Nodes given by path, e.g. from the CLI, are looked up once to find their UUID.
+type Target struct {
+	UUID         string // Node UUID. Takes precedence over the path
+	Path         string // Cells path, used when the UUID is unknown
+	PathResolved bool   // The path is an absolute datasource path (personal/user/file) rather than a workspace path (personal-files/file)
+}
+
+// String returns the target for logging.
+func (t Target) String() string {
+	if t.UUID != "" {
+		if t.Path != "" {
+			return fmt.Sprintf("%s (%s)", t.Path, t.UUID)
+		}
+		return t.UUID
+	}
+	return t.Path
+}
 
-// TagUpdaters holds functions to update various tag namespaces
-type TagUpdaters struct {
-	Preservation func(context.Context, string) error
-	Dip          func(context.Context, string) error
-	AtomSlug     func(context.Context, string) error
+// RunOptions holds the per-request options of a preservation job.
+type RunOptions struct {
+	CleanUp           bool   // Remove the processing directory and A3M artefacts once the job ends
+	StatusVocabulary  string // Status labels of the job, e.g. a language. The configured vocabulary if empty
+	ArchiveDir        string // Archive directory template. The configured directory if empty
+	Force             bool   // Preserve content unchanged since its last preservation
+	DispositionAction string // Action on the source once preserved: leave, move or delete. The configured action if empty
 }
 
 // Preserver is the service for the preservation process
 type Preserver struct {
-	a3mClient   a3mclient.ClientInterface
-	cellsClient cells.ClientInterface
-	envConfig   *config.Config
+	a3mClient        a3mclient.ClientInterface
+	cellsClient      cells.ClientInterface
+	envConfig        *config.Config
+	profiles         config.ProcessingProfiles
+	vocabularies     config.StatusVocabularies
+	dispositionQueue *disposition.Queue
+	dispositionAudit *disposition.AuditLog
 }
 
 // NewPreserver creates a new preservation service.
 // Initializes the Cells and A3M clients.
 // Panics if the clients cannot be created.
 func NewPreserver(ctx context.Context, cfg *config.Config) *Preserver {
-	a3mClient, err := a3mclient.NewClient(cfg.A3M.Address)
+	a3mClient, err := a3mclient.NewClientFromConfig(cfg, a3mclient.ClientOptions{})
 	if err != nil {
 		panic(fmt.Errorf("a3m client error: %w", err))
 	}
@@ -72,17 +80,42 @@ func NewPreserver(ctx context.Context, cfg *config.Config) *Preserver {
 
 // NewPreserverWithA3MClient creates a new preservation service with an A3M client.
 func NewPreserverWithA3MClient(ctx context.Context, cfg *config.Config, a3mClient a3mclient.ClientInterface) *Preserver {
-	cellsClient, err := cells.NewClient(ctx, cfg.Cells.CecPath, cfg.Cells.Address, cfg.Cells.AdminToken, cfg.AllowInsecureTLS)
+	cellsClient, err := NewCellsClient(ctx, cfg)
 	if err != nil {
 		logger.Fatal("cells client error: %v", err)
 	}
+	profiles, err := config.LoadProcessingProfiles(cfg.Preservation.ProfilesPath)
+	if err != nil {
+		logger.Fatal("processing profiles error: %v", err)
+	}
+	vocabularies, err := config.LoadStatusVocabularies(cfg.Status.VocabulariesPath)
+	if err != nil {
+		logger.Fatal("status vocabularies error: %v", err)
+	}
+	if _, err := vocabularies.Get(cfg.Status.Vocabulary); err != nil {
+		logger.Fatal("status vocabularies error: %v", err)
+	}
 	return &Preserver{
-		a3mClient:   a3mClient,
-		cellsClient: cellsClient,
-		envConfig:   cfg,
+		a3mClient:        a3mClient,
+		cellsClient:      cellsClient,
+		envConfig:        cfg,
+		profiles:         profiles,
+		vocabularies:     vocabularies,
+		dispositionQueue: disposition.NewQueue(disposition.QueuePath(DispositionDir(cfg.ProcessingBaseDir))),
+		dispositionAudit: disposition.NewAuditLog(disposition.AuditPath(DispositionDir(cfg.ProcessingBaseDir))),
 	}
 }
 
+// NewCellsClient creates a Cells client from the environment configuration.
+func NewCellsClient(ctx context.Context, cfg *config.Config) (*cells.Client, error) {
+	return cells.NewClient(ctx, cfg.Cells.CecPath, cfg.Cells.Address, cfg.Cells.AdminToken, cfg.AllowInsecureTLS, cells.TransferOptions{
+		Backend:     cfg.Cells.TransferBackend,
+		PartSizeMB:  cfg.Cells.TransferPartSizeMB,
+		Concurrency: cfg.Cells.TransferConcurrency,
+		StateDir:    filepath.Join(cfg.ProcessingBaseDir, transferStateDir),
+	}, cfg.Cells.UserTokenTTL, cfg.Cells.WorkspaceRefreshInterval, cfg.Cells.ListPageSize)
+}
+
 // Close closes the preservation service clients.
 func (p *Preserver) Close() {
 	logger.Debug("Closing Clients")
@@ -90,41 +123,43 @@ func (p *Preserver) Close() {
 	p.a3mClient.Close()
 }
 
+// A3MHealth returns the health of the A3M connection.
+func (p *Preserver) A3MHealth() a3mclient.HealthStatus {
+	return p.a3mClient.Health()
+}
+
 // Run runs the preservation process.
 // Ignoring gocyclo error for now, this function is complex and I cba to break it down yet TODO: refactor
 //
 //nolint:gocyclo
-func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, atomConfig *config.AtomConfig, userClient cells.UserClient, cellsPackagePath string, cleanUp, pathResolved bool) error {
+func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, atomConfig *config.AtomConfig, userClient cells.UserClient, target Target, opts RunOptions) error {
 	// Add panic recovery to prevent crashes
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Error("Panic recovered in preservation Run method for path '%s': %v", cellsPackagePath, r)
+			logger.Error("Panic recovered in preservation Run method for node '%s': %v", target, r)
 		}
 	}()
 
 	var (
-		err            error
-		nodeCollection *models.RestNodesCollection
-		tagUpdaters    *TagUpdaters
-		producingDip   bool // If the atom slug is set, we will produce a DIP
+		err          error
+		nodeUUID     string
+		node         *models.TreeNode
+		tagUpdaters  *TagUpdaters
+		producingDip bool // If the atom slug is set, we will produce a DIP
 	)
 
 	///////////////////////////////////////////////////////////////////
 	//						Pre-requisites							 //
 	///////////////////////////////////////////////////////////////////
 
-	// Unresolve resolved paths
-	// TODO: We imeditately resolve it again in gatherNodeEnvironment...
-	if pathResolved {
-		cellsPackagePath, err = p.cellsClient.UnresolveCellsPath(userClient, cellsPackagePath)
-		if err != nil {
-			return fmt.Errorf("error unresolving cells path: %w", err)
-		}
-		logger.Info("Unresolved Cells Path: %s", cellsPackagePath)
+	// Key the job by node UUID
+	nodeUUID, err = p.targetNodeUUID(ctx, userClient, target)
+	if err != nil {
+		return fmt.Errorf("error finding node: %w", err)
 	}
 
 	// Gather the node environment
-	nodeCollection, tagUpdaters, err = p.gatherNodeEnvironment(ctx, userClient, cellsPackagePath)
+	node, tagUpdaters, err = p.gatherNodeEnvironment(ctx, userClient, nodeUUID, opts.StatusVocabulary)
 	if err != nil {
 		return fmt.Errorf("error gathering node environment: %w", err)
 	}
@@ -135,43 +170,106 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		if err != nil {
 			if !processingDip {
 				// Update the preservation tag on failure
-				errMsg := fmt.Sprintf("%s: %s", preservationTagFailed, utils.TruncateError(err.Error(), 100))
-				if updateErr := tagUpdaters.Preservation(ctx, errMsg); updateErr != nil {
+				if updateErr := tagUpdaters.Preservation(ctx, config.StatusFailed, utils.TruncateError(err.Error(), 100)); updateErr != nil {
 					logger.Error("error updating Preservation tag on failure: %v", updateErr)
 				}
 			} else {
 				// Update the atom tag on failure
-				dipErrMsg := fmt.Sprintf("%s: %s", dipTagFailed, utils.TruncateError(err.Error(), 100))
-				if updateErr := tagUpdaters.Dip(ctx, dipErrMsg); updateErr != nil {
+				if updateErr := tagUpdaters.Dip(ctx, config.StatusFailed, utils.TruncateError(err.Error(), 100)); updateErr != nil {
 					logger.Error("error updating AtoM tag on failure: %v", updateErr)
 				}
-				if updateErr := tagUpdaters.Preservation(ctx, preservationTagDipFailed); updateErr != nil {
+				if updateErr := tagUpdaters.Preservation(ctx, config.StatusDipFailed, ""); updateErr != nil {
 					logger.Error("error updating Preservation tag on failure: %v", updateErr)
 				}
 			}
 		}
 	}()
 
-	// CLI Atom Slug overrides the atom slug from the node collection
-	atomSlug := nodeCollection.Parent.MetaStore[atomSlugTagNamespace]
+	// CLI Atom Slug overrides the atom slug from the node metadata
+	atomSlug := node.MetaStore[p.envConfig.Atom.SlugNamespace]
 	trimmedAtomSlug := strings.Trim(atomSlug, `"\ `) // Trim quotes and spaces
 	if trimmedAtomSlug != "" {
 		atomConfig.Slug = trimmedAtomSlug
 		logger.Info("Atom Slug Found: %s", atomConfig.Slug)
 	}
 
+	// Node metadata processing profile overrides the request configuration
+	pcfg, err = p.resolveProcessingConfig(node, pcfg)
+	if err != nil {
+		return fmt.Errorf("error resolving processing profile: %w", err)
+	}
+
+	// Lock the source and snapshot it, to detect changes made while it is preserved
+	unlockSource := p.lockSource(ctx, userClient, nodeUUID)
+	defer unlockSource()
+	var snapshot sourceSnapshot
+	var fingerprint string
+	snapshot, fingerprint, err = p.snapshotSource(ctx, nodeUUID, node.Path)
+	if err != nil {
+		return fmt.Errorf("error snapshotting source: %w", err)
+	}
+	// Content preserved with another processing configuration isn't unchanged
+	fingerprint, err = processingFingerprint(fingerprint, pcfg)
+	if err != nil {
+		return err
+	}
+
+	// Skip content unchanged since its last successful preservation, unless forced
+	if since, unchanged := p.unchangedSince(node, fingerprint); unchanged {
+		if !opts.Force {
+			logger.Info("Skipping %s, unchanged since %s", target, since)
+			// Tag Package: Preserved
+			if err = tagUpdaters.Preservation(ctx, config.StatusPreserved, "unchanged since "+since); err != nil {
+				return fmt.Errorf("error updating Preservation tag: %w", err)
+			}
+			return nil
+		}
+		logger.Info("Preserving %s again, unchanged since %s but forced", target, since)
+	}
+
+	// Apply the skip and fail collision policies before the package is processed
+	if policy := p.envConfig.Cells.CollisionPolicy; policy == CollisionSkip || policy == CollisionFail {
+		var sourcePath, destination string
+		var archivedAIPs []*models.TreeNode
+		sourcePath, err = p.workspacePath(ctx, userClient, nodeUUID)
+		if err != nil {
+			return fmt.Errorf("error finding package: %w", err)
+		}
+		destination, archivedAIPs, err = p.collidingAIPs(ctx, userClient, opts.ArchiveDir, sourcePath, node)
+		if err != nil {
+			return fmt.Errorf("error listing archived AIPs: %w", err)
+		}
+		if len(archivedAIPs) > 0 {
+			if policy == CollisionFail {
+				err = fmt.Errorf("AIP already archived in %s: %s", destination, aipNames(archivedAIPs))
+				return err
+			}
+			logger.Info("AIP already archived in %s, skipping %s: %s", destination, target, aipNames(archivedAIPs))
+			// Point the source to its archived AIP
+			results := p.archivedResults(destination, archivedAIPs, nodeUUID, node.Path)
+			if resultsErr := p.writeResults(ctx, userClient, "", results); resultsErr != nil {
+				logger.Error("Error writing preservation results: %v", resultsErr)
+			}
+			// Tag Package: Preserved
+			if err = tagUpdaters.Preservation(ctx, config.StatusPreserved, "already archived: "+aipNames(archivedAIPs)); err != nil {
+				return fmt.Errorf("error updating Preservation tag: %w", err)
+			}
+			return nil
+		}
+	}
+
 	///////////////////////////////////////////////////////////////////
 	//						Start Processing						 //
 	///////////////////////////////////////////////////////////////////
 
 	// Tag Package: Starting
-	if err = tagUpdaters.Preservation(ctx, preservationTagStarting); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusStarting, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
 
 	// If the atom slug is set, update the DIP tag to "Waiting..."
 	if atomConfig.Slug != "" {
-		if err = tagUpdaters.Dip(ctx, dipTagWaiting); err != nil {
+		if err = tagUpdaters.Dip(ctx, config.StatusWaiting, ""); err != nil {
 			return fmt.Errorf("error updating AtoM tag: %w", err)
 		}
 
@@ -183,7 +281,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		processingDip = false
 	} else {
 		// If the atom slug is not set, clear the DIP tag
-		if err = tagUpdaters.Dip(ctx, ""); err != nil {
+		if err = tagUpdaters.Dip(ctx, "", ""); err != nil {
 			return fmt.Errorf("error updating AtoM tag: %w", err)
 		}
 	}
@@ -197,7 +295,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	logger.Info("Created processing dir: %s", processingDir)
 	// Clean up the processing directory
 	defer func() {
-		if cleanUp && processingDir != "" {
+		if opts.CleanUp && processingDir != "" {
 			logger.Info("Cleaning up.")
 			if removeErr := os.RemoveAll(processingDir); removeErr != nil {
 				logger.Error("Error deleting processing directory: %v", removeErr)
@@ -211,22 +309,35 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	///////////////////////////////////////////////////////////////////
 
 	// Tag Package: Downloading
-	if err = tagUpdaters.Preservation(ctx, preservationTagDownloading); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusDownloading, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
+	// Look up the current path, in case the node moved since the job started
+	var cellsPackagePath string
+	cellsPackagePath, err = p.workspacePath(ctx, userClient, nodeUUID)
+	if err != nil {
+		return fmt.Errorf("error finding package: %w", err)
+	}
 	logger.Info("Downloading package: %s", cellsPackagePath)
 	var downloadedPath string
-	downloadedPath, err = p.downloadPackage(ctx, userClient, processingDir, cellsPackagePath)
+	downloadedPath, err = p.downloadPackage(ctx, userClient, nodeUUID, processingDir, cellsPackagePath)
 	if err != nil {
 		return fmt.Errorf("error downloading package: %v", err)
 	}
+	events := serviceEvents{rootUUID: nodeUUID, workspacePath: cellsPackagePath, transferred: time.Now(), a3mAddress: p.envConfig.A3M.Address}
+
+	// Verify the downloaded content against Cells
+	events.fixity, err = p.verifyDownload(ctx, nodeUUID, downloadedPath)
+	if err != nil {
+		return fmt.Errorf("error verifying download: %w", err)
+	}
 
 	///////////////////////////////////////////////////////////////////
 	//						 Preprocessing							 //
 	///////////////////////////////////////////////////////////////////
 
 	// Tag Package: Preprocessing
-	if err = tagUpdaters.Preservation(ctx, preservationTagPreprocessing); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusPreprocessing, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
 	// Preprocess package. Don't use retry as we move/extract the package in the first step
@@ -237,8 +348,14 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		return fmt.Errorf("user data is nil for user client")
 	}
 
+	// Version the package after the AIPs it was preserved to before
+	packageVersion := p.packageVersion(node)
+	if packageVersion.PreviousAipUUID != "" {
+		logger.Info("Preserving version %d of %s, following AIP %s", packageVersion.Version, cellsPackagePath, packageVersion.PreviousAipUUID)
+	}
+
 	var transferPath string
-	transferPath, err = p.preprocessPackage(ctx, processingDir, downloadedPath, nodeCollection, userClient.UserData)
+	transferPath, err = p.preprocessPackage(ctx, processingDir, downloadedPath, cellsPackagePath, nodeUUID, events, packageVersion, userClient.UserData)
 	if err != nil {
 		return fmt.Errorf("error preprocessing package: %w", err)
 	}
@@ -248,7 +365,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	///////////////////////////////////////////////////////////////////
 
 	// Tag Package: Preserving
-	if err = tagUpdaters.Preservation(ctx, preservationTagPackaging); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusPackaging, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
 
@@ -269,7 +386,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	a3mFinishTime := time.Since(a3mStartTime).Seconds()
 	defer func() {
 		// Clean up the A3M AIP
-		if cleanUp && a3mAipPath != "" {
+		if opts.CleanUp && a3mAipPath != "" {
 			if removeErr := os.RemoveAll(a3mAipPath); removeErr != nil {
 				logger.Error("Error deleting A3M AIP: %v", removeErr)
 			} else {
@@ -285,7 +402,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	///////////////////////////////////////////////////////////////////
 
 	// Tag Package: Extracting
-	if err = tagUpdaters.Preservation(ctx, preservationTagExtracting); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusExtracting, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
 	// Create AIP Directory
@@ -303,7 +420,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	logger.Info("Postprocessed AIP: %s", utils.RelPath(p.envConfig.ProcessingBaseDir, aipPath))
 	if pcfg.CompressAip {
 		// Tag Package: Compressing
-		if err = tagUpdaters.Preservation(ctx, preservationTagCompressing); err != nil {
+		if err = tagUpdaters.Preservation(ctx, config.StatusCompressing, ""); err != nil {
 			return fmt.Errorf("error updating Preservation tag: %w", err)
 		}
 		// Compress AIP
@@ -325,12 +442,12 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		processingDip = true
 
 		// Tag Package: Starting DIP Processing
-		if err = tagUpdaters.Dip(ctx, dipTagStarting); err != nil {
+		if err = tagUpdaters.Dip(ctx, config.StatusStarting, ""); err != nil {
 			return fmt.Errorf("error updating AtoM tag: %w", err)
 		}
 
 		// Tag Package: Waiting
-		if err = tagUpdaters.Preservation(ctx, preservationTagWaiting); err != nil {
+		if err = tagUpdaters.Preservation(ctx, config.StatusWaiting, ""); err != nil {
 			return fmt.Errorf("error updating Preservation tag: %w", err)
 		}
 
@@ -351,7 +468,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		}
 		defer func() {
 			// Clean up the A3M AIP
-			if cleanUp && a3mDipPath != "" {
+			if opts.CleanUp && a3mDipPath != "" {
 				if removeErr := os.RemoveAll(a3mDipPath); removeErr != nil {
 					logger.Error("Error deleting A3M DIP: %v", removeErr)
 				} else {
@@ -364,7 +481,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		logger.Info("Migrating DIP: %s", utils.RelPath(p.envConfig.ProcessingBaseDir, a3mDipPath))
 
 		// Tag Package: Migrating to AtoM Server
-		if err = tagUpdaters.Dip(ctx, dipTagMigrating); err != nil {
+		if err = tagUpdaters.Dip(ctx, config.StatusMigrating, ""); err != nil {
 			return fmt.Errorf("error updating AtoM tag: %w", err)
 		}
 
@@ -374,7 +491,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		}
 
 		// Tag Package: Depositing
-		if err = tagUpdaters.Dip(ctx, dipTagDepositing); err != nil {
+		if err = tagUpdaters.Dip(ctx, config.StatusDepositing, ""); err != nil {
 			return fmt.Errorf("error updating AtoM tag: %w", err)
 		}
 
@@ -384,7 +501,7 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 		}
 
 		// Tag Package: Preserved
-		if err = tagUpdaters.Preservation(ctx, dipTagCompleted); err != nil {
+		if err = tagUpdaters.Preservation(ctx, config.StatusDeposited, ""); err != nil {
 			return fmt.Errorf("error updating Preservation tag: %w", err)
 		}
 
@@ -396,112 +513,245 @@ func (p *Preserver) Run(ctx context.Context, pcfg *config.PreservationConfig, at
 	///////////////////////////////////////////////////////////////////
 
 	// Tag Package: Uploading
-	if err = tagUpdaters.Preservation(ctx, preservationTagUploading); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusUploading, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
+	// Measure the AIP before it is uploaded, to record it in the results
+	var aipSize int64
+	var aipChecksum string
+	aipSize, aipChecksum, err = aipSizeAndChecksum(aipPath)
+	if err != nil {
+		return fmt.Errorf("error measuring AIP: %w", err)
+	}
+
+	// Render the archive destination and create its missing folders
+	var archiveDestination string
+	archiveDestination, err = p.archiveDestination(ctx, userClient, opts.ArchiveDir, cellsPackagePath, aipUUID)
+	if err != nil {
+		return fmt.Errorf("error preparing archive destination: %w", err)
+	}
+
+	// List the AIPs of the package replaced by the new one. Skip and fail apply before processing
+	var archivedAIPs []*models.TreeNode
+	if p.envConfig.Cells.CollisionPolicy == CollisionReplace {
+		archivedAIPs, err = p.archivedAIPs(ctx, userClient, archiveDestination, node)
+		if err != nil {
+			return fmt.Errorf("error listing archived AIPs: %w", err)
+		}
+		if len(archivedAIPs) > 0 {
+			logger.Info("Archiving %s to replace: %s", transferName, aipNames(archivedAIPs))
+		}
+	}
+
 	// Upload Node
 	logger.Info("Uploading AIP: %s", utils.RelPath(p.envConfig.ProcessingBaseDir, aipPath))
-	var cellsUploadPath string
-	cellsUploadPath, err = p.uploadPackage(ctx, userClient, aipPath)
-	if err != nil {
+	if _, err = p.uploadPackage(ctx, userClient, nodeUUID, aipPath, archiveDestination); err != nil {
 		return fmt.Errorf("error uploading AIP: %w", err)
 	}
-	logger.Info("Uploaded AIP %s", cellsUploadPath)
 
-	// Verify the AIP is located in the upload destination
-	var resolvedUploadPath string
-	resolvedUploadPath, err = p.cellsClient.ResolveCellsPath(userClient, cellsUploadPath)
+	// Read the AIP back from the upload destination, Cells may have renamed it
+	var cellsUploadPath string
+	var aipNode *models.TreeNode
+	cellsUploadPath, aipNode, err = p.uploadedAIP(ctx, userClient, archiveDestination, filepath.Base(aipPath), aipSize)
 	if err != nil {
-		return fmt.Errorf("error resolving upload path: %w", err)
+		return fmt.Errorf("error verifying uploaded AIP: %w", err)
 	}
-	_, err = p.getNodeStats(ctx, resolvedUploadPath)
-	if err != nil {
-		return fmt.Errorf("error getting node stats: %w", err)
+	// Verify every file of the AIP, before the replaced AIPs are deleted and the source is disposed of
+	if err = p.verifyUpload(ctx, aipNode, aipPath); err != nil {
+		return fmt.Errorf("error verifying uploaded AIP: %w", err)
+	}
+	logger.Info("Uploaded AIP %s", cellsUploadPath)
+	if p.envConfig.Cells.CollisionPolicy == CollisionReplace && len(archivedAIPs) > 0 {
+		p.replaceArchivedAIPs(ctx, userClient, archiveDestination, archivedAIPs)
+	}
+
+	// Compare the source with its snapshot. The AIP is already in the archive, so failing to compare doesn't fail the job
+	drift, driftErr := p.sourceDrift(ctx, nodeUUID, snapshot)
+	if driftErr != nil {
+		logger.Error("Error checking source changes: %v", driftErr)
+	}
+
+	// Write the results to the source and AIP nodes.
+	// The AIP is already in the archive, so failing to write them doesn't fail the job.
+	results := Results{
+		AipUUID:         aipUUID,
+		AipLocation:     cellsUploadPath,
+		Version:         packageVersion.Version,
+		PreviousAipUUID: packageVersion.PreviousAipUUID,
+		Date:            time.Now(),
+		AipSize:         aipSize,
+		AipChecksum:     aipChecksum,
+		Profile:         pcfg.Profile,
+		SourceUUID:      nodeUUID,
+		SourcePath:      node.Path,
+	}
+	if producingDip {
+		results.AtomSlug = atomConfig.Slug
+		results.DipURL = dipURL(atomConfig.Host, atomConfig.Slug)
+	}
+	// Only record the fingerprint of content known to be preserved as it was
+	if driftErr == nil && !drift.drifted() {
+		results.ContentFingerprint = fingerprint
+	}
+	if resultsErr := p.writeResults(ctx, userClient, aipNode.UUID, results); resultsErr != nil {
+		logger.Error("Error writing preservation results: %v", resultsErr)
+	}
+
+	if drift.drifted() {
+		logger.Warn("Source changed during preservation: %s. %s: %s", drift, target, strings.Join(drift.paths, ", "))
+		// Tag Package: Preserved (source changed)
+		if err = tagUpdaters.Preservation(ctx, config.StatusPreservedSourceChanged, drift.String()); err != nil {
+			return fmt.Errorf("error updating Preservation tag: %w", err)
+		}
+		logger.Info("Preservation successful, source changed: %s", filepath.Base(aipPath))
+		if p.envConfig.Source.ChangeAction == SourceChangeRequeue {
+			// Not assigned to err, so the node keeps its tag
+			return fmt.Errorf("%w: %s", ErrSourceChanged, drift)
+		}
+		return nil
 	}
-	logger.Info("Verified AIP in Cells: %s", resolvedUploadPath)
-
-	// TODO: Tag the uploaded AIP with the atom slug
-	// if producingDip {
-	// 	// Tag Atom-Slug with Atom Slug
-	// 	if err = tagUpdaters.AtomSlug(ctx, atomConfig.Slug); err != nil {
-	// 		return fmt.Errorf("error updating Atom Slug tag: %w", err)
-	// 	}
-	// }
 
 	// Tag Package: Preserved
-	if err = tagUpdaters.Preservation(ctx, preservationTagCompleted); err != nil {
+	if err = tagUpdaters.Preservation(ctx, config.StatusPreserved, ""); err != nil {
 		return fmt.Errorf("error updating Preservation tag: %w", err)
 	}
 
+	// Apply the disposition to the source, only once its AIP is verified and matches it
+	if driftErr != nil {
+		logger.Warn("Skipping disposition of %s, its changes couldn't be checked", target)
+	} else {
+		unlockSource()
+		p.dispose(ctx, userClient, opts.DispositionAction, nodeUUID, results)
+	}
+
 	// Stops preservation tag from being updated on failure after this point
 	// preservationComplete = true
-	logger.Info("Preservation successful: %s", filepath.Base(aipPath))
+	logger.Info("Preservation successful: %s (version %d)", filepath.Base(aipPath), packageVersion.Version)
 
 	return nil
 }
 
 // NewUserClient creates a new cells user client.
+// The user client must be closed with CloseUserClient once its jobs are done.
 func (p *Preserver) NewUserClient(ctx context.Context, username string) (cells.UserClient, error) {
 	return p.cellsClient.NewUserClient(ctx, username, p.envConfig.AllowInsecureTLS)
 }
 
-// createTagUpdater creates a tag update function for a given namespace
-func (p *Preserver) createTagUpdater(userClient cells.UserClient, parentNodeUUID, namespace string) func(context.Context, string) error {
-	return func(ctx context.Context, status string) error {
-		return utils.Retry(3, 2*time.Second, func() error {
-			logger.Debug("Tagging: {tag: %s, status: %s, node: %s}", namespace, status, parentNodeUUID)
-			return p.cellsClient.UpdateTag(ctx, userClient, parentNodeUUID, namespace, status)
-		}, utils.IsTransientError)
+// RefreshWorkspaces refreshes the cached Cells workspace collection used to resolve template paths.
+func (p *Preserver) RefreshWorkspaces(ctx context.Context) error {
+	return p.cellsClient.RefreshWorkspaces(ctx)
+}
+
+// CloseUserClient closes a cells user client, revoking its tokens.
+func (p *Preserver) CloseUserClient(ctx context.Context, userClient cells.UserClient) error {
+	return p.cellsClient.CloseUserClient(ctx, userClient)
+}
+
+// Resolve the preservation config for a node from its processing profile metadata.
+// Returns a new config, the request config is shared between jobs and never modified.
+func (p *Preserver) resolveProcessingConfig(node *models.TreeNode, pcfg *config.PreservationConfig) (*config.PreservationConfig, error) {
+	requestCfg := config.DefaultPreservationConfig()
+	if pcfg != nil {
+		requestCfg = *pcfg
+	}
+	var nodeProfile *config.NodeProfile
+	if namespace := p.envConfig.Preservation.ProfileNamespace; namespace != "" {
+		var err error
+		nodeProfile, err = config.ParseNodeProfile(node.MetaStore[namespace])
+		if err != nil {
+			return nil, err
+		}
+	}
+	resolved, err := p.profiles.ResolveNodeProfile(requestCfg, nodeProfile)
+	if err != nil {
+		return nil, err
+	}
+	if nodeProfile != nil {
+		logger.Info("Processing profile found: {profile: %s, overrides: %s}", nodeProfile.Profile, string(nodeProfile.Overrides))
 	}
+	return &resolved, nil
 }
 
-// Gather the node environment. Returns the node collection and tag updaters.
-func (p *Preserver) gatherNodeEnvironment(ctx context.Context, userClient cells.UserClient, cellsPackagePath string) (*models.RestNodesCollection, *TagUpdaters, error) {
+// Find the UUID of the node to preserve. Nodes given by path are looked up once, the job then follows the UUID.
+func (p *Preserver) targetNodeUUID(ctx context.Context, userClient cells.UserClient, target Target) (string, error) {
+	if target.UUID != "" {
+		return target.UUID, nil
+	}
+	if target.Path == "" {
+		return "", fmt.Errorf("no node uuid or path provided")
+	}
 	// Get the resolved cells path, parsing cells template path if necessary
-	resolvedCellsPackagePath, err := p.cellsClient.ResolveCellsPath(userClient, cellsPackagePath)
+	resolvedPath := target.Path
+	if !target.PathResolved {
+		var err error
+		resolvedPath, err = p.cellsClient.ResolveCellsPath(userClient, target.Path)
+		if err != nil {
+			return "", fmt.Errorf("error resolving cells path: %w", err)
+		}
+	}
+	nodeStats, err := p.getNodeStats(ctx, resolvedPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error resolving cells path: %w", err)
+		return "", err
 	}
+	if nodeStats.Node.UUID == "" {
+		return "", fmt.Errorf("node has no uuid: %s", resolvedPath)
+	}
+	logger.Debug("Found node: {path: %s, uuid: %s}", resolvedPath, nodeStats.Node.UUID)
+	return nodeStats.Node.UUID, nil
+}
 
-	// Collect the package node data
-	nodeCollection, err := p.cellsClient.GetNodeCollection(ctx, resolvedCellsPackagePath)
+// Look up the current absolute path of a node, e.g. personal/username/file.
+func (p *Preserver) nodePath(ctx context.Context, nodeUUID string) (string, error) {
+	node, err := p.cellsClient.GetNodeByUUID(ctx, nodeUUID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error getting node collection: %w", err)
+		return "", fmt.Errorf("error getting node %s: %w", nodeUUID, err)
 	}
-
-	// Add defensive checks for nil values
-	if nodeCollection == nil {
-		return nil, nil, fmt.Errorf("node collection is nil for path: %s", cellsPackagePath)
+	if node.Path == "" {
+		return "", fmt.Errorf("node has no path: %s", nodeUUID)
 	}
-	if nodeCollection.Parent == nil {
-		return nil, nil, fmt.Errorf("parent node is nil for path: %s", cellsPackagePath)
+	return strings.Trim(node.Path, "/"), nil
+}
+
+// Look up the current workspace path of a node, e.g. personal-files/file. Transfers run as the user, through its workspaces.
+func (p *Preserver) workspacePath(ctx context.Context, userClient cells.UserClient, nodeUUID string) (string, error) {
+	resolvedPath, err := p.nodePath(ctx, nodeUUID)
+	if err != nil {
+		return "", err
 	}
-	if nodeCollection.Parent.MetaStore == nil {
-		nodeCollection.Parent.MetaStore = make(map[string]string)
+	unresolvedPath, err := p.cellsClient.UnresolveCellsPath(userClient, resolvedPath)
+	if err != nil {
+		return "", fmt.Errorf("error unresolving cells path: %w", err)
 	}
+	logger.Debug("Unresolved Cells Path: %s", unresolvedPath)
+	return unresolvedPath, nil
+}
 
-	// Set the parent node uuid
-	parentNodeUUID := nodeCollection.Parent.UUID
-
-	// Check for old tag namespaces
-	if nodeCollection.Parent.MetaStore["usermeta-a3m-progress"] != "" {
-		logger.Warn("Using old usermeta-a3m-progress preservation tag - this will be removed in the future")
-		preservationTagNamespace = "usermeta-a3m-progress"
+// Gather the node environment. Returns the node and tag updaters, which tag the node by UUID.
+// The node's descendants aren't listed here, preprocessing walks them a page at a time.
+func (p *Preserver) gatherNodeEnvironment(ctx context.Context, userClient cells.UserClient, nodeUUID, statusVocabulary string) (*models.TreeNode, *TagUpdaters, error) {
+	vocabulary, err := p.StatusVocabulary(statusVocabulary)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if nodeCollection.Parent.MetaStore["usermeta-dip-progress"] != "" {
-		logger.Warn("Using old usermeta-dip-progress dip tag - this will be removed in the future")
-		dipTagNamespace = "usermeta-dip-progress"
+	// Collect the package node data
+	node, err := p.cellsClient.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting node %s: %w", nodeUUID, err)
+	}
+	logger.Info("Cells Path: %s", node.Path)
+	if node.MetaStore == nil {
+		node.MetaStore = make(map[string]string)
 	}
 
 	// Create tag updaters
+	ns := p.jobStatusNamespaces(node)
 	tagUpdaters := &TagUpdaters{
-		Preservation: p.createTagUpdater(userClient, parentNodeUUID, preservationTagNamespace),
-		Dip:          p.createTagUpdater(userClient, parentNodeUUID, dipTagNamespace),
-		AtomSlug:     p.createTagUpdater(userClient, parentNodeUUID, atomSlugTagNamespace),
+		Preservation: p.createStatusUpdater(userClient, nodeUUID, ns.preservation, ns.preservationCode, vocabulary),
+		Dip:          p.createStatusUpdater(userClient, nodeUUID, ns.dip, ns.dipCode, vocabulary),
 	}
 
-	return nodeCollection, tagUpdaters, nil
+	return node, tagUpdaters, nil
 }
 
 // Get the node stats. Uses the Cells Client.
@@ -521,16 +771,17 @@ func (p *Preserver) getNodeStats(ctx context.Context, cellsPackagePath string) (
 }
 
 // Download package. Uses the Cells Client. Retries on transient errors.
-func (p *Preserver) downloadPackage(ctx context.Context, userClient cells.UserClient, processingDir, packagePath string) (string, error) {
+// Partial downloads are scoped to the node being preserved, so a later job of the same node resumes them.
+func (p *Preserver) downloadPackage(ctx context.Context, userClient cells.UserClient, nodeUUID, processingDir, packagePath string) (string, error) {
 	downloadDir := filepath.Join(processingDir, "cells_download")
 	if err := utils.CreateDir(downloadDir); err != nil {
 		return "", fmt.Errorf("failed to create download directory: %w", err)
 	}
-	// TODO: I don't think retry will work here because the download is executed using CEC binary, so doesn't produce a transient error.
+	// Only the sdk transfer backend surfaces transient errors. CEC failures are reported as command output.
 	var downloadedPath string
 	err := utils.Retry(3, 2*time.Second, func() error {
 		var downloadErr error
-		downloadedPath, downloadErr = p.cellsClient.DownloadNode(ctx, userClient, packagePath, downloadDir)
+		downloadedPath, downloadErr = p.cellsClient.DownloadNode(ctx, userClient, nodeUUID, packagePath, downloadDir)
 		return downloadErr
 	}, utils.IsTransientError)
 	if err != nil {
@@ -540,17 +791,47 @@ func (p *Preserver) downloadPackage(ctx context.Context, userClient cells.UserCl
 }
 
 // Preprocess package. Uses preproces module. Constructs the a3m tranfer package. Writes DC and Premis Metadata.
-func (p *Preserver) preprocessPackage(ctx context.Context, processingDir, packagePath string, nodeCollection *models.RestNodesCollection, userData *models.IdmUser) (string, error) {
+// The service events of the package are recorded in its PREMIS XML, and the access conditions of the node as a submission document.
+func (p *Preserver) preprocessPackage(ctx context.Context, processingDir, packagePath, workspacePath, nodeUUID string, events serviceEvents, packageVersion processor.PackageVersion, userData *models.IdmUser) (string, error) {
 	// Create the a3m transfer directory
 	a3mTransferDir := filepath.Join(processingDir, "a3m_transfer")
 	if err := utils.CreateDir(a3mTransferDir); err != nil {
 		return "", fmt.Errorf("failed to create a3m transfer directory: %w", err)
 	}
+	// Look up the node again, it may have been moved or edited since the download
+	root, err := p.cellsClient.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return "", fmt.Errorf("error getting node %s: %w", nodeUUID, err)
+	}
+	resolvedPath := strings.Trim(root.Path, "/")
+	if resolvedPath == "" {
+		return "", fmt.Errorf("node has no path: %s", nodeUUID)
+	}
+	walkNodes := func(fn func(node *models.TreeNode) error) error {
+		parent, err := p.cellsClient.WalkNodeCollection(ctx, resolvedPath, fn)
+		if err != nil {
+			return fmt.Errorf("error walking node collection: %w", err)
+		}
+		// The node may have moved while listing it
+		if parent.UUID != nodeUUID {
+			return fmt.Errorf("node %s moved while listing it: %s is now %s", nodeUUID, resolvedPath, parent.UUID)
+		}
+		return nil
+	}
 	// Preprocess package
-	transferPath, err := processor.PreprocessPackage(ctx, packagePath, a3mTransferDir, nodeCollection, userData, p.envConfig.Premis.Organization)
+	transferPath, err := processor.PreprocessPackage(ctx, packagePath, a3mTransferDir, root, walkNodes, events.premisEvents, packageVersion, processor.PremisOptions{
+		Checksums: processor.ChecksumOptions{
+			Algorithms: p.envConfig.Premis.ChecksumAlgorithms,
+			Workers:    p.envConfig.Premis.ChecksumWorkers,
+		},
+		EventParsing: p.envConfig.Premis.EventParsing,
+	}, userData, p.envConfig.Premis.Organization)
 	if err != nil {
 		return "", fmt.Errorf("error preprocessing package: %w", err)
 	}
+	if err := p.writeAccessMetadata(ctx, transferPath, workspacePath, root); err != nil {
+		return "", err
+	}
 	return transferPath, nil
 }
 
@@ -561,6 +842,10 @@ func (p *Preserver) submitPackage(ctx context.Context, transferPath, transferNam
 	var aipUUID string
 	// Submit package to A3M with retry
 	if err := utils.Retry(3, 2*time.Second, func() error {
+		// Wait for A3M on the job context, so the submission timeout only starts once the submission is let through
+		if err := p.a3mClient.WaitAvailable(ctx); err != nil {
+			return err
+		}
 		logger.Debug("Queing A3M Transfer: %s", utils.RelPath(p.envConfig.ProcessingBaseDir, transferPath))
 		ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
 		defer cancel()
@@ -594,9 +879,25 @@ func (p *Preserver) compressPackage(ctx context.Context, processingAipDir, aipPa
 	return archiveAipPath, nil
 }
 
-// Uploads the AIP to Cells
-func (p *Preserver) uploadPackage(ctx context.Context, userClient cells.UserClient, aipPath string) (string, error) {
-	return p.cellsClient.UploadNode(ctx, userClient, aipPath, p.envConfig.Cells.ArchiveWorkspace)
+// Uploads the AIP to Cells. Retries on transient errors, resuming from the last uploaded part.
+// Partial uploads don't resume across jobs, so they are aborted once the upload finally fails.
+func (p *Preserver) uploadPackage(ctx context.Context, userClient cells.UserClient, nodeUUID, aipPath, archiveDestination string) (string, error) {
+	var uploadedPath string
+	err := utils.Retry(3, 2*time.Second, func() error {
+		var uploadErr error
+		uploadedPath, uploadErr = p.cellsClient.UploadNode(ctx, userClient, nodeUUID, aipPath, archiveDestination)
+		return uploadErr
+	}, utils.IsTransientError)
+	if err != nil {
+		// Abort even if the job context is done
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if abortErr := p.cellsClient.AbortUploads(abortCtx, userClient, nodeUUID); abortErr != nil {
+			logger.Warn("Failed to abort partial uploads of %s: %v", aipPath, abortErr)
+		}
+		return "", err
+	}
+	return uploadedPath, nil
 }
 
 // Construct the path of the A3M Generated AIP and ensures it exists
@@ -0,0 +1,572 @@
+package preservation
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // Cells ETags are MD5 sums
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	transferservice "github.com/penwern/curate-preservation-core/common/proto/a3m/gen/go/a3m/api/transferservice/v1beta1"
+	"github.com/penwern/curate-preservation-core/internal/a3mclient"
+	"github.com/penwern/curate-preservation-core/internal/a3mtest"
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/internal/disposition"
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// fakeCells is an in-memory Cells server holding nodes and their content, keyed by absolute path.
+// The personal-files workspace resolves to personal/<login>, other workspace paths are their own absolute paths.
+type fakeCells struct {
+	login          string
+	corruptUploads bool // Store uploaded files with a wrong ETag
+
+	mu      sync.Mutex
+	nodes   map[string]*models.TreeNode
+	content map[string][]byte
+	tags    map[string][]map[string]string // Tags written to each node UUID, in order
+	listed  []string                       // Paths whose children were listed, in order
+}
+
+func newFakeCells(login string) *fakeCells {
+	return &fakeCells{
+		login:   login,
+		nodes:   map[string]*models.TreeNode{},
+		content: map[string][]byte{},
+		tags:    map[string][]map[string]string{},
+	}
+}
+
+// addFolder adds a folder node and its missing parents.
+func (f *fakeCells) addFolder(absPath string) *models.TreeNode {
+	absPath = strings.Trim(absPath, "/")
+	if node, ok := f.nodes[absPath]; ok {
+		return node
+	}
+	if parent := path.Dir(absPath); parent != "." {
+		f.addFolder(parent)
+	}
+	collection := models.TreeNodeTypeCOLLECTION
+	node := &models.TreeNode{UUID: uuid.NewString(), Path: absPath, Type: &collection, MetaStore: map[string]string{}, MTime: strconv.FormatInt(time.Now().Unix(), 10)}
+	f.nodes[absPath] = node
+	return node
+}
+
+// addFile adds a file node, its content and its missing parents. The ETag is the MD5 of the content, like a single part upload.
+func (f *fakeCells) addFile(absPath string, content []byte) *models.TreeNode {
+	absPath = strings.Trim(absPath, "/")
+	f.addFolder(path.Dir(absPath))
+	sum := md5.Sum(content) //nolint:gosec // Cells ETags are MD5 sums
+	leaf := models.TreeNodeTypeLEAF
+	node := &models.TreeNode{
+		UUID:      uuid.NewString(),
+		Path:      absPath,
+		Type:      &leaf,
+		Size:      strconv.Itoa(len(content)),
+		Etag:      hex.EncodeToString(sum[:]),
+		MTime:     strconv.FormatInt(time.Now().Unix(), 10),
+		MetaStore: map[string]string{},
+	}
+	f.nodes[absPath] = node
+	f.content[absPath] = content
+	return node
+}
+
+func (f *fakeCells) node(absPath string) (*models.TreeNode, error) {
+	node, ok := f.nodes[strings.Trim(absPath, "/")]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", cells.ErrNodeNotFound, absPath)
+	}
+	return node, nil
+}
+
+func (f *fakeCells) resolve(workspacePath string) string {
+	workspacePath = strings.Trim(workspacePath, "/")
+	if rest, ok := strings.CutPrefix(workspacePath, "personal-files"); ok {
+		return strings.Trim("personal/"+f.login+rest, "/")
+	}
+	return workspacePath
+}
+
+// walk calls fn for the nodes below a path, sorted by path, recursively or only its children.
+func (f *fakeCells) walk(absNodePath string, recursive bool, fn func(node *models.TreeNode) error) (*models.TreeNode, error) {
+	f.mu.Lock()
+	parent, err := f.node(absNodePath)
+	var children []*models.TreeNode
+	if err == nil {
+		prefix := parent.Path + "/"
+		for nodePath, node := range f.nodes {
+			if strings.HasPrefix(nodePath, prefix) && (recursive || !strings.Contains(strings.TrimPrefix(nodePath, prefix), "/")) {
+				children = append(children, node)
+			}
+		}
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Path < children[j].Path })
+	for _, child := range children {
+		if err := fn(child); err != nil {
+			return nil, err
+		}
+	}
+	return parent, nil
+}
+
+func (f *fakeCells) Close() {}
+
+func (f *fakeCells) CloseUserClient(context.Context, cells.UserClient) error { return nil }
+
+func (f *fakeCells) CreateFolder(_ context.Context, _ cells.UserClient, folderPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addFolder(f.resolve(folderPath))
+	return nil
+}
+
+func (f *fakeCells) DeleteNode(_ context.Context, _ cells.UserClient, nodePath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	absPath := f.resolve(nodePath)
+	for nodePath := range f.nodes {
+		if nodePath == absPath || strings.HasPrefix(nodePath, absPath+"/") {
+			delete(f.nodes, nodePath)
+			delete(f.content, nodePath)
+		}
+	}
+	return nil
+}
+
+func (f *fakeCells) MoveNode(context.Context, cells.UserClient, string, string, string) (string, error) {
+	return "", fmt.Errorf("move not supported")
+}
+
+// DownloadNode writes the content below a workspace path to dest, like the transfer backends.
+func (f *fakeCells) DownloadNode(_ context.Context, _ cells.UserClient, _, cellsSrc, dest string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	root, err := f.node(f.resolve(cellsSrc))
+	if err != nil {
+		return "", err
+	}
+	for nodePath, node := range f.nodes {
+		if nodePath != root.Path && !strings.HasPrefix(nodePath, root.Path+"/") {
+			continue
+		}
+		localPath := filepath.Join(dest, filepath.FromSlash(path.Base(root.Path)+strings.TrimPrefix(nodePath, root.Path)))
+		if !cells.IsContentNode(node) {
+			if err := os.MkdirAll(localPath, 0o750); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0o750); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(localPath, f.content[nodePath], 0o600); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dest, path.Base(root.Path)), nil
+}
+
+func (f *fakeCells) WalkNodeCollection(_ context.Context, absNodePath string, fn func(node *models.TreeNode) error) (*models.TreeNode, error) {
+	return f.walk(absNodePath, true, fn)
+}
+
+func (f *fakeCells) WalkNodeChildren(_ context.Context, absNodePath string, fn func(node *models.TreeNode) error) (*models.TreeNode, error) {
+	f.mu.Lock()
+	f.listed = append(f.listed, strings.Trim(absNodePath, "/"))
+	f.mu.Unlock()
+	return f.walk(absNodePath, false, fn)
+}
+
+func (f *fakeCells) GetNodeByUUID(_ context.Context, nodeUUID string) (*models.TreeNode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, node := range f.nodes {
+		if node.UUID == nodeUUID {
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", cells.ErrNodeNotFound, nodeUUID)
+}
+
+func (f *fakeCells) GetNodeAccess(_ context.Context, _ string, node *models.TreeNode) *cells.NodeAccess {
+	return &cells.NodeAccess{NodeUUID: node.UUID, NodePath: node.Path, Collected: time.Now()}
+}
+
+func (f *fakeCells) GetNodeStats(_ context.Context, absNodePath string) (*models.TreeReadNodeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	node, err := f.node(absNodePath)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TreeReadNodeResponse{Node: node}, nil
+}
+
+func (f *fakeCells) NewUserClient(context.Context, string, bool) (cells.UserClient, error) {
+	return f.AdminUserClient(), nil
+}
+
+func (f *fakeCells) RefreshWorkspaces(context.Context) error { return nil }
+
+func (f *fakeCells) ResolveCellsPath(_ cells.UserClient, cellsPath string) (string, error) {
+	return f.resolve(cellsPath), nil
+}
+
+func (f *fakeCells) UnresolveCellsPath(_ cells.UserClient, cellsPath string) (string, error) {
+	cellsPath = strings.Trim(cellsPath, "/")
+	if rest, ok := strings.CutPrefix(cellsPath, "personal/"+f.login); ok {
+		return "personal-files" + rest, nil
+	}
+	return cellsPath, nil
+}
+
+func (f *fakeCells) UpdateTag(ctx context.Context, userClient cells.UserClient, nodeUUID, namespace, content string) error {
+	return f.UpdateTags(ctx, userClient, nodeUUID, map[string]string{namespace: content})
+}
+
+// UpdateTags stores the tags JSON encoded, like Cells metadata values.
+func (f *fakeCells) UpdateTags(ctx context.Context, _ cells.UserClient, nodeUUID string, tags map[string]string) error {
+	node, err := f.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	written := map[string]string{}
+	for namespace, value := range tags {
+		encoded, _ := json.Marshal(value)
+		node.MetaStore[namespace] = string(encoded)
+		written[namespace] = value
+	}
+	f.tags[nodeUUID] = append(f.tags[nodeUUID], written)
+	return nil
+}
+
+func (f *fakeCells) UpdateMeta(ctx context.Context, _ cells.UserClient, nodeUUID string, values map[string]json.RawMessage) error {
+	node, err := f.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for namespace, value := range values {
+		node.MetaStore[namespace] = string(value)
+	}
+	return nil
+}
+
+func (f *fakeCells) DeleteMeta(ctx context.Context, _ cells.UserClient, nodeUUID string, namespaces []string) error {
+	node, err := f.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, namespace := range namespaces {
+		delete(node.MetaStore, namespace)
+	}
+	return nil
+}
+
+func (f *fakeCells) AdminUserClient() cells.UserClient {
+	return cells.UserClient{UserData: &models.IdmUser{UUID: "user-uuid", Login: f.login, GroupPath: "/"}}
+}
+
+// UploadNode adds a local file or folder below a workspace path, like the transfer backends.
+func (f *fakeCells) UploadNode(_ context.Context, _ cells.UserClient, _, src, cellsDest string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	destination := path.Join(f.resolve(cellsDest), filepath.Base(src))
+	err := filepath.WalkDir(src, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, localPath)
+		if err != nil {
+			return err
+		}
+		nodePath := path.Join(destination, filepath.ToSlash(rel))
+		if d.IsDir() {
+			f.addFolder(nodePath)
+			return nil
+		}
+		content, err := os.ReadFile(filepath.Clean(localPath))
+		if err != nil {
+			return err
+		}
+		node := f.addFile(nodePath, content)
+		if f.corruptUploads {
+			node.Etag = strings.Repeat("0", 32)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return path.Join(strings.Trim(cellsDest, "/"), filepath.Base(src)), nil
+}
+
+func (f *fakeCells) AbortUploads(context.Context, cells.UserClient, string) error { return nil }
+
+// VerifyUploadedNode compares the size and MD5 ETag of a node with a local file.
+func (f *fakeCells) VerifyUploadedNode(node *models.TreeNode, localPath string) error {
+	content, err := os.ReadFile(filepath.Clean(localPath))
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(content) //nolint:gosec // Cells ETags are MD5 sums
+	if node.Size != strconv.Itoa(len(content)) || node.Etag != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", node.Etag, hex.EncodeToString(sum[:]))
+	}
+	return nil
+}
+
+// newTestPreserver creates a preserver of the fake Cells server, submitting to a fake A3M.
+func newTestPreserver(t *testing.T, fake *fakeCells, scripts ...a3mtest.Script) (*Preserver, *a3mtest.Server) {
+	t.Helper()
+	baseDir := t.TempDir()
+	logger.Initialize("error", filepath.Join(baseDir, "test.log"))
+
+	cfg := &config.Config{ProcessingBaseDir: filepath.Join(baseDir, "processing")}
+	if err := os.MkdirAll(cfg.ProcessingBaseDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	cfg.A3M.CompletedDir = filepath.Join(baseDir, "completed")
+	if err := os.MkdirAll(cfg.A3M.CompletedDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Cells.ArchiveWorkspace = "common-files"
+	cfg.Cells.CollisionPolicy = CollisionVersion
+	cfg.Premis.RecordAccess = true
+	cfg.Premis.EventParsing = "strict"
+	cfg.Preservation.ProfileNamespace = "usermeta-preservation-profile"
+	cfg.Status.PreservationNamespace = "usermeta-preservation-status"
+	cfg.Status.PreservationCodeNamespace = "usermeta-preservation-status-code"
+	cfg.Status.Vocabulary = config.PlainVocabulary
+	cfg.Results.AipUUIDNamespace = "usermeta-preservation-aip-uuid"
+	cfg.Results.AipLocationNamespace = "usermeta-preservation-aip-location"
+	cfg.Results.VersionNamespace = "usermeta-preservation-aip-version"
+	cfg.Results.ContentFingerprintNamespace = "usermeta-preservation-content-fingerprint"
+	cfg.Results.SourceUUIDNamespace = "usermeta-preservation-source-uuid"
+	cfg.Source.ChangeAction = SourceChangeFlag
+	cfg.Disposition.Action = disposition.ActionLeave
+
+	server := a3mtest.NewServer(a3mtest.Options{CompletedDir: cfg.A3M.CompletedDir})
+	dialOptions := server.StartBufconn()
+	t.Cleanup(server.Stop)
+	server.Enqueue(scripts...)
+	a3mClient, err := a3mclient.NewClientWithOptions(server.Address(), a3mclient.ClientOptions{PollInterval: time.Millisecond, DialOptions: dialOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a3mClient.Close)
+
+	vocabularies, err := config.LoadStatusVocabularies("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispositionDir := DispositionDir(cfg.ProcessingBaseDir)
+	return &Preserver{
+		a3mClient:        a3mClient,
+		cellsClient:      fake,
+		envConfig:        cfg,
+		profiles:         config.ProcessingProfiles{},
+		vocabularies:     vocabularies,
+		dispositionQueue: disposition.NewQueue(disposition.QueuePath(dispositionDir)),
+		dispositionAudit: disposition.NewAuditLog(disposition.AuditPath(dispositionDir)),
+	}, server
+}
+
+// statusCodes returns the preservation status codes written to a node, in order.
+func (f *fakeCells) statusCodes(nodeUUID, namespace string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var codes []string
+	for _, tags := range f.tags[nodeUUID] {
+		if code, ok := tags[namespace]; ok {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+func TestRunPreservesPackage(t *testing.T) {
+	fake := newFakeCells("alice")
+	source := fake.addFolder("personal/alice/reports")
+	fake.addFile("personal/alice/reports/summary.txt", []byte("quarterly summary\n"))
+	fake.addFile("personal/alice/reports/figures/chart.csv", []byte("q,value\n1,10\n2,20\n"))
+	p, server := newTestPreserver(t, fake, a3mtest.Script{ProcessingPolls: 1, Outcome: a3mtest.OutcomeComplete})
+	ns := p.envConfig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pcfg := config.DefaultPreservationConfig()
+	err := p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	submissions := server.Submissions()
+	if len(submissions) != 1 || submissions[0].Name != "reports" {
+		t.Fatalf("submissions: got %v, want one named reports", submissions)
+	}
+	codes := fake.statusCodes(source.UUID, ns.Status.PreservationCodeNamespace)
+	if len(codes) == 0 || codes[len(codes)-1] != string(config.StatusPreserved) {
+		t.Fatalf("status codes: got %v, want to end with %s", codes, config.StatusPreserved)
+	}
+
+	// The AIP is archived with its results, linking it back to the source
+	aipUUID := metaString(source.MetaStore[ns.Results.AipUUIDNamespace])
+	location := metaString(source.MetaStore[ns.Results.AipLocationNamespace])
+	if aipUUID == "" || location != "common-files/reports-"+aipUUID {
+		t.Fatalf("results: got AIP %q at %q, want it in common-files", aipUUID, location)
+	}
+	aip, err := fake.node(location)
+	if err != nil {
+		t.Fatalf("AIP node: %v", err)
+	}
+	if got := metaString(aip.MetaStore[ns.Results.SourceUUIDNamespace]); got != source.UUID {
+		t.Errorf("AIP source UUID: got %q, want %q", got, source.UUID)
+	}
+	if _, err := fake.node(location + "/data/objects/data/reports/figures/chart.csv"); err != nil {
+		t.Errorf("AIP content: %v", err)
+	}
+	if metaString(source.MetaStore[ns.Results.ContentFingerprintNamespace]) == "" {
+		t.Error("results: got no content fingerprint, want the fingerprint of the preserved content")
+	}
+
+	// The processing directory and the A3M AIP are cleaned up
+	if entries, _ := os.ReadDir(ns.A3M.CompletedDir); len(entries) != 0 {
+		t.Errorf("completed dir: got %d entries, want none", len(entries))
+	}
+
+	// Unchanged content is skipped on the next run
+	err = p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true})
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if len(server.Submissions()) != 1 {
+		t.Fatalf("second run: got %d submissions, want the unchanged package skipped", len(server.Submissions()))
+	}
+
+	// Unchanged content is preserved again with other processing settings
+	server.Enqueue(a3mtest.Script{ProcessingPolls: 1, Outcome: a3mtest.OutcomeComplete})
+	pcfg.CompressAip = !pcfg.CompressAip
+	err = p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true})
+	if err != nil {
+		t.Fatalf("third run: %v", err)
+	}
+	if len(server.Submissions()) != 2 {
+		t.Fatalf("third run: got %d submissions, want the package preserved with the new settings", len(server.Submissions()))
+	}
+}
+
+func TestRunFailedPackage(t *testing.T) {
+	fake := newFakeCells("alice")
+	source := fake.addFolder("personal/alice/reports")
+	fake.addFile("personal/alice/reports/summary.txt", []byte("quarterly summary\n"))
+	p, _ := newTestPreserver(t, fake, a3mtest.Script{Outcome: a3mtest.OutcomeFailed, Jobs: []*transferservice.Job{a3mtest.FailedJob("Normalize")}})
+	ns := p.envConfig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pcfg := config.DefaultPreservationConfig()
+	err := p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true})
+	if err == nil || !strings.Contains(err.Error(), "Normalize") {
+		t.Fatalf("run: got %v, want the failed A3M job", err)
+	}
+	codes := fake.statusCodes(source.UUID, ns.Status.PreservationCodeNamespace)
+	if len(codes) == 0 || codes[len(codes)-1] != string(config.StatusFailed) {
+		t.Fatalf("status codes: got %v, want to end with %s", codes, config.StatusFailed)
+	}
+	if got := metaString(source.MetaStore[ns.Results.AipUUIDNamespace]); got != "" {
+		t.Errorf("results: got AIP %q, want none", got)
+	}
+}
+
+func TestRunCorruptUpload(t *testing.T) {
+	fake := newFakeCells("alice")
+	fake.corruptUploads = true
+	source := fake.addFolder("personal/alice/reports")
+	fake.addFile("personal/alice/reports/summary.txt", []byte("quarterly summary\n"))
+	p, _ := newTestPreserver(t, fake, a3mtest.Script{Outcome: a3mtest.OutcomeComplete})
+	p.envConfig.Disposition.MoveDir = "personal-files/preserved"
+	ns := p.envConfig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pcfg := config.DefaultPreservationConfig()
+	err := p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true, DispositionAction: disposition.ActionMove})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("run: got %v, want a fixity failure of the uploaded AIP", err)
+	}
+	codes := fake.statusCodes(source.UUID, ns.Status.PreservationCodeNamespace)
+	if len(codes) == 0 || codes[len(codes)-1] != string(config.StatusFailed) {
+		t.Fatalf("status codes: got %v, want to end with %s", codes, config.StatusFailed)
+	}
+	// The source is left in place, without results pointing to the corrupt AIP
+	if _, err := fake.node("personal/alice/reports/summary.txt"); err != nil {
+		t.Errorf("source: %v", err)
+	}
+	if got := metaString(source.MetaStore[ns.Results.AipUUIDNamespace]); got != "" {
+		t.Errorf("results: got AIP %q, want none", got)
+	}
+}
+
+func TestRunReplacesArchivedAIP(t *testing.T) {
+	fake := newFakeCells("alice")
+	source := fake.addFolder("personal/alice/reports")
+	fake.addFile("personal/alice/reports/summary.txt", []byte("quarterly summary\n"))
+	p, _ := newTestPreserver(t, fake,
+		a3mtest.Script{Outcome: a3mtest.OutcomeComplete},
+		a3mtest.Script{Outcome: a3mtest.OutcomeComplete},
+	)
+	ns := p.envConfig
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pcfg := config.DefaultPreservationConfig()
+	if err := p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	first := metaString(source.MetaStore[ns.Results.AipLocationNamespace])
+
+	p.envConfig.Cells.CollisionPolicy = CollisionReplace
+	if err := p.Run(ctx, &pcfg, config.DefaultAtomConfig(), fake.AdminUserClient(), Target{UUID: source.UUID}, RunOptions{CleanUp: true, Force: true}); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	second := metaString(source.MetaStore[ns.Results.AipLocationNamespace])
+	if second == first {
+		t.Fatalf("results: got AIP location %q again, want the new AIP", second)
+	}
+	if _, err := fake.node(second); err != nil {
+		t.Errorf("new AIP: %v", err)
+	}
+	if _, err := fake.node(first); err == nil {
+		t.Errorf("replaced AIP: got %s, want it deleted", first)
+	}
+	// The recorded and uploaded AIPs are looked up by path, the archive isn't listed
+	for _, listed := range fake.listed {
+		if listed == "common-files" {
+			t.Errorf("listed the archive destination, want the AIPs looked up by path")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package preservation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// Results holds the outcome of a preservation job.
+// It is written back to Cells as metadata on the source node and the uploaded AIP node,
+// so Cells search and UI can link an original to its AIP and the reverse.
+type Results struct {
+	AipUUID            string
+	AipLocation        string // AIP path in the archive workspace, e.g. common-files/transfer-uuid.zip
+	Version            int    // 1 for the first AIP of the source node, incremented by each re-preservation
+	PreviousAipUUID    string // UUID of the AIP this one follows. Empty for the first version
+	Date               time.Time
+	AipSize            int64  // Bytes. Summed over files for uncompressed AIPs
+	AipChecksum        string // sha256:<hex> of a compressed AIP. sha256:<hex> <file> of the tag manifest, or generated manifest, of an uncompressed AIP
+	Profile            string // Processing profile used. Empty if the request configuration was used
+	AtomSlug           string // Empty if no DIP was deposited
+	ContentFingerprint string // Fingerprint of the preserved source content. Empty if it changed during the job
+	DipURL             string
+	SourceUUID         string
+	SourcePath         string
+}
+
+// sourceTags returns the results metadata for the source node, keyed by namespace.
+func (p *Preserver) sourceTags(r Results) map[string]string {
+	ns := p.envConfig.Results
+	tags := map[string]string{}
+	setTag(tags, ns.AipUUIDNamespace, r.AipUUID)
+	setTag(tags, ns.AipLocationNamespace, r.AipLocation)
+	if r.Version > 0 {
+		setTag(tags, ns.VersionNamespace, strconv.Itoa(r.Version))
+	}
+	setTag(tags, ns.PreviousAipUUIDNamespace, r.PreviousAipUUID)
+	if !r.Date.IsZero() {
+		setTag(tags, ns.DateNamespace, r.Date.UTC().Format(time.RFC3339))
+	}
+	if r.AipSize > 0 {
+		setTag(tags, ns.AipSizeNamespace, strconv.FormatInt(r.AipSize, 10))
+	}
+	setTag(tags, ns.AipChecksumNamespace, r.AipChecksum)
+	setTag(tags, ns.ProfileNamespace, r.Profile)
+	setTag(tags, ns.AtomSlugNamespace, r.AtomSlug)
+	setTag(tags, ns.DipURLNamespace, r.DipURL)
+	setTag(tags, ns.ContentFingerprintNamespace, r.ContentFingerprint)
+	return tags
+}
+
+// aipTags returns the results metadata for the uploaded AIP node, keyed by namespace. It links back to the source node.
+func (p *Preserver) aipTags(r Results) map[string]string {
+	ns := p.envConfig.Results
+	tags := p.sourceTags(r)
+	setTag(tags, ns.SourceUUIDNamespace, r.SourceUUID)
+	setTag(tags, ns.SourcePathNamespace, r.SourcePath)
+	return tags
+}
+
+// setTag sets a tag if its namespace is set. Empty values clear the namespace.
+func setTag(tags map[string]string, namespace, value string) {
+	if namespace != "" {
+		tags[namespace] = value
+	}
+}
+
+// writeResults writes the results metadata to the source node and the uploaded AIP node.
+// Results left empty by this job are cleared from the source node, so none remain from an earlier job.
+// Both nodes are attempted, the first error is returned.
+func (p *Preserver) writeResults(ctx context.Context, userClient cells.UserClient, aipNodeUUID string, results Results) error {
+	var firstErr error
+	if err := p.writeTags(ctx, userClient, results.SourceUUID, p.sourceTags(results)); err != nil {
+		firstErr = fmt.Errorf("error writing results to source node: %w", err)
+	}
+	if aipNodeUUID != "" {
+		if err := p.writeTags(ctx, userClient, aipNodeUUID, p.aipTags(results)); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error writing results to AIP node: %w", err)
+		}
+	}
+	if firstErr == nil {
+		logger.Debug("Wrote preservation results: {source: %s, aip: %s}", results.SourceUUID, aipNodeUUID)
+	}
+	return firstErr
+}
+
+// writeTags sets the non-empty tags of a node, and deletes the empty ones it has.
+func (p *Preserver) writeTags(ctx context.Context, userClient cells.UserClient, nodeUUID string, tags map[string]string) error {
+	set := map[string]string{}
+	var cleared []string
+	for namespace, value := range tags {
+		if value != "" {
+			set[namespace] = value
+		} else {
+			cleared = append(cleared, namespace)
+		}
+	}
+	if err := p.cellsClient.UpdateTags(ctx, userClient, nodeUUID, set); err != nil {
+		return err
+	}
+	if len(cleared) == 0 {
+		return nil
+	}
+	// Only delete the namespaces the node has
+	node, err := p.cellsClient.GetNodeByUUID(ctx, nodeUUID)
+	if err != nil {
+		return fmt.Errorf("error getting node %s: %w", nodeUUID, err)
+	}
+	var stale []string
+	for _, namespace := range cleared {
+		if _, ok := node.MetaStore[namespace]; ok {
+			stale = append(stale, namespace)
+		}
+	}
+	if len(stale) > 0 {
+		logger.Debug("Clearing results of an earlier job from %s: %v", nodeUUID, stale)
+	}
+	return p.cellsClient.DeleteMeta(ctx, userClient, nodeUUID, stale)
+}
+
+// tagManifests are the BagIt tag manifests identifying an uncompressed AIP, strongest algorithm first.
+// A tag manifest lists the checksums of the payload manifests, which list the checksums of every payload file.
+var tagManifests = []string{"tagmanifest-sha512.txt", "tagmanifest-sha256.txt", "tagmanifest-sha1.txt", "tagmanifest-md5.txt"}
+
+// aipSizeAndChecksum returns the size of an AIP and its sha256 checksum.
+// A compressed AIP is checksummed as a whole: sha256:<hex>.
+// An uncompressed AIP is checksummed from its bag tag manifest: sha256:<hex> tagmanifest-<algorithm>.txt.
+// Bags without a tag manifest are checksummed from a generated manifest of every file, sorted by path: sha256:<hex> manifest.
+func aipSizeAndChecksum(aipPath string) (int64, string, error) {
+	info, err := os.Stat(aipPath)
+	if err != nil {
+		return 0, "", err
+	}
+	if !info.IsDir() {
+		size, checksum, err := fileChecksum(aipPath)
+		if err != nil {
+			return 0, "", err
+		}
+		return size, "sha256:" + checksum, nil
+	}
+
+	var size int64
+	var files []string
+	err = filepath.WalkDir(aipPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fileInfo.Size()
+		files = append(files, filePath)
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	for _, name := range tagManifests {
+		if _, err := os.Stat(filepath.Join(aipPath, name)); err == nil {
+			_, checksum, err := fileChecksum(filepath.Join(aipPath, name))
+			if err != nil {
+				return 0, "", err
+			}
+			return size, fmt.Sprintf("sha256:%s %s", checksum, name), nil
+		}
+	}
+
+	// Generated manifest, one "<sha256>  <path>" line per file as in BagIt manifests
+	sort.Strings(files)
+	manifest := sha256.New()
+	for _, filePath := range files {
+		_, checksum, err := fileChecksum(filePath)
+		if err != nil {
+			return 0, "", err
+		}
+		relPath, err := filepath.Rel(aipPath, filePath)
+		if err != nil {
+			return 0, "", err
+		}
+		_, _ = fmt.Fprintf(manifest, "%s  %s\n", checksum, filepath.ToSlash(relPath))
+	}
+	return size, fmt.Sprintf("sha256:%s manifest", hex.EncodeToString(manifest.Sum(nil))), nil
+}
+
+// fileChecksum returns the size and sha256 hex checksum of a file.
+func fileChecksum(filePath string) (int64, string, error) {
+	f, err := os.Open(filepath.Clean(filePath))
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dipURL returns the URL of the AtoM description a DIP was deposited to.
+func dipURL(atomHost, slug string) string {
+	if atomHost == "" || slug == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/index.php/%s", strings.TrimSuffix(atomHost, "/"), slug)
+}
@@ -0,0 +1,173 @@
+package preservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/utils"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// Source change actions.
+const (
+	SourceChangeFlag    = "flag"    // Tag the node as preserved with a changed source
+	SourceChangeRequeue = "requeue" // Also return ErrSourceChanged, so the job is run again
+)
+
+// maxSourceDriftPaths bounds the changed paths logged for a source drift.
+const maxSourceDriftPaths = 10
+
+// ErrSourceChanged is returned by Run when the source changed during the job and the change action is requeue.
+// The AIP is stored and the node is tagged, so the job only needs running again to preserve the current source.
+var ErrSourceChanged = errors.New("source changed during preservation")
+
+// sourceSnapshot fingerprints the file nodes of a package, keyed by node UUID.
+// Fingerprints are hashed to keep the snapshot small for very large packages.
+type sourceSnapshot map[string]uint64
+
+// sourceDrift counts the file nodes changed, added and removed since a snapshot.
+type sourceDrift struct {
+	changed int
+	added   int
+	removed int
+	paths   []string // Sample of changed and added paths
+}
+
+func (d sourceDrift) drifted() bool {
+	return d.changed+d.added+d.removed > 0
+}
+
+// String summarises the drift, e.g. "2 changed, 1 added".
+func (d sourceDrift) String() string {
+	var parts []string
+	if d.changed > 0 {
+		parts = append(parts, fmt.Sprintf("%d changed", d.changed))
+	}
+	if d.added > 0 {
+		parts = append(parts, fmt.Sprintf("%d added", d.added))
+	}
+	if d.removed > 0 {
+		parts = append(parts, fmt.Sprintf("%d removed", d.removed))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// nodeFingerprint hashes the node attributes changing with its content or location.
+func nodeFingerprint(node *models.TreeNode) uint64 {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", node.Etag, node.MTime, node.Size, strings.Trim(node.Path, "/"))
+	return h.Sum64()
+}
+
+// snapshotSource fingerprints the file nodes of a package, a page at a time.
+// The content fingerprint of the package, rooted at rootPath, is computed from the same listing.
+func (p *Preserver) snapshotSource(ctx context.Context, nodeUUID, rootPath string) (sourceSnapshot, string, error) {
+	snapshot := sourceSnapshot{}
+	content := newContentFingerprint(rootPath)
+	err := p.walkSource(ctx, nodeUUID, func(node *models.TreeNode) error {
+		snapshot[node.UUID] = nodeFingerprint(node)
+		content.add(node)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Debug("Source snapshot of %s: %d files, content fingerprint %s", nodeUUID, len(snapshot), content)
+	return snapshot, content.String(), nil
+}
+
+// sourceDrift compares the file nodes of a package with a snapshot.
+func (p *Preserver) sourceDrift(ctx context.Context, nodeUUID string, snapshot sourceSnapshot) (sourceDrift, error) {
+	var drift sourceDrift
+	seen := make(map[string]struct{}, len(snapshot))
+	err := p.walkSource(ctx, nodeUUID, func(node *models.TreeNode) error {
+		seen[node.UUID] = struct{}{}
+		fingerprint, ok := snapshot[node.UUID]
+		switch {
+		case !ok:
+			drift.added++
+		case fingerprint != nodeFingerprint(node):
+			drift.changed++
+		default:
+			return nil
+		}
+		if len(drift.paths) < maxSourceDriftPaths {
+			drift.paths = append(drift.paths, node.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return sourceDrift{}, err
+	}
+	for uuid := range snapshot {
+		if _, ok := seen[uuid]; !ok {
+			drift.removed++
+		}
+	}
+	return drift, nil
+}
+
+// walkSource calls fn for the package node and each file node below it.
+func (p *Preserver) walkSource(ctx context.Context, nodeUUID string, fn func(node *models.TreeNode) error) error {
+	resolvedPath, err := p.nodePath(ctx, nodeUUID)
+	if err != nil {
+		return err
+	}
+	visit := func(node *models.TreeNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !cells.IsContentNode(node) {
+			return nil
+		}
+		return fn(node)
+	}
+	parent, err := p.cellsClient.WalkNodeCollection(ctx, resolvedPath, visit)
+	if err != nil {
+		return fmt.Errorf("error walking node collection: %w", err)
+	}
+	// The node may have moved while listing it
+	if parent.UUID != nodeUUID {
+		return fmt.Errorf("node %s moved while listing it: %s is now %s", nodeUUID, resolvedPath, parent.UUID)
+	}
+	return visit(parent)
+}
+
+// lockSource locks the package node for the job's user with the configured lock namespace.
+// Returns a function releasing the lock, once however many times it is called. Locking is best effort: a failure is logged and the job goes on.
+func (p *Preserver) lockSource(ctx context.Context, userClient cells.UserClient, nodeUUID string) func() {
+	namespace := p.envConfig.Source.LockNamespace
+	if namespace == "" || userClient.UserData == nil {
+		return func() {}
+	}
+	if err := p.cellsClient.UpdateTags(ctx, userClient, nodeUUID, map[string]string{namespace: userClient.UserData.Login}); err != nil {
+		logger.Warn("Failed to lock source node %s: %v", nodeUUID, err)
+		return func() {}
+	}
+	logger.Debug("Locked source node %s", nodeUUID)
+	var once sync.Once
+	return func() {
+		once.Do(func() { p.unlockSource(ctx, userClient, nodeUUID, namespace) })
+	}
+}
+
+// unlockSource releases the lock of the package node.
+func (p *Preserver) unlockSource(ctx context.Context, userClient cells.UserClient, nodeUUID, namespace string) {
+	// Unlock even if the job context is done
+	ctx = context.WithoutCancel(ctx)
+	err := utils.Retry(3, 2*time.Second, func() error {
+		return p.cellsClient.DeleteMeta(ctx, userClient, nodeUUID, []string{namespace})
+	}, utils.IsTransientError)
+	if err != nil {
+		logger.Error("Failed to unlock source node %s: %v", nodeUUID, err)
+		return
+	}
+	logger.Debug("Unlocked source node %s", nodeUUID)
+}
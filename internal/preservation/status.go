@@ -0,0 +1,85 @@
+package preservation
+
+import (
+	"context"
+	"time"
+
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/pkg/config"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/utils"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// TagUpdaters holds functions to update the status namespaces of a node.
+// Each update writes the status label, in the job's vocabulary, and the status code.
+// The detail, if any, is appended to the label, e.g. an error message. An empty code clears the status.
+type TagUpdaters struct {
+	Preservation func(ctx context.Context, code config.StatusCode, detail string) error
+	Dip          func(ctx context.Context, code config.StatusCode, detail string) error
+}
+
+// statusNamespaces holds the status namespaces of a job.
+type statusNamespaces struct {
+	preservation     string
+	preservationCode string
+	dip              string
+	dipCode          string
+}
+
+// StatusVocabulary returns the named status vocabulary, or the configured default if the name is empty.
+func (p *Preserver) StatusVocabulary(name string) (config.StatusVocabulary, error) {
+	if name == "" {
+		name = p.envConfig.Status.Vocabulary
+	}
+	return p.vocabularies.Get(name)
+}
+
+// jobStatusNamespaces returns the status namespaces for a node.
+// They are decided per job, as a node may still carry a legacy namespace, which is then used instead.
+func (p *Preserver) jobStatusNamespaces(node *models.TreeNode) statusNamespaces {
+	cfg := p.envConfig.Status
+	ns := statusNamespaces{
+		preservation:     cfg.PreservationNamespace,
+		preservationCode: cfg.PreservationCodeNamespace,
+		dip:              cfg.DipNamespace,
+		dipCode:          cfg.DipCodeNamespace,
+	}
+	if legacy := cfg.LegacyPreservationNamespace; legacy != "" && node.MetaStore[legacy] != "" {
+		logger.Warn("Using old %s preservation tag - this will be removed in the future", legacy)
+		ns.preservation = legacy
+	}
+	if legacy := cfg.LegacyDipNamespace; legacy != "" && node.MetaStore[legacy] != "" {
+		logger.Warn("Using old %s dip tag - this will be removed in the future", legacy)
+		ns.dip = legacy
+	}
+	return ns
+}
+
+// createStatusUpdater creates a status update function writing a label and code namespace pair.
+// Either namespace may be empty to disable it.
+func (p *Preserver) createStatusUpdater(userClient cells.UserClient, nodeUUID, namespace, codeNamespace string, vocabulary config.StatusVocabulary) func(context.Context, config.StatusCode, string) error {
+	return func(ctx context.Context, code config.StatusCode, detail string) error {
+		label := ""
+		if code != "" {
+			label = vocabulary.Label(code)
+			if detail != "" {
+				label += ": " + detail
+			}
+		}
+		tags := map[string]string{}
+		if namespace != "" {
+			tags[namespace] = label
+		}
+		if codeNamespace != "" {
+			tags[codeNamespace] = string(code)
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return utils.Retry(3, 2*time.Second, func() error {
+			logger.Debug("Tagging: {tag: %s, code: %s, status: %s, node: %s}", namespace, code, label, nodeUUID)
+			return p.cellsClient.UpdateTags(ctx, userClient, nodeUUID, tags)
+		}, utils.IsTransientError)
+	}
+}
@@ -0,0 +1,40 @@
+package preservation
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/penwern/curate-preservation-core/internal/processor"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// packageVersion returns the version of a package being preserved.
+// Earlier AIPs of the node are found from the results written back to it by its last successful preservation.
+// Nodes preserved before versions were recorded count their last AIP as version 1.
+func (p *Preserver) packageVersion(node *models.TreeNode) processor.PackageVersion {
+	ns := p.envConfig.Results
+	if ns.AipUUIDNamespace == "" {
+		return processor.PackageVersion{Version: 1}
+	}
+	previousAipUUID := metaString(node.MetaStore[ns.AipUUIDNamespace])
+	if previousAipUUID == "" {
+		return processor.PackageVersion{Version: 1}
+	}
+	previousVersion := 1
+	if ns.VersionNamespace != "" {
+		if v, err := strconv.Atoi(metaString(node.MetaStore[ns.VersionNamespace])); err == nil && v > 0 {
+			previousVersion = v
+		}
+	}
+	return processor.PackageVersion{Version: previousVersion + 1, PreviousAipUUID: previousAipUUID}
+}
+
+// metaString decodes a metadata value, JSON-encoded by Cells, falling back to the raw value without quotes.
+func metaString(value string) string {
+	var decoded string
+	if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+		return strings.TrimSpace(decoded)
+	}
+	return strings.Trim(value, `"\ `)
+}
@@ -0,0 +1,233 @@
+package processor
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- MD5 is only recorded alongside SHA-256, for systems still comparing it
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/premis"
+)
+
+// Checksum algorithms of PREMIS object characteristics.
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256" // Always computed
+	ChecksumSHA512 = "sha512"
+)
+
+// checksumAlgorithms maps the checksum algorithms to their PREMIS message digest algorithm name and hash.
+var checksumAlgorithms = map[string]struct {
+	name    string
+	newHash func() hash.Hash
+}{
+	ChecksumMD5:    {name: "MD5", newHash: md5.New}, // #nosec G401 -- see import
+	ChecksumSHA256: {name: "SHA-256", newHash: sha256.New},
+	ChecksumSHA512: {name: "SHA-512", newHash: sha512.New},
+}
+
+// ChecksumOptions selects the checksums computed for the PREMIS objects of the transfer files.
+type ChecksumOptions struct {
+	Algorithms []string // Besides SHA-256, e.g. md5 or sha512
+	Workers    int      // Files hashed in parallel. The number of CPUs if zero
+}
+
+// algorithms returns the selected algorithms, SHA-256 first and without duplicates.
+func (o ChecksumOptions) algorithms() ([]string, error) {
+	algorithms := []string{ChecksumSHA256}
+	for _, algorithm := range o.Algorithms {
+		if _, ok := checksumAlgorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+		}
+		duplicate := false
+		for _, a := range algorithms {
+			duplicate = duplicate || a == algorithm
+		}
+		if !duplicate {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms, nil
+}
+
+// fileCharacteristics holds the measured characteristics of a transfer file.
+type fileCharacteristics struct {
+	size   int64
+	fixity []premis.Fixity
+}
+
+// apply sets the characteristics of a PREMIS object.
+func (c fileCharacteristics) apply(object *premis.Object) {
+	size := c.size
+	object.ObjectCharacteristics.CompositionLevel = &premis.CompositionLevel{Level: 0}
+	object.ObjectCharacteristics.Fixity = c.fixity
+	object.ObjectCharacteristics.Size = &size
+}
+
+// fileMeasurer computes the size and checksums of transfer files in parallel, as their PREMIS objects are written.
+// Only the files being measured are held in memory, so packages of any size can be measured.
+type fileMeasurer struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	transferDir string
+	algorithms  []string
+	sem         chan struct{}
+	wg          sync.WaitGroup
+	mu          sync.Mutex // Serialises the calls to the write functions
+	once        sync.Once
+	err         error
+	measured    int
+}
+
+// newFileMeasurer creates a measurer of the files below a transfer directory.
+func newFileMeasurer(ctx context.Context, transferDir string, opts ChecksumOptions) (*fileMeasurer, error) {
+	algorithms, err := opts.algorithms()
+	if err != nil {
+		return nil, err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &fileMeasurer{
+		ctx:         ctx,
+		cancel:      cancel,
+		transferDir: transferDir,
+		algorithms:  algorithms,
+		sem:         make(chan struct{}, workers),
+	}, nil
+}
+
+// measure measures the file at a slash path relative to the transfer directory, e.g. data/package/file.txt,
+// then calls write with its characteristics. Regular files are measured in the background, once a worker is free.
+// Write is called with nil, right away, for anything else. Calls to write never overlap.
+func (m *fileMeasurer) measure(relPath string, write func(*fileCharacteristics) error) error {
+	if err := m.failed(); err != nil {
+		return err
+	}
+	filePath := filepath.Join(m.transferDir, filepath.FromSlash(relPath))
+	info, err := os.Lstat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return write(nil)
+	}
+
+	select {
+	case m.sem <- struct{}{}:
+	case <-m.ctx.Done():
+		return m.failed()
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() { <-m.sem }()
+		characteristics, err := measureFile(m.ctx, filePath, m.algorithms)
+		if err != nil {
+			m.fail(fmt.Errorf("error measuring %s: %w", relPath, err))
+			return
+		}
+		m.mu.Lock()
+		err = write(&characteristics)
+		if err == nil {
+			m.measured++
+		}
+		m.mu.Unlock()
+		if err != nil {
+			m.fail(err)
+		}
+	}()
+	return nil
+}
+
+// fail records the first error and stops the files being measured.
+func (m *fileMeasurer) fail(err error) {
+	m.once.Do(func() {
+		m.mu.Lock()
+		m.err = err
+		m.mu.Unlock()
+		m.cancel()
+	})
+}
+
+// failed returns the first error, or the context error once it is done.
+func (m *fileMeasurer) failed() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	return m.ctx.Err()
+}
+
+// stop stops measuring, and waits for the files being measured to stop.
+func (m *fileMeasurer) stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// wait waits for the files being measured and returns the first error.
+func (m *fileMeasurer) wait() error {
+	m.wg.Wait()
+	m.cancel()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	logger.Debug("Measured %d transfer files with %v", m.measured, m.algorithms)
+	return nil
+}
+
+// measureFile reads a file once, computing its size and every checksum.
+func measureFile(ctx context.Context, filePath string, algorithms []string) (fileCharacteristics, error) {
+	file, err := os.Open(filepath.Clean(filePath))
+	if err != nil {
+		return fileCharacteristics{}, err
+	}
+	defer func() { _ = file.Close() }()
+
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		hashes[i] = checksumAlgorithms[algorithm].newHash()
+		writers[i] = hashes[i]
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), &contextReader{ctx: ctx, r: file})
+	if err != nil {
+		return fileCharacteristics{}, err
+	}
+
+	characteristics := fileCharacteristics{size: size}
+	for i, algorithm := range algorithms {
+		characteristics.fixity = append(characteristics.fixity, premis.Fixity{
+			MessageDigestAlgorithm:  checksumAlgorithms[algorithm].name,
+			MessageDigest:           hex.EncodeToString(hashes[i].Sum(nil)),
+			MessageDigestOriginator: "Curate Preservation System",
+		})
+	}
+	return characteristics, nil
+}
+
+// contextReader stops reading once its context is done, so large files don't delay cancellation.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
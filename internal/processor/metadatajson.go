@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// metadataJSONWriter streams the metadata JSON array to a file, one entry at a time.
+// The file is only created once an entry is added.
+type metadataJSONWriter struct {
+	path    string
+	file    *os.File
+	out     *bufio.Writer
+	entries int
+}
+
+func newMetadataJSONWriter(path string) *metadataJSONWriter {
+	return &metadataJSONWriter{path: path}
+}
+
+// add appends an entry to the array.
+func (w *metadataJSONWriter) add(entry map[string]any) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshaling metadata JSON: %w", err)
+	}
+	separator := ","
+	if w.file == nil {
+		file, err := os.OpenFile(filepath.Clean(w.path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("error creating metadata JSON: %w", err)
+		}
+		w.file = file
+		w.out = bufio.NewWriter(file)
+		separator = "["
+	}
+	if _, err := w.out.WriteString(separator); err != nil {
+		return err
+	}
+	if _, err := w.out.Write(data); err != nil {
+		return err
+	}
+	w.entries++
+	return nil
+}
+
+// close completes the array. No file is written if no entry was added.
+func (w *metadataJSONWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	if _, err := w.out.WriteString("]"); err != nil {
+		return err
+	}
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	logger.Debug("Metadata JSON written to file: %s, %d entries", w.path, w.entries)
+	return nil
+}
+
+// discard removes an incomplete file. A no-op once the writer is closed.
+func (w *metadataJSONWriter) discard() {
+	if w.file == nil {
+		return
+	}
+	_ = w.file.Close()
+	if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to remove incomplete metadata JSON %s: %v", w.path, err)
+	}
+	w.file = nil
+}
@@ -0,0 +1,353 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+	"github.com/penwern/curate-preservation-core/pkg/premis"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+// PREMIS event parsing modes.
+const (
+	EventParsingStrict  = "strict"  // Fail the package on any malformed event
+	EventParsingLenient = "lenient" // Repair or drop malformed events, and report them in the transfer
+)
+
+// premisEventNamespaces are the Cells metadata namespaces holding PREMIS events in JSON.
+// TODO: Remove "usermeta-premis-data" once it is phased out
+var premisEventNamespaces = []string{"Premis", "usermeta-premis-data"}
+
+// eventReportDocument is the report of the malformed events of a package, below the transfer metadata folder.
+const eventReportDocument = "submissionDocumentation/premis-event-report.json"
+
+// Report actions on malformed events.
+const (
+	eventRepaired = "repaired"
+	eventDropped  = "dropped"
+)
+
+// EventReport lists the malformed PREMIS events of a package, and what lenient parsing did with them.
+type EventReport struct {
+	Mode   string             `json:"mode"`
+	Events []EventReportEntry `json:"events"`
+}
+
+// EventReportEntry is a malformed PREMIS event of a node.
+type EventReportEntry struct {
+	NodeUUID        string   `json:"node_uuid"`
+	NodePath        string   `json:"node_path"`
+	Namespace       string   `json:"namespace"`
+	Index           int      `json:"index"` // Position of the event in the namespace. -1 if the namespace isn't a list of events
+	EventIdentifier string   `json:"event_identifier,omitempty"`
+	Action          string   `json:"action"` // repaired or dropped
+	Problems        []string `json:"problems"`
+}
+
+// eventParser parses the PREMIS events of nodes from their Cells metadata, in strict or lenient mode.
+type eventParser struct {
+	mode   string
+	report EventReport
+}
+
+func newEventParser(mode string) *eventParser {
+	if mode == "" {
+		mode = EventParsingStrict
+	}
+	return &eventParser{mode: mode, report: EventReport{Mode: mode, Events: []EventReportEntry{}}}
+}
+
+// nodeEvents parses the PREMIS events of a node. Strict parsing fails on the first malformed event,
+// lenient parsing repairs or drops it and records it in the report.
+func (ep *eventParser) nodeEvents(node *models.TreeNode) ([]premis.Event, error) {
+	var events []premis.Event
+	for _, namespace := range premisEventNamespaces {
+		value := node.MetaStore[namespace]
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		rawEvents, err := oneOrMany(json.RawMessage(value))
+		if err != nil {
+			if err := ep.problem(node, namespace, -1, "", eventDropped, []string{fmt.Sprintf("not a list of events: %v", err)}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for i, rawEvent := range rawEvents {
+			event, problems, dropped := parseEvent(rawEvent)
+			if len(problems) == 0 {
+				events = append(events, event)
+				continue
+			}
+			action := eventRepaired
+			if dropped {
+				action = eventDropped
+			}
+			if err := ep.problem(node, namespace, i, event.EventIdentifier.IdentifierValue, action, problems); err != nil {
+				return nil, err
+			}
+			if !dropped {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+// problem fails on a malformed event in strict mode, and reports it in lenient mode.
+func (ep *eventParser) problem(node *models.TreeNode, namespace string, index int, identifier, action string, problems []string) error {
+	if ep.mode == EventParsingStrict {
+		return fmt.Errorf("invalid PREMIS event %d in %s of %s: %s", index, namespace, node.Path, strings.Join(problems, "; "))
+	}
+	logger.Warn("PREMIS event %d in %s of %s %s: %s", index, namespace, node.Path, action, strings.Join(problems, "; "))
+	ep.report.Events = append(ep.report.Events, EventReportEntry{
+		NodeUUID:        node.UUID,
+		NodePath:        node.Path,
+		Namespace:       namespace,
+		Index:           index,
+		EventIdentifier: identifier,
+		Action:          action,
+		Problems:        problems,
+	})
+	return nil
+}
+
+// writeReport writes the report to the transfer metadata folder, if any event was repaired or dropped.
+func (ep *eventParser) writeReport(metadataDir string) error {
+	if len(ep.report.Events) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(ep.report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling PREMIS event report: %w", err)
+	}
+	reportPath := filepath.Join(metadataDir, filepath.FromSlash(eventReportDocument))
+	if err := os.MkdirAll(filepath.Dir(reportPath), 0o750); err != nil {
+		return fmt.Errorf("error creating submission documentation directory: %w", err)
+	}
+	if err := os.WriteFile(reportPath, data, 0o600); err != nil {
+		return fmt.Errorf("error writing PREMIS event report: %w", err)
+	}
+	logger.Warn("Reported %d malformed PREMIS event(s) in %s", len(ep.report.Events), eventReportDocument)
+	return nil
+}
+
+// jsonEvent is a PREMIS event as stored by Cells, e.g.
+// {"event_identifier": {...}, "event_type": "...", "event_date_time": "...", "event_detail_information": {...}, "event_outcome_information": {...}}.
+// Detail, outcome and outcome detail entries may be an object or a list of objects, and links are optional.
+type jsonEvent struct {
+	EventIdentifier          json.RawMessage `json:"event_identifier"`
+	EventType                json.RawMessage `json:"event_type"`
+	EventDateTime            json.RawMessage `json:"event_date_time"`
+	EventDetailInformation   json.RawMessage `json:"event_detail_information"`
+	EventOutcomeInformation  json.RawMessage `json:"event_outcome_information"`
+	LinkingAgentIdentifiers  json.RawMessage `json:"linking_agent_identifiers"`
+	LinkingAgentIdentifier   json.RawMessage `json:"linking_agent_identifier"`
+	LinkingObjectIdentifiers json.RawMessage `json:"linking_object_identifiers"`
+	LinkingObjectIdentifier  json.RawMessage `json:"linking_object_identifier"`
+}
+
+// parseEvent parses a PREMIS event. Returns the problems found, and whether the event can't be repaired.
+// A repaired event keeps its valid parts: invalid details, outcomes and links are left out, and a missing identifier is generated.
+func parseEvent(raw json.RawMessage) (premis.Event, []string, bool) {
+	var j jsonEvent
+	if err := json.Unmarshal(raw, &j); err != nil {
+		return premis.Event{}, []string{fmt.Sprintf("not an event object: %v", err)}, true
+	}
+	var event premis.Event
+	var problems []string
+	dropped := false
+
+	// Identifier
+	var identifier struct {
+		Type  string `json:"event_identifier_type"`
+		Value string `json:"event_identifier_value"`
+	}
+	if err := decodeField(j.EventIdentifier, &identifier); err != nil || identifier.Value == "" {
+		problems = append(problems, fieldProblem("event_identifier", err))
+		identifier.Type, identifier.Value = "UUID", uuid.New().String()
+	}
+	if identifier.Type == "" {
+		identifier.Type = "UUID"
+	}
+	event.EventIdentifier = premis.EventIdentifier{IdentifierType: identifier.Type, IdentifierValue: identifier.Value}
+
+	// Type and date are required and can't be guessed
+	if err := decodeField(j.EventType, &event.EventType); err != nil || event.EventType == "" {
+		problems = append(problems, fieldProblem("event_type", err))
+		dropped = true
+	}
+	if err := decodeField(j.EventDateTime, &event.EventDateTime); err != nil || event.EventDateTime == "" {
+		problems = append(problems, fieldProblem("event_date_time", err))
+		dropped = true
+	}
+
+	// Details
+	details, err := oneOrMany(j.EventDetailInformation)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid event_detail_information: %v", err))
+	}
+	for i, rawDetail := range details {
+		var detail struct {
+			EventDetail string `json:"event_detail"`
+		}
+		if err := json.Unmarshal(rawDetail, &detail); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid event_detail_information %d: %v", i, err))
+			continue
+		}
+		event.EventDetailInformation = append(event.EventDetailInformation, premis.EventDetailInformation{EventDetail: detail.EventDetail})
+	}
+
+	// Outcomes
+	outcomes, err := oneOrMany(j.EventOutcomeInformation)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid event_outcome_information: %v", err))
+	}
+	for i, rawOutcome := range outcomes {
+		outcome, outcomeProblems := parseOutcome(rawOutcome)
+		for _, problem := range outcomeProblems {
+			problems = append(problems, fmt.Sprintf("event_outcome_information %d: %s", i, problem))
+		}
+		if outcome != nil {
+			event.EventOutcomeInformation = append(event.EventOutcomeInformation, *outcome)
+		}
+	}
+
+	// Links
+	var linkProblems []string
+	event.LinkingAgentIdentifiers, linkProblems = parseLinks(
+		"linking_agent_identifier", []json.RawMessage{j.LinkingAgentIdentifiers, j.LinkingAgentIdentifier},
+		func(linkType, value string, roles []string) premis.LinkingAgentIdentifier {
+			return premis.LinkingAgentIdentifier{IdentifierType: linkType, IdentifierValue: value, Roles: roles}
+		})
+	problems = append(problems, linkProblems...)
+	event.LinkingObjectIdentifiers, linkProblems = parseLinks(
+		"linking_object_identifier", []json.RawMessage{j.LinkingObjectIdentifiers, j.LinkingObjectIdentifier},
+		func(linkType, value string, roles []string) premis.LinkingObjectIdentifier {
+			return premis.LinkingObjectIdentifier{ObjectIdentifierType: linkType, ObjectIdentifierValue: value, Roles: roles}
+		})
+	problems = append(problems, linkProblems...)
+
+	return event, problems, dropped
+}
+
+// parseOutcome parses an outcome entry. Returns nil if it has neither an outcome nor a valid detail.
+func parseOutcome(raw json.RawMessage) (*premis.EventOutcomeInformation, []string) {
+	var j struct {
+		EventOutcome       json.RawMessage `json:"event_outcome"`
+		EventOutcomeDetail json.RawMessage `json:"event_outcome_detail"`
+	}
+	if err := json.Unmarshal(raw, &j); err != nil {
+		return nil, []string{fmt.Sprintf("not an object: %v", err)}
+	}
+	var problems []string
+	var outcome premis.EventOutcomeInformation
+	if err := decodeField(j.EventOutcome, &outcome.EventOutcome); err != nil {
+		problems = append(problems, fieldProblem("event_outcome", err))
+	}
+	details, err := oneOrMany(j.EventOutcomeDetail)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("invalid event_outcome_detail: %v", err))
+	}
+	for i, rawDetail := range details {
+		var detail struct {
+			Note *string `json:"event_outcome_detail_note"`
+		}
+		// Outcome details are only valid with a note
+		if err := json.Unmarshal(rawDetail, &detail); err != nil || detail.Note == nil {
+			problems = append(problems, fmt.Sprintf("invalid event_outcome_detail %d: %s", i, fieldProblem("event_outcome_detail_note", err)))
+			continue
+		}
+		outcome.EventOutcomeDetails = append(outcome.EventOutcomeDetails, premis.EventOutcomeDetail{EventOutcomeDetailNote: *detail.Note})
+	}
+	if outcome.EventOutcome == "" && len(outcome.EventOutcomeDetails) == 0 {
+		if len(problems) == 0 {
+			return nil, []string{"no event_outcome or event_outcome_detail"}
+		}
+		return nil, problems
+	}
+	return &outcome, problems
+}
+
+// parseLinks parses the agent or object links of an event, from the plural and singular fields.
+// Links lacking a type or value are left out.
+func parseLinks[T any](field string, fields []json.RawMessage, newLink func(linkType, value string, roles []string) T) ([]T, []string) {
+	var links []T
+	var problems []string
+	for _, rawField := range fields {
+		rawLinks, err := oneOrMany(rawField)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %v", field, err))
+			continue
+		}
+		for i, rawLink := range rawLinks {
+			var link map[string]json.RawMessage
+			if err := json.Unmarshal(rawLink, &link); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid %s %d: %v", field, i, err))
+				continue
+			}
+			var linkType, value string
+			typeErr := decodeField(link[field+"_type"], &linkType)
+			valueErr := decodeField(link[field+"_value"], &value)
+			if typeErr != nil || valueErr != nil || linkType == "" || value == "" {
+				problems = append(problems, fmt.Sprintf("invalid %s %d: type and value are required", field, i))
+				continue
+			}
+			// Roles, e.g. linking_agent_role, may be a string or a list of strings
+			roleField := strings.TrimSuffix(field, "_identifier") + "_role"
+			var roles []string
+			if rawRoles, err := oneOrMany(link[roleField]); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid %s %d: %s", field, i, fieldProblem(roleField, err)))
+			} else {
+				for _, rawRole := range rawRoles {
+					var role string
+					if err := json.Unmarshal(rawRole, &role); err != nil || role == "" {
+						problems = append(problems, fmt.Sprintf("invalid %s %d: %s", field, i, fieldProblem(roleField, err)))
+						continue
+					}
+					roles = append(roles, role)
+				}
+			}
+			links = append(links, newLink(linkType, value, roles))
+		}
+	}
+	return links, problems
+}
+
+// oneOrMany returns the entries of a JSON list, or a single entry for any other value. Absent and null values have no entries.
+func oneOrMany(raw json.RawMessage) ([]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] != '[' {
+		return []json.RawMessage{raw}, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// decodeField decodes an optional field. Absent and null fields leave v unchanged.
+func decodeField(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// fieldProblem describes a missing or invalid field.
+func fieldProblem(field string, err error) string {
+	if err != nil {
+		return fmt.Sprintf("invalid %s: %v", field, err)
+	}
+	return "missing " + field
+}
@@ -0,0 +1,306 @@
+package processor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/penwern/curate-preservation-core/pkg/premis"
+	"github.com/pydio/cells-sdk-go/v4/models"
+)
+
+func TestOneOrMany(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "absent", raw: "", want: nil},
+		{name: "null", raw: " null ", want: nil},
+		{name: "object", raw: `{"a":1}`, want: []string{`{"a":1}`}},
+		{name: "string", raw: `"note"`, want: []string{`"note"`}},
+		{name: "list", raw: `[{"a":1}, "b"]`, want: []string{`{"a":1}`, `"b"`}},
+		{name: "empty list", raw: `[]`, want: []string{}},
+		{name: "malformed list", raw: `[{"a":1},`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := oneOrMany(json.RawMessage(tc.raw))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("oneOrMany(%q): got error %v, want error %t", tc.raw, err, tc.wantErr)
+			}
+			if len(entries) != len(tc.want) {
+				t.Fatalf("oneOrMany(%q): got %d entries, want %d", tc.raw, len(entries), len(tc.want))
+			}
+			for i, entry := range entries {
+				if string(entry) != tc.want[i] {
+					t.Errorf("oneOrMany(%q) entry %d: got %s, want %s", tc.raw, i, entry, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseOutcome(t *testing.T) {
+	cases := []struct {
+		name     string
+		raw      string
+		want     *premis.EventOutcomeInformation
+		problems []string
+	}{
+		{
+			name: "object detail",
+			raw:  `{"event_outcome": "success", "event_outcome_detail": {"event_outcome_detail_note": "ok"}}`,
+			want: &premis.EventOutcomeInformation{EventOutcome: "success", EventOutcomeDetails: []premis.EventOutcomeDetail{{EventOutcomeDetailNote: "ok"}}},
+		},
+		{
+			name: "list of details",
+			raw:  `{"event_outcome": "success", "event_outcome_detail": [{"event_outcome_detail_note": "a"}, {"event_outcome_detail_note": ""}]}`,
+			want: &premis.EventOutcomeInformation{EventOutcome: "success", EventOutcomeDetails: []premis.EventOutcomeDetail{{EventOutcomeDetailNote: "a"}, {}}},
+		},
+		{name: "outcome only", raw: `{"event_outcome": "failure"}`, want: &premis.EventOutcomeInformation{EventOutcome: "failure"}},
+		{
+			name:     "detail without note",
+			raw:      `{"event_outcome": "success", "event_outcome_detail": [{"event_outcome_detail_note": "a"}, {"other": "b"}]}`,
+			want:     &premis.EventOutcomeInformation{EventOutcome: "success", EventOutcomeDetails: []premis.EventOutcomeDetail{{EventOutcomeDetailNote: "a"}}},
+			problems: []string{"invalid event_outcome_detail 1: missing event_outcome_detail_note"},
+		},
+		{
+			name:     "only a detail without note",
+			raw:      `{"event_outcome_detail": {}}`,
+			problems: []string{"invalid event_outcome_detail 0: missing event_outcome_detail_note"},
+		},
+		{name: "empty", raw: `{}`, problems: []string{"no event_outcome or event_outcome_detail"}},
+		{name: "not an object", raw: `"success"`, problems: []string{"not an object"}},
+		{
+			name:     "invalid outcome type",
+			raw:      `{"event_outcome": 1, "event_outcome_detail": {"event_outcome_detail_note": "ok"}}`,
+			want:     &premis.EventOutcomeInformation{EventOutcomeDetails: []premis.EventOutcomeDetail{{EventOutcomeDetailNote: "ok"}}},
+			problems: []string{"invalid event_outcome"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			outcome, problems := parseOutcome(json.RawMessage(tc.raw))
+			assertProblems(t, problems, tc.problems)
+			if tc.want == nil {
+				if outcome != nil {
+					t.Fatalf("outcome: got %+v, want none", outcome)
+				}
+				return
+			}
+			if outcome == nil {
+				t.Fatalf("outcome: got none, want %+v", tc.want)
+			}
+			if outcome.EventOutcome != tc.want.EventOutcome || len(outcome.EventOutcomeDetails) != len(tc.want.EventOutcomeDetails) {
+				t.Fatalf("outcome: got %+v, want %+v", outcome, tc.want)
+			}
+			for i, detail := range outcome.EventOutcomeDetails {
+				if detail != tc.want.EventOutcomeDetails[i] {
+					t.Errorf("outcome detail %d: got %+v, want %+v", i, detail, tc.want.EventOutcomeDetails[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	newAgent := func(linkType, value string, roles []string) premis.LinkingAgentIdentifier {
+		return premis.LinkingAgentIdentifier{IdentifierType: linkType, IdentifierValue: value, Roles: roles}
+	}
+	cases := []struct {
+		name     string
+		plural   string
+		singular string
+		want     []premis.LinkingAgentIdentifier
+		problems []string
+	}{
+		{name: "absent"},
+		{
+			name:   "object",
+			plural: `{"linking_agent_identifier_type": "Cells User UUID", "linking_agent_identifier_value": "u1", "linking_agent_role": "executing program"}`,
+			want:   []premis.LinkingAgentIdentifier{{IdentifierType: "Cells User UUID", IdentifierValue: "u1", Roles: []string{"executing program"}}},
+		},
+		{
+			name:     "plural and singular fields",
+			plural:   `[{"linking_agent_identifier_type": "t", "linking_agent_identifier_value": "a", "linking_agent_role": ["r1", "r2"]}]`,
+			singular: `{"linking_agent_identifier_type": "t", "linking_agent_identifier_value": "b"}`,
+			want: []premis.LinkingAgentIdentifier{
+				{IdentifierType: "t", IdentifierValue: "a", Roles: []string{"r1", "r2"}},
+				{IdentifierType: "t", IdentifierValue: "b"},
+			},
+		},
+		{
+			name:     "missing value",
+			plural:   `[{"linking_agent_identifier_type": "t"}, {"linking_agent_identifier_type": "t", "linking_agent_identifier_value": "a"}]`,
+			want:     []premis.LinkingAgentIdentifier{{IdentifierType: "t", IdentifierValue: "a"}},
+			problems: []string{"invalid linking_agent_identifier 0: type and value are required"},
+		},
+		{
+			name:     "invalid role",
+			plural:   `{"linking_agent_identifier_type": "t", "linking_agent_identifier_value": "a", "linking_agent_role": ["r1", 2]}`,
+			want:     []premis.LinkingAgentIdentifier{{IdentifierType: "t", IdentifierValue: "a", Roles: []string{"r1"}}},
+			problems: []string{"invalid linking_agent_identifier 0: invalid linking_agent_role"},
+		},
+		{
+			name:     "not an object",
+			singular: `["a"]`,
+			problems: []string{"invalid linking_agent_identifier 0"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			links, problems := parseLinks("linking_agent_identifier", []json.RawMessage{json.RawMessage(tc.plural), json.RawMessage(tc.singular)}, newAgent)
+			assertProblems(t, problems, tc.problems)
+			if len(links) != len(tc.want) {
+				t.Fatalf("links: got %+v, want %+v", links, tc.want)
+			}
+			for i, link := range links {
+				want := tc.want[i]
+				if link.IdentifierType != want.IdentifierType || link.IdentifierValue != want.IdentifierValue || strings.Join(link.Roles, ",") != strings.Join(want.Roles, ",") {
+					t.Errorf("link %d: got %+v, want %+v", i, link, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	cases := []struct {
+		name       string
+		raw        string
+		problems   []string
+		dropped    bool
+		identifier string // Expected identifier value, any if empty
+		details    int
+		outcomes   int
+		agents     int
+	}{
+		{
+			name: "complete",
+			raw: `{"event_identifier": {"event_identifier_type": "UUID", "event_identifier_value": "e1"}, "event_type": "ingestion", "event_date_time": "2025-01-01T00:00:00Z",
+				"event_detail_information": {"event_detail": "uploaded"},
+				"event_outcome_information": [{"event_outcome": "success"}],
+				"linking_agent_identifier": {"linking_agent_identifier_type": "t", "linking_agent_identifier_value": "a"}}`,
+			identifier: "e1",
+			details:    1,
+			outcomes:   1,
+			agents:     1,
+		},
+		{
+			name:     "missing identifier",
+			raw:      `{"event_type": "ingestion", "event_date_time": "2025-01-01T00:00:00Z"}`,
+			problems: []string{"missing event_identifier"},
+		},
+		{
+			name:       "identifier without type",
+			raw:        `{"event_identifier": {"event_identifier_value": "e1"}, "event_type": "ingestion", "event_date_time": "2025-01-01T00:00:00Z"}`,
+			identifier: "e1",
+		},
+		{
+			name:       "outcome detail without note",
+			raw:        `{"event_identifier": {"event_identifier_value": "e1"}, "event_type": "ingestion", "event_date_time": "2025-01-01T00:00:00Z", "event_outcome_information": {"event_outcome": "success", "event_outcome_detail": {}}}`,
+			problems:   []string{"event_outcome_information 0: invalid event_outcome_detail 0: missing event_outcome_detail_note"},
+			identifier: "e1",
+			outcomes:   1,
+		},
+		{
+			name:       "invalid details",
+			raw:        `{"event_identifier": {"event_identifier_value": "e1"}, "event_type": "ingestion", "event_date_time": "2025-01-01T00:00:00Z", "event_detail_information": [{"event_detail": "a"}, "b"]}`,
+			problems:   []string{"invalid event_detail_information 1"},
+			identifier: "e1",
+			details:    1,
+		},
+		{
+			name:     "missing type",
+			raw:      `{"event_identifier": {"event_identifier_value": "e1"}, "event_date_time": "2025-01-01T00:00:00Z"}`,
+			problems: []string{"missing event_type"},
+			dropped:  true,
+		},
+		{
+			name:     "invalid date",
+			raw:      `{"event_identifier": {"event_identifier_value": "e1"}, "event_type": "ingestion", "event_date_time": 20250101}`,
+			problems: []string{"invalid event_date_time"},
+			dropped:  true,
+		},
+		{name: "not an object", raw: `"ingestion"`, problems: []string{"not an event object"}, dropped: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, problems, dropped := parseEvent(json.RawMessage(tc.raw))
+			assertProblems(t, problems, tc.problems)
+			if dropped != tc.dropped {
+				t.Fatalf("dropped: got %t, want %t", dropped, tc.dropped)
+			}
+			if dropped {
+				return
+			}
+			if event.EventIdentifier.IdentifierType != "UUID" || event.EventIdentifier.IdentifierValue == "" {
+				t.Errorf("identifier: got %+v, want a UUID identifier", event.EventIdentifier)
+			}
+			if tc.identifier != "" && event.EventIdentifier.IdentifierValue != tc.identifier {
+				t.Errorf("identifier: got %q, want %q", event.EventIdentifier.IdentifierValue, tc.identifier)
+			}
+			if len(event.EventDetailInformation) != tc.details || len(event.EventOutcomeInformation) != tc.outcomes || len(event.LinkingAgentIdentifiers) != tc.agents {
+				t.Errorf("event: got %d details, %d outcomes and %d agents, want %d, %d and %d",
+					len(event.EventDetailInformation), len(event.EventOutcomeInformation), len(event.LinkingAgentIdentifiers), tc.details, tc.outcomes, tc.agents)
+			}
+		})
+	}
+}
+
+func TestEventParserModes(t *testing.T) {
+	node := &models.TreeNode{UUID: "n1", Path: "personal/alice/report.pdf", MetaStore: map[string]string{
+		"Premis": `[
+			{"event_identifier": {"event_identifier_value": "e1"}, "event_type": "ingestion", "event_date_time": "2025-01-01T00:00:00Z"},
+			{"event_identifier": {"event_identifier_value": "e2"}, "event_date_time": "2025-01-01T00:00:00Z"},
+			{"event_type": "validation", "event_date_time": "2025-01-01T00:00:00Z"}
+		]`,
+		"usermeta-premis-data": `[`,
+	}}
+
+	strict := newEventParser("")
+	if _, err := strict.nodeEvents(node); err == nil || !strings.Contains(err.Error(), "invalid PREMIS event 1 in Premis") {
+		t.Fatalf("strict: got %v, want the event without type to fail the node", err)
+	}
+
+	lenient := newEventParser(EventParsingLenient)
+	events, err := lenient.nodeEvents(node)
+	if err != nil {
+		t.Fatalf("lenient: %v", err)
+	}
+	if len(events) != 2 || events[0].EventIdentifier.IdentifierValue != "e1" || events[1].EventType != "validation" {
+		t.Fatalf("lenient: got %+v, want e1 and the repaired validation event", events)
+	}
+	want := []struct {
+		namespace string
+		index     int
+		action    string
+	}{
+		{"Premis", 1, eventDropped},
+		{"Premis", 2, eventRepaired},
+		{"usermeta-premis-data", -1, eventDropped},
+	}
+	if len(lenient.report.Events) != len(want) {
+		t.Fatalf("report: got %+v, want %d entries", lenient.report.Events, len(want))
+	}
+	for i, entry := range lenient.report.Events {
+		if entry.NodeUUID != node.UUID || entry.Namespace != want[i].namespace || entry.Index != want[i].index || entry.Action != want[i].action {
+			t.Errorf("report entry %d: got %+v, want %+v", i, entry, want[i])
+		}
+	}
+}
+
+// assertProblems checks each problem contains the wanted problem at the same position.
+func assertProblems(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("problems: got %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("problem %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -4,12 +4,14 @@ package processor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/penwern/curate-preservation-core/pkg/logger"
 	"github.com/penwern/curate-preservation-core/pkg/premis"
 	"github.com/penwern/curate-preservation-core/pkg/utils"
@@ -17,11 +19,38 @@ import (
 	"github.com/pydio/cells-sdk-go/v4/models"
 )
 
+// NodeWalker calls fn for each Cells node below the package root node, recursively.
+type NodeWalker func(fn func(node *models.TreeNode) error) error
+
+// NodeEvents returns the PREMIS events recorded by the service for a node while preserving it, e.g. fixity checks.
+// The events are linked to the node object and the preservation system agent.
+type NodeEvents func(node *models.TreeNode) []premis.Event
+
+// PackageVersion is the version of a package being preserved, and the AIP it was last preserved to.
+type PackageVersion struct {
+	Version         int    // 1 for the first preservation
+	PreviousAipUUID string // Empty for the first preservation
+}
+
+// PremisOptions configures the PREMIS XML of the transfer.
+type PremisOptions struct {
+	Checksums    ChecksumOptions
+	EventParsing string // strict or lenient. Strict if empty
+}
+
 // PreprocessPackage prepares a package for preservation submission and returns the path to the preprocessed package path.
 // It MOVES the package to a new directory and extracts it if it's a ZIP file.
 // It also creates the metadata and premis files.
-// NodesCollection is the collection of cells nodes for the package, using the cells SDK.
-func PreprocessPackage(ctx context.Context, packagePath, preprocessingDir string, nodesCollection *models.RestNodesCollection, userData *models.IdmUser, organization string) (string, error) {
+// Root is the cells node of the package and walkNodes walks its descendants, using the cells SDK.
+// The metadata files are written node by node, so packages of any size are never held in memory.
+// NodeEvents, if set, adds events to the PREMIS events found in the node metadata.
+// The root object always records the service events, so the PREMIS XML is always written. An extracted ZIP records an unpacking event.
+// The files extracted from a ZIP, which have no Cells node, get objects of their own, included in the package object by the unpacking event.
+// A re-preserved package's root object records a re-ingestion event and its relationship to the previous AIP.
+// The objects of the files record their size and checksums, computed in parallel from the transfer data as they are written.
+// Malformed PREMIS events in the node metadata fail the package in strict parsing, and are repaired or dropped in lenient parsing,
+// which then reports them in the transfer submission documentation.
+func PreprocessPackage(ctx context.Context, packagePath, preprocessingDir string, root *models.TreeNode, walkNodes NodeWalker, nodeEvents NodeEvents, packageVersion PackageVersion, premisOptions PremisOptions, userData *models.IdmUser, organization string) (string, error) {
 	packageName := filepath.Base(strings.TrimSuffix(packagePath, filepath.Ext(packagePath)))
 
 	// Create transfer package directory
@@ -49,13 +78,20 @@ func PreprocessPackage(ctx context.Context, packagePath, preprocessingDir string
 	}
 
 	// TODO: Support other file types - e.g. tar, gzip, etc.
+	var unpacking *premis.Event
+	var extractDir string
 	switch {
 	case fileInfo.Mode().IsRegular() && utils.IsZipFile(packagePath) && utils.IsActualArchive(packagePath):
 		// If it's a ZIP file, extract it
 		logger.Debug("Extracting ZIP file %s", packagePath)
-		if _, err := utils.ExtractZip(ctx, packagePath, filepath.Join(dataDir, packageName)); err != nil {
+		extractDir = filepath.Join(dataDir, packageName)
+		if _, err := utils.ExtractZip(ctx, packagePath, extractDir); err != nil {
 			return "", fmt.Errorf("error extracting zip: %w", err)
 		}
+		unpacking, err = unpackingPremisEvent(filepath.Base(packagePath), extractDir)
+		if err != nil {
+			return "", err
+		}
 	case fileInfo.Mode().IsRegular():
 		// If it's a regular file, move it
 		logger.Debug("Moving file %s to %s", packagePath, dataDir)
@@ -84,46 +120,28 @@ func PreprocessPackage(ctx context.Context, packagePath, preprocessingDir string
 		return "", fmt.Errorf("error creating metadata directory: %w", err)
 	}
 
-	// Construct Metadata
-	premisObj, metadataArray, err := constructMetadataFromNodesCollection(nodesCollection, userData, organization)
+	// Measure the transfer files as their PREMIS objects are written
+	measurer, err := newFileMeasurer(ctx, transferDir, premisOptions.Checksums)
 	if err != nil {
-		return "", fmt.Errorf("error constructing PREMIS XML: %w", err)
+		return "", fmt.Errorf("error measuring transfer files: %w", err)
 	}
 
-	if len(premisObj.Objects) != 0 {
-		// Validate PREMIS XML
-		if err = premis.ValidatePremis(premisObj); err != nil {
-			return "", fmt.Errorf("error validating PREMIS XML: %w", err)
-		}
-		if err = premis.WritePremis(premisObj, filepath.Join(metadataDir, "premis.xml")); err != nil {
-			return "", fmt.Errorf("error writing PREMIS XML: %w", err)
-		}
+	// Construct Metadata
+	parser := newEventParser(premisOptions.EventParsing)
+	if err := writeMetadataFromNodes(ctx, metadataDir, root, walkNodes, nodeEvents, parser, unpacking, extractDir, packageVersion, measurer, userData, organization); err != nil {
+		return "", fmt.Errorf("error constructing metadata: %w", err)
 	}
-
-	// Write metadata JSON if its not empty
-	if len(metadataArray) > 0 {
-		metadataJSON, err := json.Marshal(metadataArray)
-		if err != nil {
-			return "", fmt.Errorf("error marshaling metadata JSON array: %w", err)
-		}
-		if err = os.WriteFile(filepath.Join(metadataDir, "metadata.json"), metadataJSON, 0o600); err != nil {
-			return "", fmt.Errorf("error writing metadata JSON: %w", err)
-		}
+	if err := parser.writeReport(metadataDir); err != nil {
+		return "", err
 	}
 
 	return transferDir, nil
 }
 
-// Constructs the PREMIS XML from the nodes in the package
+// Writes the PREMIS XML and metadata JSON from the nodes in the package
 // This function is a bit janky as it contructs Premis, Dublin Core and ISAD(G) metadata to avoid looping through the nodes repeatedly
-func constructMetadataFromNodesCollection(nodesCollection *models.RestNodesCollection, userData *models.IdmUser, organization string) (premis.Premis, []map[string]any, error) {
-	// Initialize the PREMIS XML
-	premisRoot := premis.Premis{
-		XMLNS:   "http://www.loc.gov/premis/v3",
-		XSI:     "http://www.w3.org/2001/XMLSchema-instance",
-		Version: "3.0",
-		Schema:  "http://www.loc.gov/premis/v3 https://www.loc.gov/standards/premis/premis.xsd",
-	}
+// The files extracted from a ZIP package to extractDir have no Cells node, so their objects are written from the extracted files.
+func writeMetadataFromNodes(ctx context.Context, metadataDir string, root *models.TreeNode, walkNodes NodeWalker, nodeEvents NodeEvents, parser *eventParser, unpacking *premis.Event, extractDir string, packageVersion PackageVersion, measurer *fileMeasurer, userData *models.IdmUser, organization string) error {
 	premisAgents := []premis.Agent{
 		{
 			AgentIdentifier: premis.AgentIdentifier{
@@ -155,45 +173,113 @@ func constructMetadataFromNodesCollection(nodesCollection *models.RestNodesColle
 		})
 	}
 
+	// Initialize the PREMIS XML. Objects and events are streamed to the file
+	premisWriter, err := premis.NewWriter(filepath.Join(metadataDir, "premis.xml"), premis.Premis{
+		XMLNS:   "http://www.loc.gov/premis/v3",
+		XSI:     "http://www.w3.org/2001/XMLSchema-instance",
+		Version: "3.0",
+		Schema:  "http://www.loc.gov/premis/v3 https://www.loc.gov/standards/premis/premis.xsd",
+		Agents:  premisAgents,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating PREMIS XML writer: %w", err)
+	}
+	defer premisWriter.Discard()
+	// Stop measuring files before the PREMIS XML is discarded
+	defer measurer.stop()
+
 	// Initialize the Metadata Json Array (Dublin Core and ISAD(G))
-	metadataArray := make([]map[string]any, 0)
+	metadataWriter := newMetadataJSONWriter(filepath.Join(metadataDir, "metadata.json"))
+	defer metadataWriter.discard()
 
-	nodePrefix := filepath.Dir(nodesCollection.Parent.Path)
-	// For each node in the package
-	for _, node := range append(nodesCollection.Children, nodesCollection.Parent) {
+	nodePrefix := filepath.Dir(strings.Trim(root.Path, "/"))
+	addNode := func(node *models.TreeNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		objectPath := strings.Replace(node.Path, nodePrefix, "objects/data", 1)
+		objectPath := strings.Replace(strings.Trim(node.Path, "/"), nodePrefix, "objects/data", 1)
 
 		// Create the PREMIS object
-		premisObject, premisEvents, err := constructPremisObjectsFromNode(premisAgents, node, objectPath)
+		premisObject, premisEvents, err := constructPremisObjectsFromNode(parser, premisAgents, node, objectPath)
 		if err != nil {
-			return premis.Premis{}, []map[string]any{}, fmt.Errorf("error constructing PREMIS object: %w", err)
+			return fmt.Errorf("error constructing PREMIS object: %w", err)
 		}
-		if premisEvents != nil {
-			// Append PREMIS object to PREMIS XML
-			premisRoot.Objects = append(premisRoot.Objects, premisObject)
-			// Append PREMIS events to PREMIS XML
-			premisRoot.Events = append(premisRoot.Events, premisEvents...)
+		if len(premisEvents) == 0 {
+			premisObject = newPremisObject(node, objectPath)
+		}
+		// Append the events recorded by the service while preserving the node
+		var serviceEvents []premis.Event
+		if nodeEvents != nil {
+			serviceEvents = nodeEvents(node)
+		}
+		if node.UUID == root.UUID && unpacking != nil {
+			serviceEvents = append(serviceEvents, *unpacking)
+		}
+		for _, premisEvent := range serviceEvents {
+			premisEvents = append(premisEvents, linkPremisEvent(&premisObject, premisAgents[:1], premisEvent))
+		}
+		// Relate a re-preserved package to its previous AIP
+		if node.UUID == root.UUID && packageVersion.PreviousAipUUID != "" {
+			premisEvents = append(premisEvents, reingestPremisEvent(&premisObject, premisAgents, packageVersion))
+		}
+		// Record the size and checksums of files, then write the PREMIS object and events to PREMIS XML
+		err = measurer.measure(strings.TrimPrefix(objectPath, "objects/"), func(file *fileCharacteristics) error {
+			if file != nil {
+				file.apply(&premisObject)
+			}
+			if len(premisEvents) == 0 && file == nil {
+				return nil
+			}
+			if err := premisWriter.Add(premisObject, premisEvents); err != nil {
+				return fmt.Errorf("error writing PREMIS XML: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
 		// Create this node's metadata JSON
 		metadataMap := constructMetadataJSONFromNode(node, objectPath)
 		// If the metadata JSON is not empty, append it to the array
 		if metadataMap != nil {
-			metadataArray = append(metadataArray, metadataMap)
+			if err := metadataWriter.add(metadataMap); err != nil {
+				return fmt.Errorf("error writing metadata JSON: %w", err)
+			}
+		}
+		return nil
+	}
+
+	// For each node in the package
+	if err := walkNodes(addNode); err != nil {
+		return err
+	}
+	if extractDir != "" && unpacking != nil {
+		if err := addExtractedFiles(ctx, extractDir, measurer, root, *unpacking, premisWriter); err != nil {
+			return err
 		}
 	}
-	// Append PREMIS agents to PREMIS XML
-	if len(premisRoot.Events) != 0 {
-		premisRoot.Agents = append(premisRoot.Agents, premisAgents...)
+	if err := addNode(root); err != nil {
+		return err
+	}
+	if err := measurer.wait(); err != nil {
+		return fmt.Errorf("error measuring transfer files: %w", err)
 	}
 
-	return premisRoot, metadataArray, nil
+	// Append PREMIS events and agents to PREMIS XML
+	if err := premisWriter.Close(); err != nil {
+		return fmt.Errorf("error writing PREMIS XML: %w", err)
+	}
+	if err := metadataWriter.close(); err != nil {
+		return fmt.Errorf("error writing metadata JSON: %w", err)
+	}
+	return nil
 }
 
-func constructPremisObjectsFromNode(premisAgents []premis.Agent, node *models.TreeNode, objectPath string) (premis.Object, []premis.Event, error) {
-	// Create the PREMIS object
-	premisObject := premis.Object{
+// newPremisObject creates the PREMIS object of a node, without events.
+func newPremisObject(node *models.TreeNode, objectPath string) premis.Object {
+	return premis.Object{
 		XSIType: "premis:file",
 		ObjectIdentifier: premis.ObjectIdentifier{
 			IdentifierType:  "UUID",
@@ -208,120 +294,171 @@ func constructPremisObjectsFromNode(premisAgents []premis.Agent, node *models.Tr
 		},
 		OriginalName: objectPath,
 	}
+}
 
-	// Get the json PREMIS events from the cells PREMIS metadata
-	nodePremisMetaStore := node.MetaStore["Premis"]
-	jsonPremisEvents := make([]map[string]any, 0)
-	if nodePremisMetaStore != "" {
-		if err := json.Unmarshal([]byte(nodePremisMetaStore), &jsonPremisEvents); err != nil {
-			return premis.Object{}, nil, fmt.Errorf("error unmarshalling premis json array: %v", err)
-		}
-	}
-
-	// Get the json PREMIS events from the cells PREMIS metadata
-	// TODO: Remove this once "usermeta-premis-data" is phased out
-	nodePremisMetaStoreOther := node.MetaStore["usermeta-premis-data"]
-	var jsonPremisEventsOther []map[string]any
-	if nodePremisMetaStoreOther != "" {
-		if err := json.Unmarshal([]byte(nodePremisMetaStoreOther), &jsonPremisEventsOther); err != nil {
-			return premis.Object{}, nil, fmt.Errorf("error unmarshalling premis json array: %v", err)
+// addExtractedFiles writes the PREMIS objects of the files extracted from a ZIP package to extractDir, with their size and checksums.
+// The files have no Cells node, so each object gets a new UUID and is related to the package object by the unpacking event.
+func addExtractedFiles(ctx context.Context, extractDir string, measurer *fileMeasurer, root *models.TreeNode, unpacking premis.Event, premisWriter *premis.Writer) error {
+	return filepath.WalkDir(extractDir, func(filePath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error listing extracted files: %w", err)
 		}
-	}
-
-	// Combine the json PREMIS events
-	jsonPremisEvents = append(jsonPremisEvents, jsonPremisEventsOther...)
-
-	// If there are no PREMIS events, return empty object and events
-	if len(jsonPremisEvents) == 0 {
-		return premis.Object{}, nil, nil
-	}
-
-	// Create the PREMIS events
-	premisEvents := make([]premis.Event, len(jsonPremisEvents))
-	// For each event in the json array
-	for i, premisEventJSON := range jsonPremisEvents {
-		// Create the PREMIS event
-		eventIdentifier, ok := premisEventJSON["event_identifier"].(map[string]any)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_identifier format")
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-
-		identifierType, ok := eventIdentifier["event_identifier_type"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_identifier_type format")
+		if !entry.Type().IsRegular() {
+			return nil
 		}
-
-		identifierValue, ok := eventIdentifier["event_identifier_value"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_identifier_value format")
+		relPath, err := filepath.Rel(measurer.transferDir, filePath)
+		if err != nil {
+			return fmt.Errorf("error locating extracted file %s: %w", filePath, err)
 		}
+		relPath = filepath.ToSlash(relPath)
 
-		eventType, ok := premisEventJSON["event_type"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_type format")
+		premisObject := premis.Object{
+			XSIType: "premis:file",
+			ObjectIdentifier: premis.ObjectIdentifier{
+				IdentifierType:  "UUID",
+				IdentifierValue: uuid.New().String(),
+			},
+			ObjectCharacteristics: premis.ObjectCharacteristics{
+				Format: premis.Format{
+					FormatDesignation: premis.FormatDesignation{
+						FormatName: extensionMimeType(filePath),
+					},
+				},
+			},
+			OriginalName: "objects/" + relPath,
+			Relationships: []premis.Relationship{{
+				RelationshipType:    "structural",
+				RelationshipSubType: "is included in",
+				RelatedObjectIdentifiers: []premis.RelatedObjectIdentifier{{
+					IdentifierType:  "UUID",
+					IdentifierValue: root.UUID,
+				}},
+				RelatedEventIdentifiers: []premis.RelatedEventIdentifier{premis.RelatedEventIdentifier(unpacking.EventIdentifier)},
+			}},
 		}
+		return measurer.measure(relPath, func(file *fileCharacteristics) error {
+			if file == nil {
+				return nil
+			}
+			file.apply(&premisObject)
+			if err := premisWriter.Add(premisObject, nil); err != nil {
+				return fmt.Errorf("error writing PREMIS XML: %w", err)
+			}
+			return nil
+		})
+	})
+}
 
-		eventDateTime, ok := premisEventJSON["event_date_time"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_date_time format")
-		}
+// extensionMimeType returns the MIME type of a file from its extension, without parameters, or an empty string if it's unknown.
+func extensionMimeType(filePath string) string {
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(filePath)), ";")
+	return strings.TrimSpace(mimeType)
+}
 
-		eventDetailInfo, ok := premisEventJSON["event_detail_information"].(map[string]any)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_detail_information format")
+// linkPremisEvent links an event to every agent and the object to the event.
+func linkPremisEvent(premisObject *premis.Object, premisAgents []premis.Agent, premisEvent premis.Event) premis.Event {
+	// Append linking agent identifier to event for every agent not linked yet
+	for _, premisAgent := range premisAgents {
+		linked := false
+		for _, link := range premisEvent.LinkingAgentIdentifiers {
+			linked = linked || (link.IdentifierType == premisAgent.AgentIdentifier.IdentifierType && link.IdentifierValue == premisAgent.AgentIdentifier.IdentifierValue)
 		}
-
-		eventDetail, ok := eventDetailInfo["event_detail"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_detail format")
+		if !linked {
+			premisEvent.LinkingAgentIdentifiers = append(premisEvent.LinkingAgentIdentifiers, premis.LinkingAgentIdentifier{
+				IdentifierType:  premisAgent.AgentIdentifier.IdentifierType,
+				IdentifierValue: premisAgent.AgentIdentifier.IdentifierValue,
+			})
 		}
+	}
+	// Append linking event identifier to object
+	premisObject.LinkingEventIdentifiers = append(premisObject.LinkingEventIdentifiers, premis.LinkingEventIdentifier(premisEvent.EventIdentifier))
+	return premisEvent
+}
 
-		eventOutcomeInfo, ok := premisEventJSON["event_outcome_information"].(map[string]any)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_outcome_information format")
+// unpackingPremisEvent creates the unpacking event of a ZIP package extracted to a directory.
+func unpackingPremisEvent(zipName, extractDir string) (*premis.Event, error) {
+	files := 0
+	err := filepath.WalkDir(extractDir, func(_ string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-
-		eventOutcome, ok := eventOutcomeInfo["event_outcome"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_outcome format")
+		if entry.Type().IsRegular() {
+			files++
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error counting extracted files: %w", err)
+	}
+	return &premis.Event{
+		EventIdentifier: premis.EventIdentifier{
+			IdentifierType:  "UUID",
+			IdentifierValue: uuid.New().String(),
+		},
+		EventType:     "unpacking",
+		EventDateTime: time.Now().UTC().Format(time.RFC3339),
+		EventDetailInformation: []premis.EventDetailInformation{{
+			EventDetail: "Unpacking of the ZIP package " + zipName,
+		}},
+		EventOutcomeInformation: []premis.EventOutcomeInformation{{
+			EventOutcome: "success",
+			EventOutcomeDetails: []premis.EventOutcomeDetail{{
+				EventOutcomeDetailNote: fmt.Sprintf("%d file(s) extracted", files),
+			}},
+		}},
+	}, nil
+}
 
-		eventOutcomeDetail, ok := eventOutcomeInfo["event_outcome_detail"].(map[string]any)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_outcome_detail format")
-		}
+// reingestPremisEvent links a re-ingestion event to a re-preserved package object, and relates the object to its previous AIP.
+func reingestPremisEvent(premisObject *premis.Object, premisAgents []premis.Agent, packageVersion PackageVersion) premis.Event {
+	premisEvent := linkPremisEvent(premisObject, premisAgents, premis.Event{
+		EventIdentifier: premis.EventIdentifier{
+			IdentifierType:  "UUID",
+			IdentifierValue: uuid.New().String(),
+		},
+		EventType:     "re-ingestion",
+		EventDateTime: time.Now().UTC().Format(time.RFC3339),
+		EventDetailInformation: []premis.EventDetailInformation{{
+			EventDetail: fmt.Sprintf("Package preserved again as version %d, following AIP %s", packageVersion.Version, packageVersion.PreviousAipUUID),
+		}},
+		EventOutcomeInformation: []premis.EventOutcomeInformation{{
+			EventOutcome: "success",
+			EventOutcomeDetails: []premis.EventOutcomeDetail{{
+				EventOutcomeDetailNote: "Previous AIP: " + packageVersion.PreviousAipUUID,
+			}},
+		}},
+	})
+	premisObject.Relationships = append(premisObject.Relationships, premis.Relationship{
+		RelationshipType:    "derivation",
+		RelationshipSubType: "has source",
+		RelatedObjectIdentifiers: []premis.RelatedObjectIdentifier{{
+			IdentifierType:  "UUID",
+			IdentifierValue: packageVersion.PreviousAipUUID,
+		}},
+		RelatedEventIdentifiers: []premis.RelatedEventIdentifier{premis.RelatedEventIdentifier(premisEvent.EventIdentifier)},
+	})
+	return premisEvent
+}
 
-		eventOutcomeDetailNote, ok := eventOutcomeDetail["event_outcome_detail_note"].(string)
-		if !ok {
-			return premis.Object{}, nil, fmt.Errorf("invalid event_outcome_detail_note format")
-		}
+// constructPremisObjectsFromNode creates the PREMIS object of a node and its events, parsed from its Cells PREMIS metadata.
+// Returns no events if the node has none.
+func constructPremisObjectsFromNode(parser *eventParser, premisAgents []premis.Agent, node *models.TreeNode, objectPath string) (premis.Object, []premis.Event, error) {
+	// Create the PREMIS object
+	premisObject := newPremisObject(node, objectPath)
 
-		premisEvent := premis.Event{
-			EventIdentifier: premis.EventIdentifier{
-				IdentifierType:  identifierType,
-				IdentifierValue: identifierValue,
-			},
-			EventType:     eventType,
-			EventDateTime: eventDateTime,
-			EventDetailInformation: premis.EventDetailInformation{
-				EventDetail: eventDetail,
-			},
-			EventOutcomeInformation: premis.EventOutcomeInformation{
-				EventOutcome: eventOutcome,
-				EventOutcomeDetail: premis.EventOutcomeDetail{
-					EventOutcomeDetailNote: eventOutcomeDetailNote,
-				},
-			},
-		}
-		// Append linking agent identifier to event for every agent
-		for _, premisAgent := range premisAgents {
-			premisEvent.LinkingAgentIdentifiers = append(premisEvent.LinkingAgentIdentifiers, premis.LinkingAgentIdentifier(premisAgent.AgentIdentifier))
-		}
+	// Get the PREMIS events from the cells PREMIS metadata
+	jsonPremisEvents, err := parser.nodeEvents(node)
+	if err != nil {
+		return premis.Object{}, nil, err
+	}
 
-		// Append linking event identifier to object
-		premisObject.LinkingEventIdentifiers = append(premisObject.LinkingEventIdentifiers, premis.LinkingEventIdentifier(premisEvent.EventIdentifier))
-		// Append event to events
-		premisEvents[i] = premisEvent
+	// Link the events to the agents and object
+	premisEvents := make([]premis.Event, len(jsonPremisEvents))
+	for i, premisEvent := range jsonPremisEvents {
+		premisEvents[i] = linkPremisEvent(&premisObject, premisAgents, premisEvent)
 	}
 	return premisObject, premisEvents, nil
 }
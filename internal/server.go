@@ -7,10 +7,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/penwern/curate-preservation-core/pkg/config"
@@ -27,6 +31,16 @@ type ServiceRunner interface {
 	RunArgs(context.Context, *ServiceArgs) error
 }
 
+// HealthReporter is an interface that defines the methods required by the health handler
+type HealthReporter interface {
+	Health() HealthStatus
+}
+
+// WorkspaceRefresher is an interface that defines the methods required by the workspace refresh handler
+type WorkspaceRefresher interface {
+	RefreshWorkspaces(context.Context) error
+}
+
 // recoveryMiddleware wraps an http.HandlerFunc with panic recovery
 func recoveryMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -115,19 +129,12 @@ func Handler(svc ServiceRunner, cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// If theres no paths, we're going to look for nodes from Cells
+		// If theres no paths, we're going to preserve the nodes from Cells, addressed by UUID
 		req.PathsResolved = false
-		if len(req.CellsPaths) == 0 {
-			if len(req.CellsNodes) == 0 {
-				logger.Error("Received request with no paths or nodes")
-				http.Error(w, "no paths or nodes provided", http.StatusBadRequest)
-				return
-			}
-			for _, node := range req.CellsNodes {
-				req.CellsPaths = append(req.CellsPaths, node.Path)
-			}
-			// We set true here because paths coming from cells aren't templated. e.g. personal/user/file, not persoanl-files/file
-			req.PathsResolved = true
+		if len(req.CellsPaths) == 0 && len(req.CellsNodes) == 0 {
+			logger.Error("Received request with no paths or nodes")
+			http.Error(w, "no paths or nodes provided", http.StatusBadRequest)
+			return
 		}
 
 		// Generate a unique request ID
@@ -151,6 +158,40 @@ func Handler(svc ServiceRunner, cfg *config.Config) http.HandlerFunc {
 	return recoveryMiddleware(handler)
 }
 
+// HealthHandler creates a new HTTP handler reporting the health of the preservation service.
+// Responds 503 while A3M is unavailable so load balancers and orchestrators can observe it.
+func HealthHandler(svc HealthReporter) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, _ *http.Request) {
+		health := svc.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if health.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(health); err != nil {
+			logger.Error(fmt.Sprintf("Failed to encode health response: %v", err))
+		}
+	}
+	return recoveryMiddleware(handler)
+}
+
+// RefreshWorkspacesHandler creates a new HTTP handler refreshing the cached Cells workspace collection.
+// Call it after creating a workspace or changing a template path in Cells.
+func RefreshWorkspacesHandler(svc WorkspaceRefresher) http.HandlerFunc {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := svc.RefreshWorkspaces(r.Context()); err != nil {
+			logger.Error(fmt.Sprintf("Workspace refresh error: %v", err))
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return recoveryMiddleware(handler)
+}
+
 // generateRequestID creates a unique identifier for a request based on its contents
 func generateRequestID(req ServiceArgs) string {
 	// Create a simple hash based on username and path combination
@@ -160,14 +201,23 @@ func generateRequestID(req ServiceArgs) string {
 		id += ":" + path
 	}
 	for _, node := range req.CellsNodes {
-		id += ":" + node.Path
+		// Key nodes by UUID, so a renamed node is still recognised as the same request
+		if node.UUID != "" {
+			id += ":" + node.UUID
+		} else {
+			id += ":" + node.Path
+		}
 	}
 	return id
 }
 
 // Serve starts the HTTP server for the preservation service.
+// It returns once the server is shut down by SIGINT or SIGTERM, so the caller can close the service and revoke its tokens.
 func Serve(svc *Service, addr string) error {
 	http.HandleFunc("/preserve", Handler(svc, svc.cfg))
+	http.HandleFunc("/health", HealthHandler(svc))
+	http.HandleFunc("/workspaces/refresh", RefreshWorkspacesHandler(svc))
+	http.Handle("/metrics", expvar.Handler())
 	logger.Info(fmt.Sprintf("Server listening on %s", addr))
 
 	// Create server with proper timeouts to address gosec G114
@@ -178,5 +228,24 @@ func Serve(svc *Service, addr string) error {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	return server.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		logger.Info("Shutting down HTTP server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		// Jobs still running are abandoned after the timeout. Their tokens are revoked when the service closes
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("HTTP server shutdown: %v", err)
+		}
+		return nil
+	}
 }
@@ -3,11 +3,14 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/penwern/curate-preservation-core/internal/a3mclient"
+	"github.com/penwern/curate-preservation-core/internal/cells"
+	"github.com/penwern/curate-preservation-core/internal/disposition"
 	"github.com/penwern/curate-preservation-core/internal/preservation"
 	"github.com/penwern/curate-preservation-core/pkg/config"
 	"github.com/penwern/curate-preservation-core/pkg/logger"
@@ -27,9 +30,18 @@ type ServiceArgs struct {
 	CellsPaths       []string                   `json:"paths"`
 	CellsUsername    string                     `json:"username"`
 	Cleanup          bool                       `json:"cleanup"`
+	Disposition      string                     `json:"disposition"` // Action on the source once preserved: leave, move or delete. Defaults to the configured action
+	Force            bool                       `json:"force"`       // Preserve content unchanged since its last preservation
 	PathsResolved    bool                       `json:"pathsResolved"`
 	PreservationCfg  *config.PreservationConfig `json:"preservationCfg"`
 	AtomCfg          *config.AtomConfig         `json:"atomCfg"`
+	StatusVocabulary string                     `json:"statusVocabulary"` // Status labels for the job, e.g. a language. Defaults to the configured vocabulary
+}
+
+// HealthStatus represents the health of the preservation service.
+type HealthStatus struct {
+	Status string                 `json:"status"` // ok or degraded
+	A3M    a3mclient.HealthStatus `json:"a3m"`
 }
 
 // NodeAlias represents a cells node.
@@ -47,7 +59,7 @@ func NewService(ctx context.Context, cfg *config.Config) (*Service, error) {
 		MaxActiveProcessing: 1, // Currently only support 1 package at a time ;(
 		PollInterval:        1 * time.Second,
 	}
-	a3mClient, err := a3mclient.NewClientWithOptions(cfg.A3M.Address, a3mOptions)
+	a3mClient, err := a3mclient.NewClientFromConfig(cfg, a3mOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a3m client: %w", err)
 	}
@@ -64,15 +76,79 @@ func (s *Service) Close() {
 	s.svc.Close()
 }
 
+// Health returns the health of the preservation service dependencies.
+func (s *Service) Health() HealthStatus {
+	a3mHealth := s.svc.A3MHealth()
+	status := "ok"
+	if a3mHealth.CircuitState != a3mclient.CircuitClosed.String() {
+		status = "degraded"
+	}
+	return HealthStatus{
+		Status: status,
+		A3M:    a3mHealth,
+	}
+}
+
+// RefreshWorkspaces refreshes the cached Cells workspace collection.
+func (s *Service) RefreshWorkspaces(ctx context.Context) error {
+	return s.svc.RefreshWorkspaces(ctx)
+}
+
+// Targets returns the nodes to preserve. Paths take precedence over nodes, as before.
+// Nodes are addressed by UUID, falling back to their path if the UUID is missing.
+func (args *ServiceArgs) Targets() []preservation.Target {
+	var targets []preservation.Target
+	if len(args.CellsPaths) > 0 {
+		for _, path := range args.CellsPaths {
+			targets = append(targets, preservation.Target{Path: path, PathResolved: args.PathsResolved})
+		}
+		return targets
+	}
+	for _, node := range args.CellsNodes {
+		// Paths coming from cells aren't templated. e.g. personal/user/file, not personal-files/file
+		targets = append(targets, preservation.Target{UUID: node.UUID, Path: node.Path, PathResolved: true})
+	}
+	return targets
+}
+
 // RunArgs runs the preservation service with the given arguments.
 func (s *Service) RunArgs(ctx context.Context, args *ServiceArgs) error {
-	return s.Run(ctx, args.CellsUsername, args.CellsPaths, args.Cleanup, args.PathsResolved, args.PreservationCfg, args.AtomCfg)
+	return s.Run(ctx, args.CellsUsername, args.Targets(), args.RunOptions(), args.PreservationCfg, args.AtomCfg)
+}
+
+// RunOptions returns the per-request options of the preservation jobs.
+func (args *ServiceArgs) RunOptions() preservation.RunOptions {
+	return preservation.RunOptions{
+		CleanUp:           args.Cleanup,
+		StatusVocabulary:  args.StatusVocabulary,
+		ArchiveDir:        args.CellsArchiveDir,
+		Force:             args.Force,
+		DispositionAction: args.Disposition,
+	}
 }
 
 // Run runs the preservation service.
-func (s *Service) Run(ctx context.Context, username string, paths []string, cleanup, pathsResolved bool, presConfig *config.PreservationConfig, atomConfig *config.AtomConfig) error {
+func (s *Service) Run(ctx context.Context, username string, targets []preservation.Target, opts preservation.RunOptions, presConfig *config.PreservationConfig, atomConfig *config.AtomConfig) error {
+	// Fail early on an unknown vocabulary, the nodes can't be tagged without one
+	if _, err := s.svc.StatusVocabulary(opts.StatusVocabulary); err != nil {
+		return err
+	}
+	// Fail early on an invalid archive directory template, it's only rendered before upload
+	if opts.ArchiveDir != "" {
+		if _, err := preservation.ParseArchiveDir(opts.ArchiveDir); err != nil {
+			return err
+		}
+	}
+	// Fail early on an invalid disposition, it only applies once a node is preserved
+	if err := disposition.Validate(opts.DispositionAction); err != nil {
+		return err
+	}
+	if opts.DispositionAction == disposition.ActionMove && s.cfg.Disposition.MoveDir == "" {
+		return fmt.Errorf("invalid disposition %q: CA4M_DISPOSITION_MOVE_DIR isn't set", opts.DispositionAction)
+	}
+
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(paths))
+	errChan := make(chan error, len(targets))
 
 	if s.cfg.LogLevel == "debug" {
 		// Pretty print the configuration
@@ -106,16 +182,22 @@ func (s *Service) Run(ctx context.Context, username string, paths []string, clea
 	if err != nil {
 		return fmt.Errorf("failed to get user client: %w", err)
 	}
+	defer func() {
+		// Revoke the user tokens even if the request context is done
+		if err := s.svc.CloseUserClient(context.WithoutCancel(ctx), userClient); err != nil {
+			logger.Error("Failed to close user client: %v", err)
+		}
+	}()
 
-	for _, packagePath := range paths {
+	for _, packageTarget := range targets {
 		wg.Add(1)
-		go func(path string) {
+		go func(target preservation.Target) {
 			defer wg.Done()
 
 			// Add panic recovery to prevent crashes
 			defer func() {
 				if r := recover(); r != nil {
-					logger.Error("Panic recovered in preservation goroutine for path '%s': %v", path, r)
+					logger.Error("Panic recovered in preservation goroutine for node '%s': %v", target, r)
 					errChan <- fmt.Errorf("panic occurred during preservation: %v", r)
 				}
 			}()
@@ -124,8 +206,8 @@ func (s *Service) Run(ctx context.Context, username string, paths []string, clea
 			defer func() { <-semaphore }()
 
 			for i := range maxRetries {
-				if err := s.svc.Run(ctx, presConfig, atomConfig, userClient, path, cleanup, pathsResolved); err != nil {
-					logger.Error("Error running preservation for package '%s' (attempt %d/%d): %v", path, i+1, maxRetries, err)
+				if err := s.runRequeuing(ctx, presConfig, atomConfig, userClient, target, opts); err != nil {
+					logger.Error("Error running preservation for package '%s' (attempt %d/%d): %v", target, i+1, maxRetries, err)
 					if i+1 == maxRetries {
 						errChan <- err
 					}
@@ -134,7 +216,7 @@ func (s *Service) Run(ctx context.Context, username string, paths []string, clea
 					break
 				}
 			}
-		}(packagePath)
+		}(packageTarget)
 	}
 
 	wg.Wait()
@@ -146,3 +228,19 @@ func (s *Service) Run(ctx context.Context, username string, paths []string, clea
 
 	return nil
 }
+
+// runRequeuing runs a preservation job, running it again while its source changes during the job, up to the configured limit.
+// Each run stores an AIP. Once the limit is reached, the node keeps its source changed tag.
+func (s *Service) runRequeuing(ctx context.Context, presConfig *config.PreservationConfig, atomConfig *config.AtomConfig, userClient cells.UserClient, target preservation.Target, opts preservation.RunOptions) error {
+	for requeues := 0; ; requeues++ {
+		err := s.svc.Run(ctx, presConfig, atomConfig, userClient, target, opts)
+		if !errors.Is(err, preservation.ErrSourceChanged) {
+			return err
+		}
+		if requeues >= s.cfg.Source.MaxRequeues {
+			logger.Warn("Source of '%s' changed during preservation, not re-queueing after %d attempt(s): %v", target, requeues+1, err)
+			return nil
+		}
+		logger.Info("Re-queueing '%s' (%d/%d): %v", target, requeues+1, s.cfg.Source.MaxRequeues, err)
+	}
+}
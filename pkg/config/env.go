@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
@@ -21,23 +22,98 @@ type Config struct {
 		Address      string `mapstructure:"address" validate:"hostname_port" comment:"A3M gRPC address"`
 		CompletedDir string `mapstructure:"completed_dir" validate:"dir" comment:"A3M completed directory"`
 		DipsDir      string `mapstructure:"dips_dir" validate:"dir" comment:"A3M dips directory"`
+		Token        string `mapstructure:"token" comment:"A3M bearer token sent with every RPC. Requires TLS"`
+
+		HealthCheckInterval time.Duration `mapstructure:"health_check_interval" comment:"Time between A3M health checks. Disabled if zero"`
+		FailureThreshold    int           `mapstructure:"failure_threshold" validate:"gte=1" comment:"Consecutive A3M failures before submissions wait for recovery"`
+
+		TLS struct {
+			Enabled        bool   `mapstructure:"enabled" comment:"Connect to A3M using TLS"`
+			CACertPath     string `mapstructure:"ca_cert_path" validate:"omitempty,file" comment:"PEM CA bundle to verify the A3M server"`
+			ClientCertPath string `mapstructure:"client_cert_path" validate:"required_with=ClientKeyPath,omitempty,file" comment:"PEM client certificate for mTLS"`
+			ClientKeyPath  string `mapstructure:"client_key_path" validate:"required_with=ClientCertPath,omitempty,file" comment:"PEM client key for mTLS"`
+			ServerName     string `mapstructure:"server_name" comment:"Overrides the A3M server name used for verification"`
+		} `mapstructure:"tls"`
 	} `mapstructure:"a3m"`
 
 	Cells struct {
 		Address          string `mapstructure:"address" validate:"http_url" comment:"Cells address"`
 		AdminToken       string `mapstructure:"admin_token" validate:"required" comment:"Cells admin token"`
 		ArchiveWorkspace string `mapstructure:"archive_workspace" comment:"Cells archive workspace"`
-		CecPath          string `mapstructure:"cec_path" validate:"file" comment:"Cells cec binary path"`
+		CollisionPolicy  string `mapstructure:"collision_policy" validate:"oneof=version skip replace fail" comment:"Action when an AIP of the same package is already archived in the destination"`
+		CecPath          string `mapstructure:"cec_path" validate:"required_if=TransferBackend cec,omitempty,file" comment:"Cells cec binary path"`
+
+		UserTokenTTL             time.Duration `mapstructure:"user_token_ttl" validate:"gte=5m" comment:"Lifetime of user impersonation tokens. Refreshed while jobs run"`
+		WorkspaceRefreshInterval time.Duration `mapstructure:"workspace_refresh_interval" comment:"Maximum age of the cached workspace collection. Only refreshed on misses and on demand if zero"`
+		ListPageSize             int           `mapstructure:"list_page_size" validate:"gte=1" comment:"Nodes listed per tree listing request. Bounds the memory used by large packages"`
+
+		TransferBackend     string `mapstructure:"transfer_backend" validate:"oneof=cec sdk" comment:"Content transfer backend: native sdk or cec binary"`
+		TransferPartSizeMB  int64  `mapstructure:"transfer_part_size_mb" validate:"gte=10" comment:"Multipart transfer part size in MB. Must be a multiple of 10"`
+		TransferConcurrency int    `mapstructure:"transfer_concurrency" validate:"gte=1" comment:"Parts transferred in parallel for each file"`
 	} `mapstructure:"cells"`
 
 	Atom struct {
-		ConfigPath string `mapstructure:"config_path" comment:"Path to AtoM configuration file"`
+		ConfigPath    string `mapstructure:"config_path" comment:"Path to AtoM configuration file"`
+		SlugNamespace string `mapstructure:"slug_namespace" comment:"Cells metadata namespace holding the AtoM slug a node's DIP is deposited to"`
 	} `mapstructure:"atom"`
 
 	Premis struct {
 		Organization string `mapstructure:"organization" comment:"Premis Agent Organization"`
+		RecordAccess bool   `mapstructure:"record_access" comment:"Record the Cells ownership, workspace members and shares of packages in their transfer"`
+
+		ChecksumAlgorithms []string `mapstructure:"checksum_algorithms" validate:"dive,oneof=md5 sha256 sha512" comment:"Checksums recorded for each transfer file besides SHA-256, comma separated"`
+		ChecksumWorkers    int      `mapstructure:"checksum_workers" validate:"gte=0" comment:"Transfer files checksummed in parallel. The number of CPUs if zero"`
+		EventParsing       string   `mapstructure:"event_parsing" validate:"oneof=strict lenient" comment:"Parsing of the Cells PREMIS event metadata: strict fails on malformed events, lenient repairs or drops and reports them"`
 	}
 
+	Preservation struct {
+		ProfileNamespace string `mapstructure:"profile_namespace" comment:"Cells metadata namespace selecting the processing profile of a node. Empty disables node profiles"`
+		ProfilesPath     string `mapstructure:"profiles_path" comment:"Path to processing profiles file"`
+	} `mapstructure:"preservation"`
+
+	// Status namespaces receive the progress of a job on the source node, as a display label and a machine-readable code
+	Status struct {
+		PreservationNamespace       string `mapstructure:"preservation_namespace" validate:"required" comment:"Cells metadata namespace receiving the preservation status label"`
+		PreservationCodeNamespace   string `mapstructure:"preservation_code_namespace" comment:"Cells metadata namespace receiving the preservation status code. Empty disables"`
+		DipNamespace                string `mapstructure:"dip_namespace" comment:"Cells metadata namespace receiving the DIP status label. Empty disables"`
+		DipCodeNamespace            string `mapstructure:"dip_code_namespace" comment:"Cells metadata namespace receiving the DIP status code. Empty disables"`
+		LegacyPreservationNamespace string `mapstructure:"legacy_preservation_namespace" comment:"Deprecated preservation status namespace, used instead for nodes that still carry it"`
+		LegacyDipNamespace          string `mapstructure:"legacy_dip_namespace" comment:"Deprecated DIP status namespace, used instead for nodes that still carry it"`
+		Vocabulary                  string `mapstructure:"vocabulary" validate:"required" comment:"Default status vocabulary: emoji, plain or one from the vocabularies file"`
+		VocabulariesPath            string `mapstructure:"vocabularies_path" comment:"Path to status vocabularies file"`
+	} `mapstructure:"status"`
+
+	// Results namespaces receive the outcome of a job, on both the source node and the uploaded AIP node. Empty disables a namespace
+	Results struct {
+		AipUUIDNamespace            string `mapstructure:"aip_uuid_namespace" comment:"Cells metadata namespace receiving the AIP UUID"`
+		AipLocationNamespace        string `mapstructure:"aip_location_namespace" comment:"Cells metadata namespace receiving the AIP path in the archive workspace"`
+		VersionNamespace            string `mapstructure:"version_namespace" comment:"Cells metadata namespace receiving the AIP version. Also read to version re-preservations"`
+		PreviousAipUUIDNamespace    string `mapstructure:"previous_aip_uuid_namespace" comment:"Cells metadata namespace receiving the UUID of the AIP a re-preservation follows"`
+		DateNamespace               string `mapstructure:"date_namespace" comment:"Cells metadata namespace receiving the preservation date"`
+		AipSizeNamespace            string `mapstructure:"aip_size_namespace" comment:"Cells metadata namespace receiving the AIP size in bytes"`
+		AipChecksumNamespace        string `mapstructure:"aip_checksum_namespace" comment:"Cells metadata namespace receiving the AIP checksum. Compressed AIPs only"`
+		ProfileNamespace            string `mapstructure:"profile_namespace" comment:"Cells metadata namespace receiving the processing profile used"`
+		AtomSlugNamespace           string `mapstructure:"atom_slug_namespace" comment:"Cells metadata namespace receiving the AtoM slug the DIP was deposited to"`
+		DipURLNamespace             string `mapstructure:"dip_url_namespace" comment:"Cells metadata namespace receiving the AtoM description URL"`
+		ContentFingerprintNamespace string `mapstructure:"content_fingerprint_namespace" comment:"Cells metadata namespace receiving the source content fingerprint. Also read to skip unchanged content"`
+		SourceUUIDNamespace         string `mapstructure:"source_uuid_namespace" comment:"Cells metadata namespace receiving the source node UUID. AIP node only"`
+		SourcePathNamespace         string `mapstructure:"source_path_namespace" comment:"Cells metadata namespace receiving the source node path. AIP node only"`
+	} `mapstructure:"results"`
+
+	// Source guards against changes to the source node while it is preserved
+	Source struct {
+		ChangeAction  string `mapstructure:"change_action" validate:"oneof=flag requeue" comment:"Action on source changes during a job: flag the node as preserved with a changed source, or also preserve it again"`
+		MaxRequeues   int    `mapstructure:"max_requeues" validate:"gte=0" comment:"Maximum times a job is re-queued for source changes, before the node is only flagged"`
+		LockNamespace string `mapstructure:"lock_namespace" comment:"Cells metadata namespace locking the source node during a job, e.g. content_lock. Empty disables"`
+	} `mapstructure:"source"`
+
+	// Disposition applies to the source node once its AIP is archived and verified
+	Disposition struct {
+		Action       string        `mapstructure:"action" validate:"oneof=leave move delete" comment:"Default action on preserved sources: leave them, move them or schedule their deletion"`
+		MoveDir      string        `mapstructure:"move_dir" validate:"required_if=Action move" comment:"Workspace folder preserved sources are moved to. Supports the archive directory template variables"`
+		ReviewPeriod time.Duration `mapstructure:"review_period" validate:"gte=0" comment:"Time scheduled deletions wait in the reviewable queue"`
+	} `mapstructure:"disposition"`
+
 	Cleanup           bool   `mapstructure:"cleanup" comment:"Cleanup completed packages"`
 	AllowInsecureTLS  bool   `mapstructure:"allow_insecure_tls" comment:"Allow insecure TLS connections"`
 	LogLevel          string `mapstructure:"log_level" validate:"oneof=debug info warn error fatal panic" comment:"Log level"`
@@ -60,15 +136,69 @@ func setDefaults() {
 	viper.SetDefault("a3m.address", "localhost:7000")
 	viper.SetDefault("a3m.completed_dir", "/home/a3m/.local/share/a3m/share/completed")
 	viper.SetDefault("a3m.dips_dir", "/home/a3m/.local/share/a3m/share/dips")
+	viper.SetDefault("a3m.token", "")
+	viper.SetDefault("a3m.health_check_interval", "30s")
+	viper.SetDefault("a3m.failure_threshold", 3)
+	viper.SetDefault("a3m.tls.enabled", false)
+	viper.SetDefault("a3m.tls.ca_cert_path", "")
+	viper.SetDefault("a3m.tls.client_cert_path", "")
+	viper.SetDefault("a3m.tls.client_key_path", "")
+	viper.SetDefault("a3m.tls.server_name", "")
 
 	viper.SetDefault("cells.address", "https://localhost:8080")
 	viper.SetDefault("cells.admin_token", "")
 	viper.SetDefault("cells.archive_workspace", "common-files")
+	viper.SetDefault("cells.collision_policy", "version")
 	viper.SetDefault("cells.cec_path", "/usr/local/bin/cec")
+	viper.SetDefault("cells.user_token_ttl", "30m")
+	viper.SetDefault("cells.workspace_refresh_interval", "5m")
+	viper.SetDefault("cells.list_page_size", 1000)
+	viper.SetDefault("cells.transfer_backend", "sdk")
+	viper.SetDefault("cells.transfer_part_size_mb", 50)
+	viper.SetDefault("cells.transfer_concurrency", 3)
 
 	viper.SetDefault("atom.config_path", "./atom_config.json")
+	viper.SetDefault("atom.slug_namespace", "usermeta-atom-slug")
 
 	viper.SetDefault("premis.organization", "")
+	viper.SetDefault("premis.record_access", true)
+	viper.SetDefault("premis.checksum_algorithms", []string{})
+	viper.SetDefault("premis.checksum_workers", 0)
+	viper.SetDefault("premis.event_parsing", "strict")
+
+	viper.SetDefault("preservation.profile_namespace", "usermeta-preservation-profile")
+	viper.SetDefault("preservation.profiles_path", "./preservation_profiles.json")
+
+	viper.SetDefault("status.preservation_namespace", "usermeta-preservation-status")
+	viper.SetDefault("status.preservation_code_namespace", "usermeta-preservation-status-code")
+	viper.SetDefault("status.dip_namespace", "usermeta-dip-status")
+	viper.SetDefault("status.dip_code_namespace", "usermeta-dip-status-code")
+	viper.SetDefault("status.legacy_preservation_namespace", "usermeta-a3m-progress")
+	viper.SetDefault("status.legacy_dip_namespace", "usermeta-dip-progress")
+	viper.SetDefault("status.vocabulary", "emoji")
+	viper.SetDefault("status.vocabularies_path", "./status_vocabularies.json")
+
+	viper.SetDefault("results.aip_uuid_namespace", "usermeta-preservation-aip-uuid")
+	viper.SetDefault("results.aip_location_namespace", "usermeta-preservation-aip-location")
+	viper.SetDefault("results.version_namespace", "usermeta-preservation-aip-version")
+	viper.SetDefault("results.previous_aip_uuid_namespace", "usermeta-preservation-previous-aip-uuid")
+	viper.SetDefault("results.date_namespace", "usermeta-preservation-date")
+	viper.SetDefault("results.aip_size_namespace", "usermeta-preservation-aip-size")
+	viper.SetDefault("results.aip_checksum_namespace", "usermeta-preservation-aip-checksum")
+	viper.SetDefault("results.profile_namespace", "usermeta-preservation-profile-used")
+	viper.SetDefault("results.atom_slug_namespace", "usermeta-preservation-atom-slug")
+	viper.SetDefault("results.dip_url_namespace", "usermeta-dip-url")
+	viper.SetDefault("results.content_fingerprint_namespace", "usermeta-preservation-content-fingerprint")
+	viper.SetDefault("results.source_uuid_namespace", "usermeta-preservation-source-uuid")
+	viper.SetDefault("results.source_path_namespace", "usermeta-preservation-source-path")
+
+	viper.SetDefault("source.change_action", "flag")
+	viper.SetDefault("source.max_requeues", 1)
+	viper.SetDefault("source.lock_namespace", "")
+
+	viper.SetDefault("disposition.action", "leave")
+	viper.SetDefault("disposition.move_dir", "")
+	viper.SetDefault("disposition.review_period", "720h")
 
 	viper.SetDefault("cleanup", true)
 	viper.SetDefault("allow_insecure_tls", false)
@@ -13,6 +13,7 @@ type PreservationConfig struct {
 	// TODO: Change this to AIP Compression Algo and Level (with algo option None)
 	CompressAip bool                              `json:"compress_aip" comment:"Compress AIP"`
 	A3mConfig   *transferservice.ProcessingConfig `json:"a3m_config" comment:"A3M processing configuration"`
+	Profile     string                            `json:"profile,omitempty" comment:"Name of the processing profile the configuration was resolved from"`
 }
 
 // DefaultPreservationConfig returns a default configuration for the preservation service.
@@ -59,6 +60,7 @@ func (cfg *PreservationConfig) MergeWithDefaults() PreservationConfig {
 
 	// Handle top level fields
 	result.CompressAip = cfg.CompressAip || defaults.CompressAip
+	result.Profile = cfg.Profile
 
 	// Handle A3M config
 	if cfg.A3mConfig != nil {
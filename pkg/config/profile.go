@@ -0,0 +1,184 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	transferservice "github.com/penwern/curate-preservation-core/common/proto/a3m/gen/go/a3m/api/transferservice/v1beta1"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultProfileName is the name of the built-in profile that resolves to DefaultPreservationConfig.
+const DefaultProfileName = "default"
+
+// ProcessingProfiles maps a profile name to a partial preservation configuration.
+// Each profile is applied on top of the built-in defaults, so it only needs to list the fields it changes.
+// Example:
+//
+//	{
+//	  "born-digital": {"a3m_config": {"normalize": false}},
+//	  "compressed":   {"compress_aip": true}
+//	}
+type ProcessingProfiles map[string]json.RawMessage
+
+// NodeProfile is the value of the processing profile metadata namespace on a Cells node.
+// The namespace may hold either a bare profile name (e.g. "born-digital") or a JSON object
+// naming an optional profile together with per-field overrides, e.g.
+//
+//	{"profile": "born-digital", "compress_aip": true, "a3m_config": {"examine_contents": true}}
+type NodeProfile struct {
+	Profile   string          // Named profile to start from. Empty means the request configuration.
+	Overrides json.RawMessage // Partial PreservationConfig JSON applied after the profile. May be nil.
+}
+
+// LoadProcessingProfiles loads the named processing profiles from a JSON file.
+// A missing file is not an error and yields no profiles other than the built-in default.
+func LoadProcessingProfiles(path string) (ProcessingProfiles, error) {
+	profiles := ProcessingProfiles{}
+	if path == "" {
+		return profiles, nil
+	}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, fmt.Errorf("reading profiles file: %w", err)
+	}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("unmarshaling profiles file: %w", err)
+	}
+	// Ensure every profile is applicable before it is needed by a job
+	for name := range profiles {
+		if _, err := profiles.Get(name); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// Get returns the full preservation configuration for the named profile.
+func (p ProcessingProfiles) Get(name string) (PreservationConfig, error) {
+	if raw, ok := p[name]; ok {
+		cfg, err := DefaultPreservationConfig().WithOverrides(raw)
+		if err != nil {
+			return PreservationConfig{}, fmt.Errorf("invalid processing profile %q: %w", name, err)
+		}
+		return cfg, nil
+	}
+	if name == DefaultProfileName {
+		return DefaultPreservationConfig(), nil
+	}
+	return PreservationConfig{}, fmt.Errorf("unknown processing profile: %q", name)
+}
+
+// ParseNodeProfile parses the value of the processing profile metadata namespace.
+// Cells stores user metadata JSON encoded, so the value may be a quoted string holding either a profile name or a JSON object.
+// Returns nil if the value is empty.
+func ParseNodeProfile(value string) (*NodeProfile, error) {
+	value = strings.TrimSpace(value)
+	// Unwrap JSON encoded strings
+	var unquoted string
+	if err := json.Unmarshal([]byte(value), &unquoted); err == nil {
+		value = strings.TrimSpace(unquoted)
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	// Bare profile name
+	if !strings.HasPrefix(value, "{") {
+		return &NodeProfile{Profile: value}, nil
+	}
+
+	// Profile name with per-field overrides
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return nil, fmt.Errorf("error parsing node profile: %w", err)
+	}
+	nodeProfile := &NodeProfile{}
+	if rawName, ok := fields["profile"]; ok {
+		if err := json.Unmarshal(rawName, &nodeProfile.Profile); err != nil {
+			return nil, fmt.Errorf("error parsing node profile name: %w", err)
+		}
+		delete(fields, "profile")
+	}
+	if len(fields) > 0 {
+		overrides, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding node profile overrides: %w", err)
+		}
+		nodeProfile.Overrides = overrides
+	}
+	return nodeProfile, nil
+}
+
+// ResolveNodeProfile returns the preservation configuration for a node.
+// Precedence, lowest to highest:
+//  1. Built-in defaults
+//  2. The request configuration (API body or CLI flags), already merged with the defaults.
+//     A profile named by the request replaces the rest of the request configuration
+//  3. The named profile from the node metadata, which replaces the request configuration
+//  4. The per-field overrides from the node metadata
+//
+// The request configuration is never modified.
+func (p ProcessingProfiles) ResolveNodeProfile(requestCfg PreservationConfig, nodeProfile *NodeProfile) (PreservationConfig, error) {
+	cfg := requestCfg.Clone()
+	if requestCfg.Profile != "" {
+		profileCfg, err := p.Get(requestCfg.Profile)
+		if err != nil {
+			return PreservationConfig{}, err
+		}
+		cfg = profileCfg
+		cfg.Profile = requestCfg.Profile
+	}
+	if nodeProfile == nil {
+		return cfg, nil
+	}
+	if nodeProfile.Profile != "" {
+		profileCfg, err := p.Get(nodeProfile.Profile)
+		if err != nil {
+			return PreservationConfig{}, err
+		}
+		cfg = profileCfg
+		cfg.Profile = nodeProfile.Profile
+	}
+	if len(nodeProfile.Overrides) > 0 {
+		overridden, err := cfg.WithOverrides(nodeProfile.Overrides)
+		if err != nil {
+			return PreservationConfig{}, fmt.Errorf("invalid node profile overrides: %w", err)
+		}
+		cfg = overridden
+	}
+	return cfg, nil
+}
+
+// Clone returns a deep copy of the preservation configuration.
+func (cfg PreservationConfig) Clone() PreservationConfig {
+	clone := cfg
+	if cfg.A3mConfig != nil {
+		a3mConfig, ok := proto.Clone(cfg.A3mConfig).(*transferservice.ProcessingConfig)
+		if ok {
+			clone.A3mConfig = a3mConfig
+		}
+	}
+	return clone
+}
+
+// WithOverrides returns a copy of the configuration with the fields present in the partial JSON applied.
+// Unlike MergeWithDefaults, fields that are present are always applied, so booleans can be switched off.
+func (cfg PreservationConfig) WithOverrides(raw json.RawMessage) (PreservationConfig, error) {
+	result := cfg.Clone()
+	if result.A3mConfig == nil {
+		result.A3mConfig = defaultA3mConfig()
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return PreservationConfig{}, err
+	}
+	return result, nil
+}
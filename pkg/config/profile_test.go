@@ -0,0 +1,181 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseNodeProfile(t *testing.T) {
+	cases := []struct {
+		name      string
+		value     string
+		want      *NodeProfile
+		overrides map[string]string // Override field to raw JSON value
+		wantErr   string
+	}{
+		{name: "empty", value: ""},
+		{name: "blank", value: "  "},
+		{name: "empty JSON string", value: `""`},
+		{name: "bare name", value: "born-digital", want: &NodeProfile{Profile: "born-digital"}},
+		{name: "JSON encoded name", value: `" born-digital "`, want: &NodeProfile{Profile: "born-digital"}},
+		{name: "object with name", value: `{"profile": "born-digital"}`, want: &NodeProfile{Profile: "born-digital"}},
+		{
+			name:      "object with name and overrides",
+			value:     `{"profile": "born-digital", "compress_aip": true, "a3m_config": {"examine_contents": true}}`,
+			want:      &NodeProfile{Profile: "born-digital"},
+			overrides: map[string]string{"compress_aip": "true", "a3m_config": `{"examine_contents": true}`},
+		},
+		{
+			name:      "object with overrides only",
+			value:     `{"compress_aip": false}`,
+			want:      &NodeProfile{},
+			overrides: map[string]string{"compress_aip": "false"},
+		},
+		{
+			name:      "JSON encoded object",
+			value:     `"{\"profile\": \"compressed\", \"compress_aip\": false}"`,
+			want:      &NodeProfile{Profile: "compressed"},
+			overrides: map[string]string{"compress_aip": "false"},
+		},
+		{name: "malformed object", value: `{"profile": `, wantErr: "error parsing node profile"},
+		{name: "name not a string", value: `{"profile": 1}`, wantErr: "error parsing node profile name"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseNodeProfile(tc.value)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ParseNodeProfile(%q): got %v, want an error containing %q", tc.value, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNodeProfile(%q): %v", tc.value, err)
+			}
+			if tc.want == nil {
+				if got != nil {
+					t.Fatalf("ParseNodeProfile(%q): got %+v, want none", tc.value, got)
+				}
+				return
+			}
+			if got == nil || got.Profile != tc.want.Profile {
+				t.Fatalf("ParseNodeProfile(%q): got %+v, want profile %q", tc.value, got, tc.want.Profile)
+			}
+			if len(tc.overrides) == 0 {
+				if got.Overrides != nil {
+					t.Errorf("overrides: got %s, want none", got.Overrides)
+				}
+				return
+			}
+			var overrides map[string]json.RawMessage
+			if err := json.Unmarshal(got.Overrides, &overrides); err != nil {
+				t.Fatalf("overrides: %v", err)
+			}
+			if len(overrides) != len(tc.overrides) {
+				t.Fatalf("overrides: got %s, want %v", got.Overrides, tc.overrides)
+			}
+			for field, want := range tc.overrides {
+				if canonical(t, overrides[field]) != canonical(t, json.RawMessage(want)) {
+					t.Errorf("override %s: got %s, want %s", field, overrides[field], want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveNodeProfile(t *testing.T) {
+	profiles := ProcessingProfiles{
+		"born-digital": json.RawMessage(`{"a3m_config": {"normalize": false}}`),
+		"compressed":   json.RawMessage(`{"compress_aip": true}`),
+	}
+	// The request configuration examines contents, which no profile does
+	request := DefaultPreservationConfig()
+	request.A3mConfig.ExamineContents = true
+
+	cases := []struct {
+		name        string
+		request     PreservationConfig
+		node        *NodeProfile
+		profile     string
+		compress    bool
+		normalize   bool
+		examine     bool
+		wantErr     string
+		requestName string // Profile named by the request
+	}{
+		{name: "request configuration", request: request, normalize: true, examine: true},
+		{name: "request profile", requestName: "compressed", profile: "compressed", compress: true, normalize: true},
+		{name: "node profile replaces the request configuration", request: request, node: &NodeProfile{Profile: "born-digital"}, profile: "born-digital"},
+		{name: "node profile replaces the request profile", requestName: "compressed", node: &NodeProfile{Profile: "born-digital"}, profile: "born-digital"},
+		{
+			name:      "node overrides on the request configuration",
+			request:   request,
+			node:      &NodeProfile{Overrides: json.RawMessage(`{"compress_aip": true}`)},
+			compress:  true,
+			normalize: true,
+			examine:   true,
+		},
+		{
+			name:     "node overrides on the node profile",
+			request:  request,
+			node:     &NodeProfile{Profile: "born-digital", Overrides: json.RawMessage(`{"compress_aip": true, "a3m_config": {"examine_contents": true}}`)},
+			profile:  "born-digital",
+			compress: true,
+			examine:  true,
+		},
+		{
+			name:        "node overrides switch a profile setting off",
+			requestName: "compressed",
+			node:        &NodeProfile{Overrides: json.RawMessage(`{"compress_aip": false}`)},
+			profile:     "compressed",
+			normalize:   true,
+		},
+		{name: "default profile", request: request, node: &NodeProfile{Profile: DefaultProfileName}, profile: DefaultProfileName, normalize: true},
+		{name: "unknown node profile", request: request, node: &NodeProfile{Profile: "missing"}, wantErr: "unknown processing profile"},
+		{name: "unknown request profile", requestName: "missing", wantErr: "unknown processing profile"},
+		{name: "unknown override", request: request, node: &NodeProfile{Overrides: json.RawMessage(`{"compress": true}`)}, wantErr: "invalid node profile overrides"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			requestCfg := tc.request
+			if tc.requestName != "" {
+				requestCfg = DefaultPreservationConfig()
+				requestCfg.Profile = tc.requestName
+			}
+			before := requestCfg.Clone()
+			got, err := profiles.ResolveNodeProfile(requestCfg, tc.node)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("resolve: got %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if got.Profile != tc.profile || got.CompressAip != tc.compress || got.A3mConfig.Normalize != tc.normalize || got.A3mConfig.ExamineContents != tc.examine {
+				t.Errorf("resolve: got profile %q, compress %t, normalize %t, examine %t, want %q, %t, %t, %t",
+					got.Profile, got.CompressAip, got.A3mConfig.Normalize, got.A3mConfig.ExamineContents, tc.profile, tc.compress, tc.normalize, tc.examine)
+			}
+			// The request configuration is shared between jobs
+			if requestCfg.CompressAip != before.CompressAip || requestCfg.A3mConfig.Normalize != before.A3mConfig.Normalize || requestCfg.A3mConfig.ExamineContents != before.A3mConfig.ExamineContents {
+				t.Errorf("request configuration: got %+v, want it unchanged", requestCfg)
+			}
+		})
+	}
+}
+
+// canonical re-encodes a JSON value, so values differing only in spacing compare equal.
+func canonical(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		t.Fatalf("invalid JSON %s: %v", raw, err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
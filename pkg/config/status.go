@@ -0,0 +1,140 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StatusCode is the machine-readable status of a preservation or DIP job, written alongside its display label.
+type StatusCode string
+
+// Status codes of the preservation and DIP status namespaces.
+const (
+	StatusStarting               StatusCode = "starting"
+	StatusDownloading            StatusCode = "downloading"
+	StatusPreprocessing          StatusCode = "preprocessing"
+	StatusPackaging              StatusCode = "packaging"
+	StatusExtracting             StatusCode = "extracting"
+	StatusCompressing            StatusCode = "compressing"
+	StatusWaiting                StatusCode = "waiting"
+	StatusUploading              StatusCode = "uploading"
+	StatusPreserved              StatusCode = "preserved"
+	StatusPreservedSourceChanged StatusCode = "preserved_source_changed"
+	StatusFailed                 StatusCode = "failed"
+	StatusDipFailed              StatusCode = "dip_failed"
+	StatusMigrating              StatusCode = "migrating"
+	StatusDepositing             StatusCode = "depositing"
+	StatusDeposited              StatusCode = "deposited"
+)
+
+// Built-in status vocabulary names.
+const (
+	EmojiVocabulary = "emoji"
+	PlainVocabulary = "plain"
+)
+
+// StatusVocabulary maps status codes to the labels displayed in Cells.
+type StatusVocabulary map[StatusCode]string
+
+// StatusVocabularies maps a vocabulary name, e.g. a language, to its labels.
+// Example:
+//
+//	{
+//	  "fr": {"starting": "Démarrage...", "preserved": "Préservé", "failed": "Échec"}
+//	}
+type StatusVocabularies map[string]StatusVocabulary
+
+var builtinVocabularies = StatusVocabularies{
+	EmojiVocabulary: {
+		StatusStarting:               "🟢 Starting...",
+		StatusDownloading:            "🌐 Downloading...",
+		StatusPreprocessing:          "🗂️ Preprocessing...",
+		StatusPackaging:              "📦 Packaging...",
+		StatusExtracting:             "🗃️ Extracting...",
+		StatusCompressing:            "🗃️ Compressing...",
+		StatusWaiting:                "⏳ Waiting...",
+		StatusUploading:              "🌐 Uploading...",
+		StatusPreserved:              "🔒 Preserved",
+		StatusPreservedSourceChanged: "🔒 Preserved (source changed)",
+		StatusFailed:                 "❌ Failed",
+		StatusDipFailed:              "❌ DIP Failed",
+		StatusMigrating:              "📨 Migrating...",
+		StatusDepositing:             "🌐 Depositing...",
+		StatusDeposited:              "🖼️ Deposited",
+	},
+	PlainVocabulary: {
+		StatusStarting:               "Starting...",
+		StatusDownloading:            "Downloading...",
+		StatusPreprocessing:          "Preprocessing...",
+		StatusPackaging:              "Packaging...",
+		StatusExtracting:             "Extracting...",
+		StatusCompressing:            "Compressing...",
+		StatusWaiting:                "Waiting...",
+		StatusUploading:              "Uploading...",
+		StatusPreserved:              "Preserved",
+		StatusPreservedSourceChanged: "Preserved (source changed)",
+		StatusFailed:                 "Failed",
+		StatusDipFailed:              "DIP Failed",
+		StatusMigrating:              "Migrating...",
+		StatusDepositing:             "Depositing...",
+		StatusDeposited:              "Deposited",
+	},
+}
+
+// LoadStatusVocabularies loads the status vocabularies from a JSON file, alongside the built-in emoji and plain vocabularies.
+// Labels missing from a vocabulary fall back to the plain vocabulary, so a translation only needs to list what it changes.
+// A missing path yields the built-in vocabularies only.
+func LoadStatusVocabularies(path string) (StatusVocabularies, error) {
+	vocabularies := StatusVocabularies{}
+	for name, vocabulary := range builtinVocabularies {
+		vocabularies[name] = vocabulary
+	}
+	if path == "" {
+		return vocabularies, nil
+	}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return vocabularies, nil
+		}
+		return nil, fmt.Errorf("reading status vocabularies file: %w", err)
+	}
+	var loaded StatusVocabularies
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("unmarshaling status vocabularies file: %w", err)
+	}
+	plain := builtinVocabularies[PlainVocabulary]
+	for name, vocabulary := range loaded {
+		merged := StatusVocabulary{}
+		for code, label := range plain {
+			merged[code] = label
+		}
+		for code, label := range vocabulary {
+			if _, ok := plain[code]; !ok {
+				return nil, fmt.Errorf("invalid status vocabulary %q: unknown status code %q", name, code)
+			}
+			merged[code] = label
+		}
+		vocabularies[name] = merged
+	}
+	return vocabularies, nil
+}
+
+// Get returns the named vocabulary.
+func (v StatusVocabularies) Get(name string) (StatusVocabulary, error) {
+	vocabulary, ok := v[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown status vocabulary: %q", name)
+	}
+	return vocabulary, nil
+}
+
+// Label returns the display label of a status code, or the code itself if the vocabulary has no label for it.
+func (v StatusVocabulary) Label(code StatusCode) string {
+	if label, ok := v[code]; ok {
+		return label
+	}
+	return string(code)
+}
@@ -37,9 +37,30 @@ type Object struct {
 	ObjectIdentifier        ObjectIdentifier         `xml:"premis:objectIdentifier"`
 	ObjectCharacteristics   ObjectCharacteristics    `xml:"premis:objectCharacteristics"`
 	OriginalName            string                   `xml:"premis:originalName"`
+	Relationships           []Relationship           `xml:"premis:relationship,omitempty"`
 	LinkingEventIdentifiers []LinkingEventIdentifier `xml:"premis:linkingEventIdentifier"`
 }
 
+// Relationship relates an object to other objects, e.g. to the previous version of an AIP.
+type Relationship struct {
+	RelationshipType         string                    `xml:"premis:relationshipType"`
+	RelationshipSubType      string                    `xml:"premis:relationshipSubType"`
+	RelatedObjectIdentifiers []RelatedObjectIdentifier `xml:"premis:relatedObjectIdentifier"`
+	RelatedEventIdentifiers  []RelatedEventIdentifier  `xml:"premis:relatedEventIdentifier,omitempty"`
+}
+
+// RelatedObjectIdentifier identifies the object of a relationship.
+type RelatedObjectIdentifier struct {
+	IdentifierType  string `xml:"premis:relatedObjectIdentifierType"`
+	IdentifierValue string `xml:"premis:relatedObjectIdentifierValue"`
+}
+
+// RelatedEventIdentifier identifies the event that established a relationship.
+type RelatedEventIdentifier struct {
+	IdentifierType  string `xml:"premis:relatedEventIdentifierType"`
+	IdentifierValue string `xml:"premis:relatedEventIdentifierValue"`
+}
+
 // ObjectIdentifier uniquely identifies an object.
 type ObjectIdentifier struct {
 	IdentifierType  string `xml:"premis:objectIdentifierType"`
@@ -48,7 +69,23 @@ type ObjectIdentifier struct {
 
 // ObjectCharacteristics holds technical and descriptive details.
 type ObjectCharacteristics struct {
-	Format Format `xml:"premis:format"`
+	CompositionLevel *CompositionLevel `xml:"premis:compositionLevel,omitempty"`
+	Fixity           []Fixity          `xml:"premis:fixity,omitempty"`
+	Size             *int64            `xml:"premis:size,omitempty"` // Bytes. Unset for objects not measured
+	Format           Format            `xml:"premis:format"`
+}
+
+// CompositionLevel is the number of encodings, e.g. compression or encryption, applied to an object. 0 for plain files.
+type CompositionLevel struct {
+	Level   uint   `xml:",chardata"`
+	Unknown string `xml:"unknown,attr,omitempty"` // "yes" if the level is unknown
+}
+
+// Fixity holds a message digest of an object.
+type Fixity struct {
+	MessageDigestAlgorithm  string `xml:"premis:messageDigestAlgorithm"` // e.g. SHA-256
+	MessageDigest           string `xml:"premis:messageDigest"`
+	MessageDigestOriginator string `xml:"premis:messageDigestOriginator,omitempty"`
 }
 
 // FormatDesignation ...
@@ -73,8 +110,8 @@ type Event struct {
 	EventIdentifier          EventIdentifier           `xml:"premis:eventIdentifier"`
 	EventType                string                    `xml:"premis:eventType"`
 	EventDateTime            string                    `xml:"premis:eventDateTime"`
-	EventDetailInformation   EventDetailInformation    `xml:"premis:eventDetailInformation"`
-	EventOutcomeInformation  EventOutcomeInformation   `xml:"premis:eventOutcomeInformation"`
+	EventDetailInformation   []EventDetailInformation  `xml:"premis:eventDetailInformation,omitempty"`
+	EventOutcomeInformation  []EventOutcomeInformation `xml:"premis:eventOutcomeInformation,omitempty"`
 	LinkingAgentIdentifiers  []LinkingAgentIdentifier  `xml:"premis:linkingAgentIdentifier"`
 	LinkingObjectIdentifiers []LinkingObjectIdentifier `xml:"premis:linkingObjectIdentifier,omitempty"`
 }
@@ -87,13 +124,13 @@ type EventIdentifier struct {
 
 // EventDetailInformation contains details about the event.
 type EventDetailInformation struct {
-	EventDetail string `xml:"premis:eventDetail"`
+	EventDetail string `xml:"premis:eventDetail,omitempty"`
 }
 
-// EventOutcomeInformation contains details about the result of an event.
+// EventOutcomeInformation contains details about the result of an event. Requires an outcome or a detail.
 type EventOutcomeInformation struct {
-	EventOutcome       string             `xml:"premis:eventOutcome"`
-	EventOutcomeDetail EventOutcomeDetail `xml:"premis:eventOutcomeDetail"`
+	EventOutcome        string               `xml:"premis:eventOutcome,omitempty"`
+	EventOutcomeDetails []EventOutcomeDetail `xml:"premis:eventOutcomeDetail,omitempty"`
 }
 
 // EventOutcomeDetail ...
@@ -103,14 +140,16 @@ type EventOutcomeDetail struct {
 
 // LinkingAgentIdentifier links an agent to an event.
 type LinkingAgentIdentifier struct {
-	IdentifierType  string `xml:"premis:linkingAgentIdentifierType"`
-	IdentifierValue string `xml:"premis:linkingAgentIdentifierValue"`
+	IdentifierType  string   `xml:"premis:linkingAgentIdentifierType"`
+	IdentifierValue string   `xml:"premis:linkingAgentIdentifierValue"`
+	Roles           []string `xml:"premis:linkingAgentRole,omitempty"` // e.g. executing program
 }
 
 // LinkingObjectIdentifier links an object to an event.
 type LinkingObjectIdentifier struct {
-	ObjectIdentifierType  string `xml:"premis:linkingObjectIdentifierType"`
-	ObjectIdentifierValue string `xml:"premis:linkingObjectIdentifierValue"`
+	ObjectIdentifierType  string   `xml:"premis:linkingObjectIdentifierType"`
+	ObjectIdentifierValue string   `xml:"premis:linkingObjectIdentifierValue"`
+	Roles                 []string `xml:"premis:linkingObjectRole,omitempty"` // e.g. source or outcome
 }
 
 // Agent represents an entity (person, organization, or software) responsible for events.
@@ -168,15 +207,15 @@ func GetPremis() Premis {
 				},
 				EventType:     "ingestion",
 				EventDateTime: "2025-03-11T12:34:56Z",
-				EventDetailInformation: EventDetailInformation{
+				EventDetailInformation: []EventDetailInformation{{
 					EventDetail: "Object ingested successfully.",
-				},
-				EventOutcomeInformation: EventOutcomeInformation{
+				}},
+				EventOutcomeInformation: []EventOutcomeInformation{{
 					EventOutcome: "success",
-					EventOutcomeDetail: EventOutcomeDetail{
+					EventOutcomeDetails: []EventOutcomeDetail{{
 						EventOutcomeDetailNote: "Object ingested successfully.",
-					},
-				},
+					}},
+				}},
 				LinkingAgentIdentifiers: []LinkingAgentIdentifier{
 					{
 						IdentifierType:  "UUID",
@@ -205,15 +244,15 @@ func GetPremis() Premis {
 				},
 				EventType:     "ingestion",
 				EventDateTime: "2025-03-11T12:34:56Z",
-				EventDetailInformation: EventDetailInformation{
+				EventDetailInformation: []EventDetailInformation{{
 					EventDetail: "Object ingested successfully.",
-				},
-				EventOutcomeInformation: EventOutcomeInformation{
+				}},
+				EventOutcomeInformation: []EventOutcomeInformation{{
 					EventOutcome: "success",
-					EventOutcomeDetail: EventOutcomeDetail{
+					EventOutcomeDetails: []EventOutcomeDetail{{
 						EventOutcomeDetailNote: "Object ingested successfully.",
-					},
-				},
+					}},
+				}},
 				LinkingAgentIdentifiers: []LinkingAgentIdentifier{
 					{
 						IdentifierType:  "UUID",
@@ -277,18 +316,35 @@ func WritePremis(premisRecord Premis, filePath string) error {
 
 // ValidatePremis validates the PREMIS metadata against the schema.
 func ValidatePremis(premisRecord Premis) error {
-	// Marshal the PREMIS record to XML
-	xmlData, err := xml.Marshal(premisRecord)
+	validator, err := NewValidator()
 	if err != nil {
-		return fmt.Errorf("error marshaling PREMIS record: %w", err)
+		return err
 	}
+	defer validator.Free()
+	return validator.Validate(premisRecord)
+}
 
-	// Parse the XML schema from the embedded data
+// Validator validates PREMIS records against the schema, which is parsed once for every record validated.
+type Validator struct {
+	schema *xsd.Schema
+}
+
+// NewValidator parses the embedded schema. Free the validator once done.
+func NewValidator() (*Validator, error) {
 	schema, err := xsd.Parse(premisSchema)
 	if err != nil {
-		return fmt.Errorf("error parsing embedded XML schema: %w", err)
+		return nil, fmt.Errorf("error parsing embedded XML schema: %w", err)
+	}
+	return &Validator{schema: schema}, nil
+}
+
+// Validate validates the PREMIS record against the schema.
+func (v *Validator) Validate(premisRecord Premis) error {
+	// Marshal the PREMIS record to XML
+	xmlData, err := xml.Marshal(premisRecord)
+	if err != nil {
+		return fmt.Errorf("error marshaling PREMIS record: %w", err)
 	}
-	defer schema.Free()
 
 	// Parse the XML
 	doc, err := libxml2.Parse(xmlData)
@@ -298,9 +354,14 @@ func ValidatePremis(premisRecord Premis) error {
 	defer doc.Free()
 
 	// Validate the XML document against the schema
-	if err := schema.Validate(doc); err != nil {
+	if err := v.schema.Validate(doc); err != nil {
 		return fmt.Errorf("error validating XML document against schema: %w", err)
 	}
 
 	return nil
 }
+
+// Free releases the parsed schema.
+func (v *Validator) Free() {
+	v.schema.Free()
+}
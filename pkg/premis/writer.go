@@ -0,0 +1,181 @@
+package premis
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/penwern/curate-preservation-core/pkg/logger"
+)
+
+// Writer streams a PREMIS record to a file, so records with many objects are never held in memory.
+// The schema orders every object before any event, so events are buffered in a file next to the record until the record is closed.
+// The buffer stays in the transfer's metadata directory, on the same volume as the record, rather than in the system temp directory.
+// The file is only created once an object is added.
+type Writer struct {
+	path      string
+	root      Premis // Record attributes and agents. Objects and events are ignored
+	validator *Validator
+
+	file    *os.File
+	out     *bufio.Writer
+	events  *os.File
+	eventsW *bufio.Writer
+	objects int
+}
+
+// NewWriter creates a writer for the PREMIS record at filePath.
+// The root holds the record attributes and the agents, written once the record is closed.
+func NewWriter(filePath string, root Premis) (*Writer, error) {
+	validator, err := NewValidator()
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{path: filePath, root: root, validator: validator}, nil
+}
+
+// Add validates an object and its events against the schema, and writes them.
+func (w *Writer) Add(object Object, events []Event) error {
+	record := w.root
+	record.Objects = []Object{object}
+	record.Events = events
+	if err := w.validator.Validate(record); err != nil {
+		return fmt.Errorf("invalid PREMIS object %s: %w", object.ObjectIdentifier.IdentifierValue, err)
+	}
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if err := writeElement(w.out, "premis:object", object); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := writeElement(w.eventsW, "premis:event", event); err != nil {
+			return err
+		}
+	}
+	w.objects++
+	return nil
+}
+
+// Objects returns the number of objects written.
+func (w *Writer) Objects() int {
+	return w.objects
+}
+
+// Close writes the buffered events and the agents, and completes the record.
+// No file is written if no object was added.
+func (w *Writer) Close() error {
+	defer w.Discard()
+	if w.file == nil {
+		return nil
+	}
+
+	if err := w.eventsW.Flush(); err != nil {
+		return fmt.Errorf("error buffering PREMIS events: %w", err)
+	}
+	if _, err := w.events.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading PREMIS events: %w", err)
+	}
+	if _, err := io.Copy(w.out, w.events); err != nil {
+		return fmt.Errorf("error writing PREMIS events: %w", err)
+	}
+	for _, agent := range w.root.Agents {
+		if err := writeElement(w.out, "premis:agent", agent); err != nil {
+			return err
+		}
+	}
+	if _, err := w.out.WriteString("\n</premis:premis>"); err != nil {
+		return fmt.Errorf("error writing PREMIS XML to file: %w", err)
+	}
+	if err := w.out.Flush(); err != nil {
+		return fmt.Errorf("error writing PREMIS XML to file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error writing PREMIS XML to file: %w", err)
+	}
+	w.file = nil
+	logger.Debug("PREMIS XML written to file: %s, %d objects", w.path, w.objects)
+	return nil
+}
+
+// Discard removes the events buffer and any incomplete record. A no-op once the writer is closed.
+func (w *Writer) Discard() {
+	if w.events != nil {
+		_ = w.events.Close()
+		if err := os.Remove(w.events.Name()); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove PREMIS events buffer %s: %v", w.events.Name(), err)
+		}
+		w.events = nil
+	}
+	if w.file != nil {
+		_ = w.file.Close()
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove incomplete PREMIS XML %s: %v", w.path, err)
+		}
+		w.file = nil
+	}
+	if w.validator != nil {
+		w.validator.Free()
+		w.validator = nil
+	}
+}
+
+// open creates the record file, writes the root element start tag, and creates the events buffer.
+func (w *Writer) open() error {
+	file, err := os.OpenFile(filepath.Clean(w.path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating PREMIS XML file: %w", err)
+	}
+	w.file = file
+	w.out = bufio.NewWriter(file)
+
+	events, err := os.CreateTemp(filepath.Dir(w.path), ".premis-events-*.xml")
+	if err != nil {
+		return fmt.Errorf("error creating PREMIS events buffer: %w", err)
+	}
+	w.events = events
+	w.eventsW = bufio.NewWriter(events)
+
+	if _, err := w.out.WriteString(xml.Header); err != nil {
+		return fmt.Errorf("error writing PREMIS XML to file: %w", err)
+	}
+	enc := xml.NewEncoder(w.out)
+	start := xml.StartElement{
+		Name: xml.Name{Local: "premis:premis"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:premis"}, Value: w.root.XMLNS},
+			{Name: xml.Name{Local: "xmlns:xsi"}, Value: w.root.XSI},
+			{Name: xml.Name{Local: "version"}, Value: w.root.Version},
+			{Name: xml.Name{Local: "xsi:schemaLocation"}, Value: w.root.Schema},
+		},
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return fmt.Errorf("error writing PREMIS XML to file: %w", err)
+	}
+	if err := enc.Flush(); err != nil {
+		return fmt.Errorf("error writing PREMIS XML to file: %w", err)
+	}
+	return nil
+}
+
+// writeElement writes an element of the root element on a new line, indented as WritePremis does.
+func writeElement(out io.Writer, name string, v any) error {
+	if _, err := io.WriteString(out, "\n"); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("    ", "    ")
+	if err := enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+		return fmt.Errorf("error marshaling %s: %w", name, err)
+	}
+	if err := enc.Flush(); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return nil
+}